@@ -0,0 +1,159 @@
+package formaldehyd
+
+import (
+	"strings"
+)
+
+// Radio buttons that sit together are one question with several
+// answers:
+//
+//	Color
+//	Red   ( )
+//	Green (*)
+//	Blue  ( )
+//
+//	Size  S ( ) M ( ) L ( )
+//
+// Radios on the same line, or on consecutive lines, become a single
+// choice field whose options are the radios' labels. A line of text
+// right above the first one is the question; without one, if the
+// next radio is on the same line, the words before the first radio's
+// last word are (so the question above is "Size", with options S, M
+// and L). At most one option can be selected to start with. A #tag
+// in front of the question or any of the radios, or an {attribute
+// block} on any of them, applies to the whole choice, and so does
+// ? help under the last one. A radio on its own is still a yes-or-no
+// field.
+
+// groupChoices replaces runs of radio buttons with choice fields.
+func groupChoices(n *Node) (errs ParseErrors) {
+	kids := []*Node{}
+
+	for i := 0; i < len(n.Children); i++ {
+		kid := n.Children[i]
+
+		if kid.Kind == NPage || kid.Kind == NRepeat {
+			errs = append(errs, groupChoices(kid)...)
+		}
+
+		j := i + 1
+		for kid.Kind == NRadioField && j < len(n.Children) {
+			next, prev := n.Children[j], n.Children[j-1]
+			if next.Kind != NRadioField || next.Opt != kid.Opt || next.Line-prev.Line > 1 {
+				break
+			}
+			j++
+		}
+
+		if j-i < 2 {
+			kids = append(kids, kid)
+			continue
+		}
+
+		group := &Node{
+			Kind:   NChoiceField,
+			Parent: n,
+			Line:   kid.Line,
+			Col:    kid.Col,
+			Opt:    kid.Opt,
+			Attrs:  map[string]string{"label": ""},
+		}
+
+		if last := len(kids) - 1; last >= 0 && kids[last].above {
+			q := kids[last]
+			group.Attrs["label"] = q.Text
+			group.Hash = q.Hash
+			group.Line = q.Line
+			group.Col = q.Col
+			kids = kids[:last]
+		}
+
+		// Size S ( ) M ( ) L ( ): the question is on the line with
+		// the options, in front of the first one
+		first := n.Children[i]
+		label := first.Attrs["label"]
+		if group.Attrs["label"] == "" && n.Children[i+1].Line == first.Line {
+			if sp := strings.LastIndex(label, " "); sp > 0 {
+				group.Attrs["label"] = label[:sp]
+				label = label[sp+1:]
+			}
+		}
+
+		seen := map[string]bool{}
+		selected := ""
+
+		for _, r := range n.Children[i:j] {
+			text := r.Attrs["label"]
+			if r == first {
+				text = label
+			}
+
+			opt := &Node{
+				Kind:   NSelection,
+				Text:   text,
+				Parent: group,
+				Line:   r.Line,
+				Col:    r.Col,
+				Opt:    r.Opt,
+				Attrs:  map[string]string{},
+			}
+
+			if seen[opt.Text] {
+				errs = append(errs, nodeError(r, "\"%s\" is already an option", opt.Text))
+				continue
+			}
+			seen[opt.Text] = true
+
+			if r.Attrs["selected"] == "t" {
+				if selected != "" {
+					errs = append(errs, nodeError(r, "only one option can be selected; \"%s\" already is", selected))
+				} else {
+					selected = opt.Text
+					opt.Attrs["selected"] = "t"
+				}
+			}
+
+			if r.Hash != "" {
+				if group.Hash != "" && group.Hash != r.Hash {
+					errs = append(errs, nodeError(r, "this choice is already tagged #%s", group.Hash))
+				} else {
+					group.Hash = r.Hash
+				}
+			}
+
+			if r.Attrs["required"] == "t" {
+				group.Attrs["required"] = "t"
+			}
+
+			group.Children = append(group.Children, opt)
+		}
+
+		// help under a radio in the middle would have split the run,
+		// so the only help the choice can have is the last radio's
+		if h := n.Children[j-1].Attrs["help"]; h != "" {
+			group.Attrs["help"] = h
+		}
+
+		kids = append(kids, group)
+		i = j - 1
+	}
+
+	// a line of text above something other than a choice goes back
+	// to being part of the text before it
+	n.Children = nil
+	for _, kid := range kids {
+		if kid.above {
+			kid.above = false
+
+			last := len(n.Children) - 1
+			if last >= 0 && n.Children[last].Kind == NText && n.Children[last].Opt == kid.Opt && kid.Hash == "" {
+				n.Children[last].Text += " " + kid.Text
+				continue
+			}
+		}
+
+		n.Children = append(n.Children, kid)
+	}
+
+	return errs
+}
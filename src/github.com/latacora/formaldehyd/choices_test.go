@@ -0,0 +1,105 @@
+package formaldehyd
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestChoices(t *testing.T) {
+	n, err := Parse([]byte(`
+About you
+---------
+
+Some words about this page.
+Favorite color
+Red   ( )
+Green (*)
+Blue  ( ) {required}
+
+#size
+Size S ( ) M ( ) L ( )
+
+Do you agree?
+Yes ( )
+
+Other ( )
+`))
+	ok(t, err)
+
+	kids := n.Children[0].Children
+
+	kinds := []string{}
+	for _, k := range kids {
+		kinds = append(kinds, nodeNames[k.Kind])
+	}
+
+	if strings.Join(kinds, ",") != "Text,ChoiceField,ChoiceField,Text,RadioField,RadioField" {
+		t.Fatalf("unexpected nodes: %v\n%s", kinds, n)
+	}
+
+	if kids[0].Text != "Some words about this page." {
+		t.Errorf("unexpected text: %s", kids[0].Text)
+	}
+
+	color := kids[1]
+	if color.ID != "about-you.favorite-color" || color.Attrs["required"] != "t" || len(color.Children) != 3 {
+		t.Errorf("unexpected choice: %s", color)
+	}
+
+	if c := color.Children[1]; c.Text != "Green" || c.Attrs["selected"] != "t" {
+		t.Errorf("expected Green to be selected: %s", c)
+	}
+
+	size := kids[2]
+	if size.ID != "size" || size.Attrs["label"] != "Size" || len(size.Children) != 3 {
+		t.Errorf("unexpected choice: %s", size)
+	}
+
+	for i, want := range []string{"S", "M", "L"} {
+		if c := size.Children[i]; c.Text != want {
+			t.Errorf("expected option %s, got %s", want, c.Text)
+		}
+	}
+
+	// a lone radio doesn't take the text above it
+	if kids[3].Text != "Do you agree?" || kids[4].Attrs["label"] != "Yes" {
+		t.Errorf("unexpected lone radio: %s", n)
+	}
+
+	for answer, bad := range map[string]bool{"Red": false, "Purple": true} {
+		errs := n.Validate(Answers{"about-you.favorite-color": answer})
+		if (len(errs) != 0) != bad {
+			t.Errorf("%s: unexpected errors %v", answer, errs)
+		}
+	}
+
+	if errs := n.Validate(Answers{}); len(errs) != 1 || errs[0].Field != "about-you.favorite-color" {
+		t.Errorf("expected the choice to be required, got %v", errs)
+	}
+
+	if !strings.Contains(n.JSON(), `"default": "Green"`) {
+		t.Errorf("expected the default in the JSON")
+	}
+
+	roundTrip(t, Format(n))
+
+	m, err := FromJSON([]byte(n.JSON()))
+	ok(t, err)
+
+	if !Equivalent(n, m) {
+		t.Errorf("choices don't survive JSON:\n%s", m)
+	}
+}
+
+func TestBadChoices(t *testing.T) {
+	_, err := Parse([]byte(`
+Pick
+A (*)
+B (*)
+A ( )
+`))
+	if err == nil || !strings.Contains(err.Error(), "only one option can be selected") ||
+		!strings.Contains(err.Error(), `"A" is already an option`) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/latacora/formaldehyd"
+)
+
+func check(args []string) int {
+	fs := flag.NewFlagSet("check", flag.ExitOnError)
+	strict := fs.Bool("strict", false, "fail on warnings too")
+	fs.Parse(args)
+
+	status := 0
+
+	for _, path := range files(fs.Args()) {
+		s, err := load(path)
+		if err != nil {
+			status = fail(path, err)
+			continue
+		}
+
+		if s.report() || (*strict && len(s.errs) > 0) {
+			status = 1
+		}
+	}
+
+	return status
+}
+
+func fmtCmd(args []string) int {
+	fs := flag.NewFlagSet("fmt", flag.ExitOnError)
+	list := fs.Bool("l", false, "list files that aren't formatted, and fail if there are any")
+	write := fs.Bool("w", false, "rewrite files in place")
+	fs.Parse(args)
+
+	status := 0
+
+	for _, path := range files(fs.Args()) {
+		s, err := load(path)
+		if err != nil {
+			status = fail(path, err)
+			continue
+		}
+
+		// formatting drops whatever the parser skipped over, so a
+		// file with so much as a warning is left as it is
+		if s.report() || len(s.errs) > 0 {
+			status = 1
+			continue
+		}
+
+		out := formaldehyd.Format(s.root)
+		changed := !bytes.Equal(out, s.buf)
+
+		if *list && changed {
+			fmt.Println(s.name())
+			status = 1
+		}
+
+		switch {
+		case *write && s.path == stdin:
+			os.Stdout.Write(out)
+
+		case *write && s.isJSON():
+			status = fail(path, fmt.Errorf("won't overwrite JSON with form source"))
+
+		case *write && changed:
+			if err := rewrite(path, out); err != nil {
+				status = fail(path, err)
+			}
+
+		case !*write && !*list:
+			os.Stdout.Write(out)
+		}
+	}
+
+	return status
+}
+
+// rewrite replaces the contents of the file at path, keeping its mode.
+func rewrite(path string, buf []byte) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(path, buf, info.Mode().Perm())
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/latacora/formaldehyd"
+)
+
+func gen(args []string) int {
+	fs := flag.NewFlagSet("gen", flag.ExitOnError)
+	pkg := fs.String("pkg", "main", "package of the generated file")
+	typ := fs.String("type", "", "name of the submission struct (default: from the file name)")
+	out := fs.String("o", "", "file to write (default: standard output)")
+	fs.Parse(args)
+
+	s := one(fs.Args())
+	if s == nil {
+		return 1
+	}
+
+	opts := &formaldehyd.GoOptions{
+		Package: *pkg,
+		Type:    *typ,
+	}
+
+	if s.path != stdin {
+		opts.Source = filepath.Base(s.path)
+	}
+
+	src, err := s.root.GoSource(opts)
+	if err != nil {
+		return fail(s.name(), err)
+	}
+
+	if *out == "" {
+		os.Stdout.Write(src)
+		return 0
+	}
+
+	if err := ioutil.WriteFile(*out, src, 0644); err != nil {
+		return fail(*out, err)
+	}
+
+	return 0
+}
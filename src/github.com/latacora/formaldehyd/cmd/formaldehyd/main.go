@@ -0,0 +1,206 @@
+// Command formaldehyd works with form files from the command line.
+//
+//	formaldehyd check [-strict] [file ...]
+//	formaldehyd fmt [-l] [-w] [file ...]
+//	formaldehyd ast [file]
+//	formaldehyd json [-catalog file] [file]
+//	formaldehyd html [-title title] [-page id] [-catalog file] [-lang locale] [file]
+//	formaldehyd gen [-pkg name] [-type name] [-o file] [file]
+//	formaldehyd messages [-merge file] [file]
+//
+// Files ending in .json are read as the JSON that formaldehyd json
+// writes; anything else is form source. Without a file, or given "-",
+// the form is read from standard input.
+//
+// check parses forms and reports every problem in them, with where it
+// is. fmt prints forms laid out canonically; -w rewrites the files
+// instead, and -l lists the ones that aren't laid out that way. It
+// won't touch a form with problems, warnings included. ast
+// dumps the parsed tree, json and html render the form (translated,
+// with -catalog), and gen writes Go source for handling submissions to
+// it (see formaldehyd.Node.GoSource). messages writes a catalog of
+// every string in the form for translators to start from; -merge
+// brings an existing translation up to date with the form instead.
+//
+// The exit status is 1 if a form doesn't parse (or, for check -strict
+// and fmt, has warnings, or for fmt -l, isn't formatted), and 2 for
+// bad usage, so the commands can gate CI.
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/latacora/formaldehyd"
+)
+
+// A command is one subcommand; it gets the arguments after its name,
+// and returns the exit status.
+type command struct {
+	usage string
+	run   func(args []string) int
+}
+
+var commands = map[string]*command{
+	"check":    {"[-strict] [file ...]", check},
+	"fmt":      {"[-l] [-w] [file ...]", fmtCmd},
+	"ast":      {"[file]", ast},
+	"json":     {"[-catalog file] [file]", jsonCmd},
+	"html":     {"[-title title] [-page id] [-catalog file] [-lang locale] [file]", html},
+	"gen":      {"[-pkg name] [-type name] [-o file] [file]", gen},
+	"messages": {"[-merge file] [file]", messages},
+}
+
+func usage() {
+	names := []string{}
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Fprintf(os.Stderr, "usage:\n")
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  formaldehyd %s %s\n", name, commands[name].usage)
+	}
+}
+
+// stdin is the name standard input goes by, as an argument and in
+// messages.
+const stdin = "-"
+
+// files is the files a command was given, or standard input.
+func files(args []string) []string {
+	if len(args) == 0 {
+		return []string{stdin}
+	}
+
+	return args
+}
+
+// A source is one form file, read and parsed.
+type source struct {
+	path string
+	buf  []byte
+	root *formaldehyd.Node
+
+	// errs is every problem the parser found, warnings included
+	errs formaldehyd.ParseErrors
+}
+
+func (s *source) name() string {
+	if s.path == stdin {
+		return "<stdin>"
+	}
+
+	return s.path
+}
+
+func (s *source) isJSON() bool {
+	return strings.HasSuffix(s.path, ".json")
+}
+
+// load reads and parses a form file, JSON or source. An error is a
+// file that can't be read or decoded; problems with the form itself
+// are in errs.
+func load(path string) (*source, error) {
+	s := &source{path: path}
+
+	var err error
+	if path == stdin {
+		s.buf, err = ioutil.ReadAll(os.Stdin)
+	} else {
+		s.buf, err = ioutil.ReadFile(path)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !s.isJSON() {
+		s.root, s.errs = formaldehyd.ParseAll(s.buf)
+		return s, nil
+	}
+
+	s.root, err = formaldehyd.FromJSON(s.buf)
+	if errs, ok := err.(formaldehyd.ParseErrors); ok {
+		s.errs = errs
+	} else if err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// report prints the problems with a form, compiler style, and says
+// whether any of them were errors.
+func (s *source) report() bool {
+	for _, e := range s.errs {
+		pos := s.name()
+		if e.Line > 0 {
+			pos += fmt.Sprintf(":%d", e.Line)
+		}
+		if e.Column > 0 {
+			pos += fmt.Sprintf(":%d", e.Column)
+		}
+
+		// the message, excerpt and caret, without the position
+		c := *e
+		c.Line, c.Warning = 0, false
+		msg := c.Error()
+
+		if e.Warning {
+			msg = "warning: " + msg
+		}
+
+		fmt.Fprintf(os.Stderr, "%s: %s\n", pos, msg)
+	}
+
+	return len(s.errs.Errors()) > 0
+}
+
+// fail reports an error on the way out.
+func fail(path string, err error) int {
+	fmt.Fprintf(os.Stderr, "%s: %s\n", path, err)
+	return 1
+}
+
+// one loads the single form a command works on, reporting any
+// problems with it; it's nil if the form can't be used.
+func one(args []string) *source {
+	if len(args) > 1 {
+		fmt.Fprintf(os.Stderr, "expected one file, got %d\n", len(args))
+		os.Exit(2)
+	}
+
+	path := files(args)[0]
+
+	s, err := load(path)
+	if err != nil {
+		fail(path, err)
+		return nil
+	}
+
+	if s.report() {
+		return nil
+	}
+
+	return s
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+
+	cmd, ok := commands[os.Args[1]]
+	if !ok {
+		usage()
+		os.Exit(2)
+	}
+
+	os.Exit(cmd.run(os.Args[2:]))
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// The tests run the command by running the test binary again, with
+// FORMALDEHYD_MAIN set so it runs main instead, since the commands
+// exit on their own.
+func TestMain(m *testing.M) {
+	if os.Getenv("FORMALDEHYD_MAIN") != "" {
+		os.Args = append([]string{"formaldehyd"}, os.Args[1:]...)
+		main()
+		os.Exit(0)
+	}
+
+	os.Exit(m.Run())
+}
+
+// runCmd runs the command with args and input on standard input,
+// returning what it printed and its exit status.
+func runCmd(t *testing.T, input string, args ...string) (stdout, stderr string, status int) {
+	t.Helper()
+
+	cmd := exec.Command(os.Args[0], args...)
+	cmd.Env = append(os.Environ(), "FORMALDEHYD_MAIN=1")
+	cmd.Stdin = strings.NewReader(input)
+
+	out, errOut := &bytes.Buffer{}, &bytes.Buffer{}
+	cmd.Stdout, cmd.Stderr = out, errOut
+
+	err := cmd.Run()
+	if e, ok := err.(*exec.ExitError); ok {
+		status = e.ExitCode()
+	} else if err != nil {
+		t.Fatal(err)
+	}
+
+	return out.String(), errOut.String(), status
+}
+
+const (
+	pages     = "../../fixtures/pages.form"
+	badSyntax = "../../fixtures/bad-syntax.form"
+)
+
+func TestExitStatus(t *testing.T) {
+	for _, c := range []struct {
+		input  string
+		args   []string
+		status int
+	}{
+		{"", nil, 2},
+		{"", []string{"nope"}, 2},
+
+		{"", []string{"check", pages}, 0},
+		{"", []string{"check", pages, badSyntax}, 1},
+		{"", []string{"check", "no-such-file.form"}, 1},
+		{"Name [    ]\n", []string{"check"}, 0},
+		{"", []string{"check", "-nope"}, 2},
+
+		{"Name  [    ]\n", []string{"fmt", "-l"}, 1},
+		{"Name [    ]\n", []string{"fmt", "-l"}, 0},
+		{"Name [    ] =\n", []string{"fmt"}, 1},
+
+		{"", []string{"ast", pages}, 0},
+		{"", []string{"ast", "-h"}, 0},
+		{"", []string{"ast", "-nope", pages}, 2},
+		{"", []string{"ast", pages, pages}, 2},
+		{"", []string{"ast", badSyntax}, 1},
+
+		{"", []string{"json", "-h"}, 0},
+		{"", []string{"json", "-nope", pages}, 2},
+
+		{"", []string{"html", "-page", "page-2", pages}, 0},
+		{"", []string{"html", "-page", "nope", pages}, 1},
+
+		{"", []string{"gen", pages}, 0},
+		{"", []string{"messages", pages}, 0},
+	} {
+		if _, stderr, status := runCmd(t, c.input, c.args...); status != c.status {
+			t.Errorf("%v: expected exit status %d, got %d\n%s", c.args, c.status, status, stderr)
+		}
+	}
+}
+
+func TestOutput(t *testing.T) {
+	stdout, _, _ := runCmd(t, "Name  [    ]\n", "fmt")
+	if stdout != "Name [    ]\n" {
+		t.Errorf("expected the form laid out, got %q", stdout)
+	}
+
+	stdout, _, _ = runCmd(t, "", "html", "-page", "page-2", pages)
+	if !strings.Contains(stdout, "<legend>Page 2</legend>") || strings.Contains(stdout, "<legend>Page 1</legend>") {
+		t.Errorf("expected just page 2, got\n%s", stdout)
+	}
+
+	_, stderr, _ := runCmd(t, "", "html", "-page", "nope", pages)
+	if !strings.Contains(stderr, `no page "nope"`) {
+		t.Errorf("expected an error about the page, got %q", stderr)
+	}
+
+	_, stderr, _ = runCmd(t, "", "check", badSyntax)
+	if !strings.HasPrefix(stderr, badSyntax+":") {
+		t.Errorf("expected errors with where they are, got %q", stderr)
+	}
+}
+
+func TestFmtWrite(t *testing.T) {
+	dir, err := ioutil.TempDir("", "formaldehyd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	messy, warned := filepath.Join(dir, "messy.form"), filepath.Join(dir, "warned.form")
+	ioutil.WriteFile(messy, []byte("Name  [    ]\n"), 0600)
+	ioutil.WriteFile(warned, []byte("Name  [    ] =\n"), 0644)
+
+	// a file with warnings isn't listed as merely unformatted
+	if stdout, stderr, status := runCmd(t, "", "fmt", "-l", warned); status != 1 || stdout != "" || !strings.Contains(stderr, "warning") {
+		t.Errorf("expected just the warning, got %d, %q, %q", status, stdout, stderr)
+	}
+
+	if _, stderr, status := runCmd(t, "", "fmt", "-w", messy, warned); status != 1 {
+		t.Errorf("expected to fail on the warning, got %d\n%s", status, stderr)
+	}
+
+	if buf, _ := ioutil.ReadFile(messy); string(buf) != "Name [    ]\n" {
+		t.Errorf("expected the file rewritten, got %q", buf)
+	}
+
+	if info, err := os.Stat(messy); err != nil {
+		t.Error(err)
+	} else if info.Mode().Perm() != 0600 {
+		t.Errorf("expected the file to keep its mode, got %v", info.Mode())
+	}
+
+	if buf, _ := ioutil.ReadFile(warned); string(buf) != "Name  [    ] =\n" {
+		t.Errorf("expected the file with a warning left alone, got %q", buf)
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/latacora/formaldehyd"
+)
+
+// loadCatalog reads a catalog file; "" is no catalog at all.
+func loadCatalog(path string) (formaldehyd.Catalog, error) {
+	if path == "" {
+		return nil, nil
+	}
+
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	c := formaldehyd.Catalog{}
+	if err := json.Unmarshal(buf, &c); err != nil {
+		return nil, fmt.Errorf("can't decode catalog: %s", err)
+	}
+
+	return c, nil
+}
+
+// localized loads the single form a command works on, translated with
+// the catalog at path, if there is one.
+func localized(args []string, path string) (*source, *formaldehyd.Node) {
+	s := one(args)
+	if s == nil {
+		return nil, nil
+	}
+
+	c, err := loadCatalog(path)
+	if err != nil {
+		fail(path, err)
+		return nil, nil
+	}
+
+	if c == nil {
+		return s, s.root
+	}
+
+	return s, s.root.Localize(c)
+}
+
+func messages(args []string) int {
+	fs := flag.NewFlagSet("messages", flag.ExitOnError)
+	merge := fs.String("merge", "", "catalog to bring up to date with the form")
+	fs.Parse(args)
+
+	s := one(fs.Args())
+	if s == nil {
+		return 1
+	}
+
+	c := s.root.Messages()
+
+	if *merge != "" {
+		old, err := loadCatalog(*merge)
+		if err != nil {
+			return fail(*merge, err)
+		}
+
+		var stale []string
+		c, stale = old.Merge(c)
+
+		for _, key := range stale {
+			fmt.Fprintf(os.Stderr, "%s: dropping %s, which isn't in the form anymore\n", *merge, key)
+		}
+	}
+
+	buf, _ := json.MarshalIndent(c, "", "  ")
+	fmt.Printf("%s\n", buf)
+	return 0
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/latacora/formaldehyd"
+)
+
+func ast(args []string) int {
+	fs := flag.NewFlagSet("ast", flag.ExitOnError)
+	fs.Parse(args)
+
+	s := one(fs.Args())
+	if s == nil {
+		return 1
+	}
+
+	fmt.Print(s.root.String())
+	return 0
+}
+
+func jsonCmd(args []string) int {
+	fs := flag.NewFlagSet("json", flag.ExitOnError)
+	catalog := fs.String("catalog", "", "catalog to translate the form with")
+	fs.Parse(args)
+
+	s, root := localized(fs.Args(), *catalog)
+	if s == nil {
+		return 1
+	}
+
+	fmt.Println(root.JSON())
+	return 0
+}
+
+func html(args []string) int {
+	fs := flag.NewFlagSet("html", flag.ExitOnError)
+	title := fs.String("title", "", "title of the page")
+	page := fs.String("page", "", "ID of the one page of the form to show")
+	catalog := fs.String("catalog", "", "catalog to translate the form with")
+	lang := fs.String("lang", "", "language the catalog is in")
+	fs.Parse(args)
+
+	s, root := localized(fs.Args(), *catalog)
+	if s == nil {
+		return 1
+	}
+
+	err := root.HTML(os.Stdout, &formaldehyd.HTMLOptions{
+		Title: *title,
+		Page:  *page,
+		Lang:  *lang,
+	})
+	if err != nil {
+		return fail(s.name(), err)
+	}
+
+	return 0
+}
@@ -0,0 +1,866 @@
+package formaldehyd
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// A computed field isn't answered; its value is worked out from the
+// answers to other fields, and it's shown read-only:
+//
+//	Price    [    +/-]
+//	Quantity [    +/-]
+//	Rush     [ ]
+//	Total    [= price * quantity + (if rush then 25 else 0) ]
+//
+// Fields are named by ID. The expression language is small:
+//
+//	numbers, "text", true and false
+//	+ - * / %                  arithmetic; a checkbox counts as 1 or 0
+//	= != < <= > >=             comparisons
+//	and or not                 logic; anything answered counts as true
+//	if c then a else b         a and b have to be the same type
+//	min(a, b, ...) max(a, b, ...) round(a) round(a, places)
+//
+// In text, \" is a quote and \\ a backslash. A - between two names
+// has to have spaces around it, since IDs have dashes in them.
+// Expressions are checked when the form is parsed: every name has to
+// be a field, the types have to line up, and computed fields can't
+// depend on themselves, directly or not.
+//
+// A field that wasn't answered (or is hidden) counts as 0, false or
+// "", so that totals add up as a form is filled in. A value that can't
+// be worked out, like a division by zero, leaves the computed field
+// (and anything computed from it) without an answer.
+
+// An Expr is a parsed expression. Op is the operator, or "num", "str",
+// "bool" or "field" for a value; it's emitted in the JSON as is, for
+// clients that want to keep a total up to date as the form is filled
+// in.
+type Expr struct {
+	Op    string      `json:"op"`
+	Field string      `json:"field,omitempty"`
+	Value interface{} `json:"value,omitempty"`
+	Args  []*Expr     `json:"args,omitempty"`
+}
+
+// precedence of each operator, loosest first; values and function
+// calls bind tightest
+var precedence = map[string]int{
+	"if":  1,
+	"or":  2,
+	"and": 3,
+	"not": 4,
+	"=":   5, "!=": 5, "<": 5, "<=": 5, ">": 5, ">=": 5,
+	"+": 6, "-": 6,
+	"*": 7, "/": 7, "%": 7,
+	"neg": 8,
+}
+
+var functions = map[string]bool{"min": true, "max": true, "round": true}
+
+// exprQuote and exprUnquote escape and unescape a "string" in an
+// expression, or in an attribute block.
+var (
+	exprQuote   = strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+	exprUnquote = strings.NewReplacer(`\\`, `\`, `\"`, `"`)
+)
+
+var keywords = map[string]bool{
+	"if": true, "then": true, "else": true,
+	"and": true, "or": true, "not": true,
+	"true": true, "false": true,
+}
+
+func (e *Expr) prec() int {
+	if p, ok := precedence[e.Op]; ok {
+		return p
+	}
+
+	return 9
+}
+
+// String writes the expression back out, with only the parentheses
+// it needs.
+func (e *Expr) String() string {
+	// wrap an operand if it binds looser than its operator; on the
+	// right, an equal one needs wrapping too, since everything
+	// groups to the left
+	arg := func(i int, right bool) string {
+		a := e.Args[i]
+		if a.prec() < e.prec() || (right && a.prec() == e.prec()) {
+			return "(" + a.String() + ")"
+		}
+		return a.String()
+	}
+
+	switch e.Op {
+	case "num":
+		return strconv.FormatFloat(e.Value.(float64), 'f', -1, 64)
+	case "str":
+		return `"` + exprQuote.Replace(e.Value.(string)) + `"`
+	case "bool":
+		return strconv.FormatBool(e.Value.(bool))
+	case "field":
+		return e.Field
+	case "neg":
+		return "-" + arg(0, false)
+	case "not":
+		return "not " + arg(0, false)
+	case "if":
+		return "if " + e.Args[0].String() + " then " + e.Args[1].String() + " else " + e.Args[2].String()
+	}
+
+	if functions[e.Op] {
+		args := []string{}
+		for _, a := range e.Args {
+			args = append(args, a.String())
+		}
+		return e.Op + "(" + strings.Join(args, ", ") + ")"
+	}
+
+	// the comparisons don't chain, so both sides of one need
+	// wrapping if they're comparisons themselves
+	return arg(0, precedence[e.Op] == 5) + " " + e.Op + " " + arg(1, true)
+}
+
+// exprParser is a recursive descent parser over the tokens of an
+// expression.
+type exprParser struct {
+	src  string
+	toks []string
+	off  int
+}
+
+// lexExpr breaks an expression into names, numbers, "strings" and
+// operators.
+func lexExpr(src string) (ret []string, err error) {
+	rs := []rune(src)
+
+	name := func(r rune) bool {
+		return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_' || r == '.' || r == '-'
+	}
+
+	for i := 0; i < len(rs); {
+		r := rs[i]
+		start := i
+
+		switch {
+		case unicode.IsSpace(r):
+			i++
+			continue
+
+		case unicode.IsLetter(r) || r == '_':
+			for i < len(rs) && name(rs[i]) {
+				i++
+			}
+
+			// a name can't end in a dash or dot
+			for rs[i-1] == '-' || rs[i-1] == '.' {
+				i--
+			}
+
+		case unicode.IsDigit(r) || (r == '.' && i+1 < len(rs) && unicode.IsDigit(rs[i+1])):
+			for i < len(rs) && (unicode.IsDigit(rs[i]) || rs[i] == '.') {
+				i++
+			}
+
+		case r == '"':
+			for i++; i < len(rs) && rs[i] != '"'; i++ {
+				if rs[i] == '\\' {
+					i++
+				}
+			}
+
+			if i >= len(rs) {
+				return nil, fmt.Errorf("unterminated \" in expression")
+			}
+			i++
+
+		case strings.ContainsRune("<>!", r) && i+1 < len(rs) && rs[i+1] == '=':
+			i += 2
+
+		case strings.ContainsRune("+-*/%=<>(),", r):
+			i++
+
+		default:
+			return nil, fmt.Errorf("unexpected \"%c\" in expression", r)
+		}
+
+		ret = append(ret, string(rs[start:i]))
+	}
+
+	return ret, nil
+}
+
+// parseExpr parses the text of an expression.
+func parseExpr(src string) (*Expr, error) {
+	toks, err := lexExpr(src)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(toks) == 0 {
+		return nil, fmt.Errorf("computed field has no expression")
+	}
+
+	p := &exprParser{src: src, toks: toks}
+
+	e, err := p.expr()
+	if err != nil {
+		return nil, err
+	}
+
+	if p.off < len(p.toks) {
+		return nil, p.unexpected("an operator")
+	}
+
+	return e, nil
+}
+
+func (p *exprParser) peek() string {
+	if p.off < len(p.toks) {
+		return p.toks[p.off]
+	}
+
+	return ""
+}
+
+func (p *exprParser) unexpected(want string) error {
+	if p.off >= len(p.toks) {
+		return fmt.Errorf("expression \"%s\" ends early; expected %s", strings.TrimSpace(p.src), want)
+	}
+
+	return fmt.Errorf("in expression \"%s\", got \"%s\" but expected %s", strings.TrimSpace(p.src), p.toks[p.off], want)
+}
+
+func (p *exprParser) expect(tok string) error {
+	if p.peek() != tok {
+		return p.unexpected(`"` + tok + `"`)
+	}
+
+	p.off++
+	return nil
+}
+
+func (p *exprParser) expr() (*Expr, error) {
+	if p.peek() != "if" {
+		return p.binary(precedence["or"])
+	}
+
+	p.off++
+	e := &Expr{Op: "if"}
+
+	for _, next := range []string{"then", "else", ""} {
+		a, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		e.Args = append(e.Args, a)
+
+		if next != "" {
+			if err := p.expect(next); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return e, nil
+}
+
+// binary parses operators that bind at least as tightly as prec.
+func (p *exprParser) binary(prec int) (*Expr, error) {
+	if prec == precedence["not"] {
+		if p.peek() == "not" {
+			p.off++
+			a, err := p.binary(prec)
+			if err != nil {
+				return nil, err
+			}
+			return &Expr{Op: "not", Args: []*Expr{a}}, nil
+		}
+		prec++
+	}
+
+	if prec > precedence["*"] {
+		return p.unary()
+	}
+
+	left, err := p.binary(prec + 1)
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		op := p.peek()
+		if q, ok := precedence[op]; !ok || q != prec || op == "not" || op == "if" {
+			return left, nil
+		}
+		p.off++
+
+		right, err := p.binary(prec + 1)
+		if err != nil {
+			return nil, err
+		}
+
+		left = &Expr{Op: op, Args: []*Expr{left, right}}
+
+		// a < b < c doesn't mean what it looks like
+		if prec == precedence["="] {
+			if _, ok := precedence[p.peek()]; ok && precedence[p.peek()] == prec {
+				return nil, p.unexpected("something other than another comparison")
+			}
+		}
+	}
+}
+
+func (p *exprParser) unary() (*Expr, error) {
+	if p.peek() == "-" {
+		p.off++
+		a, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return &Expr{Op: "neg", Args: []*Expr{a}}, nil
+	}
+
+	return p.primary()
+}
+
+func (p *exprParser) primary() (*Expr, error) {
+	tok := p.peek()
+	if tok == "" {
+		return nil, p.unexpected("a value")
+	}
+
+	first, _ := utf8.DecodeRuneInString(tok)
+
+	switch {
+	case tok == "(":
+		p.off++
+		e, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		return e, p.expect(")")
+
+	case tok == "true" || tok == "false":
+		p.off++
+		return &Expr{Op: "bool", Value: tok == "true"}, nil
+
+	case tok[0] == '"':
+		p.off++
+		s := exprUnquote.Replace(tok[1 : len(tok)-1])
+		return &Expr{Op: "str", Value: s}, nil
+
+	case unicode.IsDigit(first) || first == '.':
+		f, err := strconv.ParseFloat(tok, 64)
+		if err != nil {
+			return nil, fmt.Errorf("\"%s\" isn't a number", tok)
+		}
+		p.off++
+		return &Expr{Op: "num", Value: f}, nil
+
+	case functions[tok] && p.off+1 < len(p.toks) && p.toks[p.off+1] == "(":
+		p.off += 2
+		e := &Expr{Op: tok}
+
+		for {
+			a, err := p.expr()
+			if err != nil {
+				return nil, err
+			}
+			e.Args = append(e.Args, a)
+
+			if p.peek() != "," {
+				break
+			}
+			p.off++
+		}
+
+		if tok == "round" && len(e.Args) > 2 {
+			return nil, fmt.Errorf("round takes a number and, optionally, how many places to round it to")
+		}
+
+		return e, p.expect(")")
+
+	case unicode.IsLetter(first) || first == '_':
+		if keywords[tok] {
+			return nil, p.unexpected("a value")
+		}
+		p.off++
+		return &Expr{Op: "field", Field: tok}, nil
+	}
+
+	return nil, p.unexpected("a value")
+}
+
+// fields lists the IDs an expression refers to.
+func (e *Expr) fields() (ret []string) {
+	if e.Op == "field" {
+		return []string{e.Field}
+	}
+
+	for _, a := range e.Args {
+		ret = append(ret, a.fields()...)
+	}
+
+	return ret
+}
+
+// Value types.
+const (
+	typeNumber  = "number"
+	typeBoolean = "boolean"
+	typeText    = "text"
+)
+
+// valueType is the type of the value a field contributes to an
+// expression, or "" if it can't be used in one.
+func valueType(f *Node, ids map[string]*Node, busy map[*Node]bool) string {
+	switch f.Kind {
+	case NNumberField:
+		return typeNumber
+	case NCheckField, NRadioField, NSwitchField:
+		return typeBoolean
+	case NTextField, NEmailField, NPhoneField, NDateField, NDropField, NChoiceField:
+		return typeText
+	case NComputedField:
+		// a bad expression is reported on its own field; don't
+		// report it again on everything that uses it
+		e, err := parseExpr(f.Attrs["expr"])
+		if err != nil {
+			return typeNumber
+		}
+
+		busy[f] = true
+		defer delete(busy, f)
+
+		if t, err := e.typ(ids, busy); err == nil {
+			return t
+		}
+		return typeNumber
+	}
+
+	return ""
+}
+
+// typ works out the type of the expression's value, checking that
+// each operator gets what it needs. Numbers and booleans mix; text
+// only goes with text. The two ways an if goes have to be the same
+// type, though, so the if has one.
+func (e *Expr) typ(ids map[string]*Node, busy map[*Node]bool) (string, error) {
+	types := []string{}
+	for _, a := range e.Args {
+		t, err := a.typ(ids, busy)
+		if err != nil {
+			return "", err
+		}
+		types = append(types, t)
+	}
+
+	numeric := func(i int) error {
+		if types[i] == typeText {
+			return fmt.Errorf("%s is text, not a number", e.Args[i])
+		}
+		return nil
+	}
+
+	switch e.Op {
+	case "num":
+		return typeNumber, nil
+	case "str":
+		return typeText, nil
+	case "bool":
+		return typeBoolean, nil
+
+	case "field":
+		f, ok := ids[e.Field]
+		if !ok || !f.IsField() {
+			return "", fmt.Errorf("\"%s\" isn't a field", e.Field)
+		}
+
+		if r := f.inRepeat(); r != nil {
+			return "", fmt.Errorf("%s is answered once per row of %s, so it can't be used in an expression", e.Field, r.ID)
+		}
+
+		// a cycle is reported elsewhere; just don't chase it
+		if busy[f] {
+			return typeNumber, nil
+		}
+
+		t := valueType(f, ids, busy)
+		if t == "" {
+			return "", fmt.Errorf("%s is a %s, which can't be used in an expression", e.Field, strings.ToLower(nodeNames[f.Kind]))
+		}
+		return t, nil
+
+	case "and", "or", "not":
+		return typeBoolean, nil
+
+	case "=", "!=":
+		if (types[0] == typeText) != (types[1] == typeText) {
+			return "", fmt.Errorf("can't compare %s with %s", e.Args[0], e.Args[1])
+		}
+
+		return typeBoolean, nil
+
+	case "<", "<=", ">", ">=":
+		if (types[0] == typeText) != (types[1] == typeText) {
+			return "", fmt.Errorf("can't compare %s with %s", e.Args[0], e.Args[1])
+		}
+		return typeBoolean, nil
+
+	case "if":
+		if types[1] != types[2] {
+			return "", fmt.Errorf("%s and %s aren't the same type", e.Args[1], e.Args[2])
+		}
+
+		return types[1], nil
+	}
+
+	// arithmetic, and the functions
+	for i := range e.Args {
+		if err := numeric(i); err != nil {
+			return "", err
+		}
+	}
+
+	return typeNumber, nil
+}
+
+// resolveComputed checks the expression of every computed field
+// against the form, and makes sure none of them depend on
+// themselves.
+func resolveComputed(root *Node) (errs ParseErrors) {
+	ids := root.index()
+	deps := map[*Node][]*Node{}
+	computed := []*Node{}
+
+	for _, f := range root.Fields() {
+		if f.Kind != NComputedField {
+			continue
+		}
+
+		e, err := parseExpr(f.Attrs["expr"])
+		if err == nil {
+			_, err = e.typ(ids, map[*Node]bool{f: true})
+		}
+
+		if err == nil {
+			err = e.checkOptions(ids)
+		}
+
+		if err != nil {
+			errs = append(errs, nodeError(f, "%s", err))
+			continue
+		}
+
+		computed = append(computed, f)
+		for _, id := range e.fields() {
+			if d := ids[id]; d.Kind == NComputedField {
+				deps[f] = append(deps[f], d)
+			}
+		}
+	}
+
+	state := map[*Node]int{}
+
+	var visit func(*Node) bool
+	visit = func(n *Node) bool {
+		switch state[n] {
+		case 1:
+			return false
+		case 2:
+			return true
+		}
+
+		state[n] = 1
+		for _, d := range deps[n] {
+			if !visit(d) {
+				state[n] = 2
+				if d == n || state[d] == 1 {
+					errs = append(errs, nodeError(n, "%s depends on itself", n.ID))
+				}
+				return false
+			}
+		}
+		state[n] = 2
+
+		return true
+	}
+
+	for _, f := range computed {
+		visit(f)
+	}
+
+	return errs
+}
+
+// checkOptions makes sure a dropdown or choice is only compared with
+// text that's one of its options.
+func (e *Expr) checkOptions(ids map[string]*Node) error {
+	for _, a := range e.Args {
+		if err := a.checkOptions(ids); err != nil {
+			return err
+		}
+	}
+
+	if e.Op != "=" && e.Op != "!=" {
+		return nil
+	}
+
+	for i, a := range e.Args {
+		other := e.Args[1-i]
+		f := ids[a.Field]
+		if a.Op != "field" || other.Op != "str" || (f.Kind != NDropField && f.Kind != NChoiceField) {
+			continue
+		}
+
+		found := false
+		for _, o := range options(f) {
+			found = found || o == other.Value
+		}
+
+		if !found {
+			return fmt.Errorf("\"%s\" isn't one of the options for %s", other.Value, a.Field)
+		}
+	}
+
+	return nil
+}
+
+// eval works out the value of the expression, getting the values of
+// fields from get. nil means it can't be worked out.
+func (e *Expr) eval(get func(id string) interface{}) interface{} {
+	switch e.Op {
+	case "num", "str", "bool":
+		return e.Value
+	case "field":
+		return get(e.Field)
+	}
+
+	args := []interface{}{}
+	for _, a := range e.Args {
+		args = append(args, a.eval(get))
+	}
+
+	switch e.Op {
+	case "and":
+		return truthy(args[0]) && truthy(args[1])
+	case "or":
+		return truthy(args[0]) || truthy(args[1])
+	case "not":
+		return !truthy(args[0])
+	case "if":
+		if args[0] == nil {
+			return nil
+		}
+		if truthy(args[0]) {
+			return args[1]
+		}
+		return args[2]
+	}
+
+	for _, a := range args {
+		if a == nil {
+			return nil
+		}
+	}
+
+	if s, ok := args[0].(string); ok {
+		t, ok := args[1].(string)
+		if !ok {
+			return nil
+		}
+
+		switch e.Op {
+		case "=":
+			return s == t
+		case "!=":
+			return s != t
+		case "<":
+			return s < t
+		case "<=":
+			return s <= t
+		case ">":
+			return s > t
+		case ">=":
+			return s >= t
+		}
+
+		return nil
+	}
+
+	nums := []float64{}
+	for _, a := range args {
+		nums = append(nums, toNumber(a))
+	}
+
+	var ret float64
+
+	switch e.Op {
+	case "=":
+		return nums[0] == nums[1]
+	case "!=":
+		return nums[0] != nums[1]
+	case "<":
+		return nums[0] < nums[1]
+	case "<=":
+		return nums[0] <= nums[1]
+	case ">":
+		return nums[0] > nums[1]
+	case ">=":
+		return nums[0] >= nums[1]
+
+	case "neg":
+		ret = -nums[0]
+	case "+":
+		ret = nums[0] + nums[1]
+	case "-":
+		ret = nums[0] - nums[1]
+	case "*":
+		ret = nums[0] * nums[1]
+	case "/":
+		ret = nums[0] / nums[1]
+	case "%":
+		ret = math.Mod(nums[0], nums[1])
+
+	case "min", "max":
+		ret = nums[0]
+		for _, n := range nums[1:] {
+			if (e.Op == "min") == (n < ret) {
+				ret = n
+			}
+		}
+
+	case "round":
+		scale := 1.0
+		if len(nums) > 1 {
+			scale = math.Pow(10, math.Round(nums[1]))
+		}
+		ret = math.Round(nums[0]*scale) / scale
+	}
+
+	// dividing by zero, say
+	if math.IsNaN(ret) || math.IsInf(ret, 0) {
+		return nil
+	}
+
+	return ret
+}
+
+// toNumber is the value of a number or boolean in arithmetic.
+func toNumber(v interface{}) float64 {
+	if b, ok := v.(bool); ok {
+		if b {
+			return 1
+		}
+		return 0
+	}
+
+	f, _ := number(v)
+	return f
+}
+
+// Compute returns the answers with the value of every computed field
+// filled in. Whatever was submitted for a computed field is ignored.
+func (n *Node) Compute(answers Answers) Answers {
+	ret := Answers{}
+	for k, v := range answers {
+		ret[k] = v
+	}
+
+	// conditions can't depend on computed fields, so filling them
+	// in doesn't change what shows
+	vis := n.visibility(ret)
+	ids := vis.ids
+
+	computed := []*Node{}
+	for _, f := range n.Fields() {
+		if f.Kind == NComputedField {
+			computed = append(computed, f)
+			delete(ret, f.ID)
+		}
+	}
+
+	memo := map[string]interface{}{}
+
+	var get func(id string) interface{}
+	get = func(id string) interface{} {
+		f := ids[id]
+		if f == nil || !vis.visible(f) {
+			return zero(f, ids)
+		}
+
+		if f.Kind != NComputedField {
+			v := ret[id]
+			switch valueType(f, ids, map[*Node]bool{}) {
+			case typeNumber:
+				if _, ok := number(v); !ok {
+					return 0.0
+				}
+				return toNumber(v)
+			case typeBoolean:
+				b, _ := v.(bool)
+				return b
+			}
+
+			s, _ := v.(string)
+			return s
+		}
+
+		if v, ok := memo[id]; ok {
+			return v
+		}
+
+		// cycles are caught at parse time, but don't spin forever
+		// on a tree built some other way
+		memo[id] = nil
+
+		e, err := parseExpr(f.Attrs["expr"])
+		if err != nil {
+			return nil
+		}
+
+		v := e.eval(get)
+		if f, ok := v.(float64); ok && f == 0 {
+			v = 0.0 // not -0
+		}
+
+		memo[id] = v
+		if v != nil {
+			ret[id] = v
+		}
+
+		return v
+	}
+
+	for _, f := range computed {
+		get(f.ID)
+	}
+
+	return ret
+}
+
+// zero is what a field that isn't there or is hidden counts as.
+func zero(f *Node, ids map[string]*Node) interface{} {
+	if f == nil {
+		return nil
+	}
+
+	switch valueType(f, ids, map[*Node]bool{}) {
+	case typeNumber:
+		return 0.0
+	case typeBoolean:
+		return false
+	case typeText:
+		return ""
+	}
+
+	return nil
+}
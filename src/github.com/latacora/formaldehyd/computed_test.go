@@ -0,0 +1,175 @@
+package formaldehyd
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestExpr(t *testing.T) {
+	for src, want := range map[string]string{
+		"a+b*c":                          "a + b * c",
+		"(a + b) * c":                    "(a + b) * c",
+		"a - (b - c)":                    "a - (b - c)",
+		"(a - b) - c":                    "a - b - c",
+		"-(a + 1)":                       "-(a + 1)",
+		"not a and b or c":               "not a and b or c",
+		"not (a and b)":                  "not (a and b)",
+		"(a = 1) = (b = 2)":              "(a = 1) = (b = 2)",
+		"if a > 1 then \"x\" else \"y\"": `if a > 1 then "x" else "y"`,
+		"1 + (if a then 2 else 3)":       "1 + (if a then 2 else 3)",
+		"round( total/ 3 ,2)":            "round(total / 3, 2)",
+		"min(a, max(b, 2.50))":           "min(a, max(b, 2.5))",
+		"page.first-name":                "page.first-name",
+		`"say \"hi\""`:                   `"say \"hi\""`,
+		`"C:\\dir\\"`:                    `"C:\\dir\\"`,
+	} {
+		e, err := parseExpr(src)
+		if err != nil {
+			t.Errorf("%q: %s", src, err)
+			continue
+		}
+
+		if got := e.String(); got != want {
+			t.Errorf("%q: expected %q, got %q", src, want, got)
+		}
+
+		// what String writes parses back the same
+		back, err := parseExpr(e.String())
+		if err != nil || back.String() != e.String() {
+			t.Errorf("%q: didn't round trip: %v %v", src, back, err)
+		}
+	}
+
+	if e, err := parseExpr(`"a \"b\" \\c\\"`); err != nil || e.Value != `a "b" \c\` {
+		t.Errorf("expected the quotes and backslashes unescaped, got %v, %v", e, err)
+	}
+
+	for src, want := range map[string]string{
+		"":               "no expression",
+		"a +":            "ends early",
+		"a b":            "expected an operator",
+		"a < b < c":      "another comparison",
+		"(a":             `expected ")"`,
+		"if a then b":    `expected "else"`,
+		"a $ b":          `unexpected "$"`,
+		`"open`:          "unterminated",
+		"round(a, 1, 2)": "round takes",
+		"then":           "expected a value",
+	} {
+		if _, err := parseExpr(src); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%q: expected an error about %q, got %v", src, want, err)
+		}
+	}
+}
+
+func TestBadComputed(t *testing.T) {
+	for src, want := range map[string]string{
+		"Total [= missing * 2 ]":                                        `"missing" isn't a field`,
+		"Name [          ]\nTotal [= name * 2 ]":                        "name is text, not a number",
+		"Name [          ]\nTotal [= if name = 1 then 1 else 2 ]":       "can't compare name with 1",
+		"Age [ +/-]\nTotal [= if age > 1 then \"old\" else 2 ]":         "aren't the same type",
+		"Age [ +/-]\nTotal [= if age > 1 then true else 2 ]":            "aren't the same type",
+		"CV [     ^]\nTotal [= cv ]":                                    "can't be used in an expression",
+		"Size *---\n * s\n * m\n ----\nBig [= size = \"l\" ]":           `"l" isn't one of the options for size`,
+		"#a\nA [= b + 1 ]\n#b\nB [= a + 1 ]":                            "depends on itself",
+		"#a\nA [= a + 1 ]":                                              "depends on itself",
+		"Total [= 1 + ":                                                 "a ] to close it",
+		"Total [= 1 ] {required}":                                       "can't be required",
+		"#total\nTotal [= 1 ]\n~ total ~~~~\nName [    ]\n~~~~~~~~~~~~": "total is computed",
+	} {
+		_, err := Parse([]byte(src))
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%q: expected an error about %q, got %v", src, want, err)
+		}
+	}
+}
+
+func TestCompute(t *testing.T) {
+	n, err := Parse(fixture("computed.form"))
+	ok(t, err)
+
+	a := n.Compute(Answers{"price": 12.5, "quantity": "4", "rush": true, "size": "large", "total": 1.0})
+	for id, want := range map[string]interface{}{
+		"subtotal":      50.0,
+		"total":         80.0,
+		"order.average": 20.0,
+	} {
+		if a[id] != want {
+			t.Errorf("expected %s to be %v, got %v", id, want, a[id])
+		}
+	}
+
+	// nothing answered yet counts as zero, and dividing by it leaves
+	// the average without a value
+	a = n.Compute(Answers{})
+	if a["subtotal"] != 0.0 || a["total"] != 0.0 {
+		t.Errorf("expected zeros for an empty form, got %v", a)
+	}
+
+	if _, ok := a["order.average"]; ok {
+		t.Errorf("expected no average without a quantity, got %v", a)
+	}
+
+	if errs := n.Validate(n.Compute(Answers{"price": 1.0, "quantity": 2.0})); len(errs) > 0 {
+		t.Errorf("expected computed values to validate, got %v", errs)
+	}
+
+	if v, err := a.Float("subtotal"); err != nil || v != 0 {
+		t.Errorf("expected Float to read a computed value, got %v %v", v, err)
+	}
+
+	back, err := FromJSON([]byte(n.JSON()))
+	ok(t, err)
+
+	if !Equivalent(n, back) {
+		t.Errorf("expected computed fields to survive JSON, got %v", back)
+	}
+
+	roundTrip(t, fixture("computed.form"))
+}
+
+func TestComputeHidden(t *testing.T) {
+	n, err := Parse([]byte(`
+#gift
+Gift [ ]
+
+~ gift ~~~~~~~~~~~~~~~~~~
+
+#wrapping
+Wrapping [ 3 +/-]
+#fee
+Fee      [= wrapping * 2 ]
+
+~~~~~~~~~~~~~~~~~~~~~~~~~
+
+#total
+Total [= 10 + wrapping ]
+`))
+	ok(t, err)
+
+	a := n.Compute(Answers{"gift": false, "wrapping": 3.0, "fee": 99.0})
+	if _, ok := a["fee"]; ok {
+		t.Errorf("expected no value for a hidden computed field, got %v", a)
+	}
+
+	if a["total"] != 10.0 {
+		t.Errorf("expected a hidden answer to count as zero, got %v", a["total"])
+	}
+
+	a = n.Compute(Answers{"gift": true, "wrapping": 3.0})
+	if a["fee"] != 6.0 || a["total"] != 13.0 {
+		t.Errorf("expected fee and total once shown, got %v", a)
+	}
+
+	w := &bytes.Buffer{}
+	ok(t, n.HTML(w, &HTMLOptions{Answers: a}))
+
+	if want := `<output id="f-fee" name="fee" data-expr="wrapping * 2">6</output>`; !strings.Contains(w.String(), want) {
+		t.Errorf("missing %s in\n%s", want, w.String())
+	}
+
+	if s := n.Schema().Properties["fee"]; !s.ReadOnly || s.Type != "number" {
+		t.Errorf("expected a read-only number in the schema, got %+v", s)
+	}
+}
@@ -0,0 +1,377 @@
+package formaldehyd
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Condition operators.
+const (
+	CondSet   = "set"   // the field is checked, or answered at all
+	CondUnset = "unset" // the opposite
+	CondEq    = "eq"    // the field's answer is Value
+	CondNe    = "ne"    // the field's answer is anything but Value
+)
+
+// A Condition is what a ~squiggle~ block turns into: the sections
+// inside the block only show when it holds. The text of the block
+// reads like:
+//
+//	~ subscribe ~~~~~~~~~~~~~~~~      (a checkbox is checked)
+//	~ not subscribe ~~~~~~~~~~~~      (... or isn't)
+//	~ color is green ~~~~~~~~~~~      (a radio or dropdown has a value)
+//	~ color is not green ~~~~~~~      (... or doesn't)
+//
+// where the first word is the ID of an earlier field.
+type Condition struct {
+	Field string `json:"field"`
+	Op    string `json:"op"`
+	Value string `json:"value"`
+}
+
+func (c *Condition) String() string {
+	switch c.Op {
+	case CondUnset:
+		return "not " + c.Field
+	case CondEq:
+		return c.Field + " is " + c.Value
+	case CondNe:
+		return c.Field + " is not " + c.Value
+	}
+
+	return c.Field
+}
+
+// parseCondition reads the text of a squiggle block.
+func parseCondition(text string) (*Condition, error) {
+	words := strings.Fields(text)
+
+	c := &Condition{Op: CondSet}
+
+	if len(words) > 0 && words[0] == "not" {
+		c.Op = CondUnset
+		words = words[1:]
+	}
+
+	if len(words) == 0 {
+		return nil, fmt.Errorf("condition \"%s\" doesn't name a field", text)
+	}
+
+	c.Field = words[0]
+	words = words[1:]
+
+	if len(words) == 0 {
+		return c, nil
+	}
+
+	if c.Op == CondUnset || words[0] != "is" {
+		return nil, fmt.Errorf(`can't make sense of condition "%s";
+expected "field", "not field", "field is value" or "field is not value"`, text)
+	}
+
+	c.Op = CondEq
+	words = words[1:]
+
+	if len(words) > 0 && words[0] == "not" {
+		c.Op = CondNe
+		words = words[1:]
+	}
+
+	if len(words) == 0 {
+		return nil, fmt.Errorf("condition \"%s\" doesn't say what value to compare to", text)
+	}
+
+	c.Value = strings.Join(words, " ")
+	return c, nil
+}
+
+// truthy is whether an answer counts as "set".
+func truthy(v interface{}) bool {
+	switch t := v.(type) {
+	case nil:
+		return false
+	case bool:
+		return t
+	case string:
+		return t != ""
+	}
+
+	// a repeat is set if it has any rows
+	if rs, ok := rows(v); ok {
+		return len(rs) > 0
+	}
+
+	if f, ok := number(v); ok {
+		return f != 0
+	}
+
+	return true
+}
+
+// matches is whether an answer equals a condition's value.
+func matches(v interface{}, value string) bool {
+	if s, ok := v.(string); ok {
+		return s == value
+	}
+
+	if f, ok := number(v); ok {
+		g, ok := number(value)
+		return ok && f == g
+	}
+
+	return false
+}
+
+// holds evaluates the condition given the answer to its field (nil if
+// there isn't one).
+func (c *Condition) holds(v interface{}) bool {
+	switch c.Op {
+	case CondUnset:
+		return !truthy(v)
+	case CondEq:
+		return v != nil && matches(v, c.Value)
+	case CondNe:
+		return v == nil || !matches(v, c.Value)
+	}
+
+	return truthy(v)
+}
+
+// index maps IDs to the nodes under n.
+func (n *Node) index() map[string]*Node {
+	ret := map[string]*Node{}
+
+	var walk func(*Node)
+	walk = func(k *Node) {
+		if k.ID != "" {
+			ret[k.ID] = k
+		}
+
+		for _, kid := range k.Children {
+			walk(kid)
+		}
+	}
+
+	walk(n)
+	return ret
+}
+
+// visibility works out which nodes show for a given set of answers.
+// A node shows when its condition holds and its page shows; the
+// answer to a field that's hidden counts as no answer at all.
+type visibility struct {
+	ids     map[string]*Node
+	answers Answers
+	memo    map[*Node]bool
+}
+
+func (n *Node) visibility(answers Answers) *visibility {
+	return &visibility{
+		ids:     n.index(),
+		answers: answers,
+		memo:    map[*Node]bool{},
+	}
+}
+
+func (v *visibility) visible(n *Node) bool {
+	if n == nil {
+		return true
+	}
+
+	if ret, ok := v.memo[n]; ok {
+		return ret
+	}
+
+	// conditions are checked for cycles at parse time, but don't
+	// spin forever on a tree built some other way
+	v.memo[n] = false
+
+	ret := v.visible(n.Parent)
+
+	if ret && n.Cond != nil {
+		ret = n.Cond.holds(v.answer(n.Cond.Field))
+	}
+
+	v.memo[n] = ret
+	return ret
+}
+
+func (v *visibility) answer(id string) interface{} {
+	f, ok := v.ids[id]
+	if !ok || !v.visible(f) {
+		return nil
+	}
+
+	return v.answers[id]
+}
+
+// Visible is whether n shows, given a set of answers to its form.
+func (n *Node) Visible(root *Node, answers Answers) bool {
+	return root.visibility(answers).visible(n)
+}
+
+// Prune returns the answers with those for hidden fields removed.
+func (n *Node) Prune(answers Answers) Answers {
+	vis := n.visibility(answers)
+
+	ret := Answers{}
+	for k, v := range answers {
+		f, ok := vis.ids[k]
+		if ok && !vis.visible(f) {
+			continue
+		}
+
+		if ok && f.Kind == NRepeat {
+			v = pruneRows(f, v, vis)
+		}
+
+		ret[k] = v
+	}
+
+	return ret
+}
+
+// resolveConditions turns the text of every squiggle block into a
+// Condition, checking that it refers to a field that exists, that
+// the comparison makes sense for that kind of field, and that no
+// chain of conditions loops back on itself.
+func resolveConditions(root *Node) (errs ParseErrors) {
+	ids := root.index()
+	parsed := map[string]*Condition{}
+	bad := map[string]bool{}
+	order := []*Node{}
+
+	var walk func(*Node)
+	walk = func(n *Node) {
+		order = append(order, n)
+
+		if n.Opt != "" && !bad[n.Opt] {
+			c, ok := parsed[n.Opt]
+			if !ok {
+				var err error
+				if c, err = parseCondition(n.Opt); err == nil {
+					err = c.check(ids)
+				}
+
+				if err != nil {
+					// report a bad block once, not once per node in it
+					errs = append(errs, nodeError(n, "%s", err))
+					bad[n.Opt] = true
+					c = nil
+				} else {
+					parsed[n.Opt] = c
+				}
+			}
+
+			if c != nil && c.Field == n.ID {
+				errs = append(errs, nodeError(n, "%s can't depend on its own answer", n.ID))
+			} else {
+				n.Cond = c
+			}
+		}
+
+		for _, kid := range n.Children {
+			walk(kid)
+		}
+	}
+
+	walk(root)
+
+	// follow each node's condition to its field, and that field's
+	// condition (or its page's) to the next, looking for a loop
+	state := map[*Node]int{}
+
+	deps := func(n *Node) (ret []*Node) {
+		if n.Cond != nil {
+			ret = append(ret, ids[n.Cond.Field])
+		}
+
+		if n.Parent != nil {
+			ret = append(ret, n.Parent)
+		}
+
+		return ret
+	}
+
+	var visit func(*Node) bool
+	visit = func(n *Node) bool {
+		if n == nil {
+			return true
+		}
+
+		switch state[n] {
+		case 1:
+			errs = append(errs, nodeError(n, "the condition on %s depends on itself", n.ID))
+			return false
+		case 2:
+			return true
+		}
+
+		state[n] = 1
+		for _, d := range deps(n) {
+			if !visit(d) {
+				// break the loop so evaluation can't chase it
+				n.Cond = nil
+				state[n] = 2
+				return false
+			}
+		}
+		state[n] = 2
+
+		return true
+	}
+
+	for _, n := range order {
+		visit(n)
+	}
+
+	return errs
+}
+
+// check makes sure a condition can be evaluated against the form.
+func (c *Condition) check(ids map[string]*Node) error {
+	f, ok := ids[c.Field]
+	if !ok || !f.IsField() {
+		return fmt.Errorf("condition refers to \"%s\", which isn't a field", c.Field)
+	}
+
+	if f.Kind == NComputedField {
+		return fmt.Errorf("%s is computed; conditions can only depend on answers", c.Field)
+	}
+
+	if r := f.inRepeat(); r != nil {
+		return fmt.Errorf("%s is answered once per row of %s; conditions can only depend on fields outside a repeat", c.Field, r.ID)
+	}
+
+	if c.Op != CondEq && c.Op != CondNe {
+		return nil
+	}
+
+	switch f.Kind {
+	case NDropField, NChoiceField:
+		for _, opt := range f.Children {
+			if opt.Text == c.Value {
+				return nil
+			}
+		}
+
+		return fmt.Errorf("\"%s\" isn't one of the options for %s", c.Value, c.Field)
+
+	case NCheckField, NRadioField, NSwitchField:
+		return fmt.Errorf("%s is a checkbox; use \"%s\" or \"not %s\"", c.Field, c.Field, c.Field)
+
+	case NFileField:
+		return fmt.Errorf("%s is a file upload; use \"%s\" or \"not %s\"", c.Field, c.Field, c.Field)
+
+	case NRepeat:
+		return fmt.Errorf("%s is a repeat; use \"%s\" or \"not %s\"", c.Field, c.Field, c.Field)
+
+	case NDateField:
+		if _, err := time.Parse(dateLayout, c.Value); err != nil {
+			return fmt.Errorf("%s is a date; compare it to one like 2006-01-02", c.Field)
+		}
+	}
+
+	return nil
+}
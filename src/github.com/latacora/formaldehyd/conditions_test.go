@@ -0,0 +1,83 @@
+package formaldehyd
+
+import (
+	"strings"
+	"testing"
+)
+
+const conditionForm = `
+Page 1
+------
+
+#subscribe
+Subscribe [ ]
+
+#color
+Color *-----
+      * red
+      * green
+      ------
+
+~ subscribe ~~~~~~~~~~~~~~~~~~~~
+
+#email
+Email [                    ]
+
+~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+
+~ color is not green ~~~~~~~~~~~
+
+#why
+Why not green [                    ]
+
+~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+`
+
+func TestConditions(t *testing.T) {
+	n, err := Parse([]byte(conditionForm))
+	ok(t, err)
+
+	ids := n.index()
+
+	if c := ids["email"].Cond; c == nil || c.Field != "subscribe" || c.Op != CondSet {
+		t.Fatalf("expected email to depend on subscribe, got %+v", c)
+	}
+
+	if c := ids["why"].Cond; c == nil || c.Field != "color" || c.Op != CondNe || c.Value != "green" {
+		t.Fatalf("expected why to depend on color, got %+v", c)
+	}
+
+	a := Answers{"subscribe": false, "color": "green"}
+	if ids["email"].Visible(n, a) || ids["why"].Visible(n, a) {
+		t.Fatalf("expected email and why to be hidden")
+	}
+
+	a = Answers{"subscribe": true, "color": "red"}
+	if !ids["email"].Visible(n, a) || !ids["why"].Visible(n, a) {
+		t.Fatalf("expected email and why to show")
+	}
+
+	// hidden fields aren't validated, and get pruned
+	a = Answers{"subscribe": false, "email": 12}
+	if errs := n.Validate(a); len(errs) != 0 {
+		t.Fatalf("unexpected validation errors: %v", errs)
+	}
+
+	if _, ok := n.Prune(a)["email"]; ok {
+		t.Fatalf("expected email to be pruned")
+	}
+}
+
+func TestBadConditions(t *testing.T) {
+	for src, want := range map[string]string{
+		"~ nope ~~~~\nName [     ]\n~~~~\n":                      "isn't a field",
+		"#a\nA [ ]\n~ a is yes ~~~\nName [     ]\n~~~~\n":        "is a checkbox",
+		"~ a ~~~~\n#a\nA [ ]\n~~~~\n":                            "its own answer",
+		"~ b ~~~~\n#a\nA [ ]\n~~~~\n~ a ~~~~\n#b\nB [ ]\n~~~~\n": "depends on itself",
+	} {
+		_, err := Parse([]byte(src))
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("expected an error containing \"%s\", got %v", want, err)
+		}
+	}
+}
@@ -0,0 +1,278 @@
+package formaldehyd
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// An attribute block trails a field on the same line and constrains
+// its answer:
+//
+//	Name [                    ] {required, minlen 2, pattern /[A-Za-z ]+/}
+//	Age  [  +/-]              {min 1, max 10, step 1}
+//	I agree [ ]               {required}
+//	Born [ yyyy-mm-dd ]       {min 1900-01-01}
+//	CV   [               ^]   {accept ".pdf .doc", maxsize 2000000}
+//	Bio  [                  ] {rows 5}
+//	City [                  ] {placeholder "e.g. Chicago"}
+//	Zip  [ 60601            ] {placeholder}
+//
+// a button can say what it does (see wizard.go):
+//
+//	[( Skip ahead )] {goto payment}
+//
+// and a repeat how many rows it takes (see repeat.go):
+//
+//	+ Dependants +++++++ {min 1, max 6}
+//
+// Items are separated by commas; each is a keyword, optionally
+// followed by a value. Values can be /regexes/ or "quoted strings"
+// when they need to contain commas. A string escapes " and \ with a
+// backslash, as in an expression, and a regex escapes /.
+//
+// What's written in a box is its default, and is filled in for the
+// person answering. A placeholder is only a hint, shown while the box
+// is empty; a bare {placeholder} makes what's in the box one instead.
+
+// splitAttrs breaks the inside of an attribute block into keyword,
+// value pairs.
+func splitAttrs(body string) (ret [][2]string, err error) {
+	var item strings.Builder
+
+	flush := func() {
+		text := strings.TrimSpace(item.String())
+		item.Reset()
+
+		if text == "" {
+			return
+		}
+
+		kv := [2]string{text, ""}
+		if i := strings.IndexAny(text, " \t"); i != -1 {
+			kv = [2]string{text[:i], strings.TrimSpace(text[i:])}
+		}
+
+		ret = append(ret, kv)
+	}
+
+	rs := []rune(body)
+	for i := 0; i < len(rs); i++ {
+		switch r := rs[i]; r {
+		case ',':
+			flush()
+
+		case '/', '"':
+			// copy through to the matching delimiter, escapes and all
+			item.WriteRune(r)
+
+			closed := false
+			for i++; i < len(rs); i++ {
+				item.WriteRune(rs[i])
+
+				if rs[i] == '\\' && i+1 < len(rs) {
+					i++
+					item.WriteRune(rs[i])
+				} else if rs[i] == r {
+					closed = true
+					break
+				}
+			}
+
+			if !closed {
+				return nil, fmt.Errorf("unterminated %c in attribute block", r)
+			}
+
+		default:
+			item.WriteRune(r)
+		}
+	}
+
+	flush()
+	return ret, nil
+}
+
+// unquote strips the delimiters off a /regex/ or "string" value.
+func unquote(v string) string {
+	if len(v) >= 2 && v[0] == '"' && v[len(v)-1] == '"' {
+		return exprUnquote.Replace(v[1 : len(v)-1])
+	}
+
+	if len(v) >= 2 && v[0] == '/' && v[len(v)-1] == '/' {
+		return strings.Replace(v[1:len(v)-1], `\/`, `/`, -1)
+	}
+
+	return v
+}
+
+// applyAttrs checks the items of an attribute block against the kind
+// of field they're attached to and stores them in its Attrs.
+func (n *Node) applyAttrs(body string) error {
+	items, err := splitAttrs(body)
+	if err != nil {
+		return err
+	}
+
+	whole := func(k, v string) error {
+		if _, err := strconv.Atoi(v); err != nil {
+			return fmt.Errorf("%s wants a whole number, not \"%s\"", k, v)
+		}
+		n.Attrs[k] = v
+		return nil
+	}
+
+	for _, kv := range items {
+		k, v := kv[0], unquote(kv[1])
+
+		switch {
+		case k == "required" || k == "optional":
+			if !n.IsField() {
+				return fmt.Errorf("only fields can be %s", k)
+			}
+
+			if v != "" {
+				return fmt.Errorf("%s doesn't take a value", k)
+			}
+
+			if n.Kind == NComputedField {
+				return fmt.Errorf("a computed field is never answered, so it can't be %s", k)
+			}
+
+			if n.Kind == NRepeat {
+				return fmt.Errorf("a repeat can't be %s; say how many rows it needs with min instead", k)
+			}
+
+			delete(n.Attrs, "required")
+			if k == "required" {
+				n.Attrs["required"] = "t"
+			}
+
+		case n.Kind == NButton && (k == ActNext || k == ActPrev || k == ActSubmit || k == ActCancel || k == ActGoto):
+			if a := n.Attrs["action"]; a != "" {
+				return fmt.Errorf("this button already does %s", a)
+			}
+
+			if k == ActGoto && v == "" {
+				return fmt.Errorf("goto needs the id of a page")
+			}
+
+			if k != ActGoto && v != "" {
+				return fmt.Errorf("%s doesn't take a value", k)
+			}
+
+			n.Attrs["action"] = k
+			if v != "" {
+				n.Attrs["target"] = v
+			}
+
+		case (k == "min" || k == "max") && n.Kind == NRepeat:
+			if err = whole(k, v); err != nil {
+				return err
+			}
+
+		case (k == "min" || k == "max" || k == "step") && n.Kind == NNumberField:
+			if err = whole(k, v); err != nil {
+				return err
+			}
+
+		case (k == "minlen" || k == "maxlen") && n.Kind == NTextField:
+			if err = whole(k, v); err != nil {
+				return err
+			}
+
+		case k == "pattern" && n.Kind == NTextField:
+			n.Attrs["pattern"] = v
+
+		case k == "rows" && n.Kind == NTextField:
+			if err = whole(k, v); err != nil {
+				return err
+			}
+
+		case (k == "min" || k == "max") && n.Kind == NDateField:
+			if _, err := time.Parse(dateLayout, v); err != nil {
+				return fmt.Errorf("%s wants a date like 2006-01-02, not \"%s\"", k, v)
+			}
+			n.Attrs[k] = v
+
+		case k == "accept" && n.Kind == NFileField:
+			if len(strings.Fields(v)) == 0 {
+				return fmt.Errorf("accept needs file extensions or types, like \".pdf image/*\"")
+			}
+			n.Attrs[k] = strings.Join(strings.Fields(v), " ")
+
+		case k == "placeholder" && n.placeholds():
+			if v == "" {
+				v, n.Attrs["default"] = n.Attrs["default"], ""
+			}
+
+			if v == "" {
+				return fmt.Errorf("placeholder needs some text, in quotes or in the box")
+			}
+			n.Attrs[k] = v
+
+		case k == "maxsize" && n.Kind == NFileField:
+			if err = whole(k, v); err != nil {
+				return err
+			}
+
+		default:
+			return fmt.Errorf("\"%s\" doesn't apply to a %s", k, strings.ToLower(nodeNames[n.Kind]))
+		}
+	}
+
+	return n.checkAttrs()
+}
+
+// placeholds is whether a field is a box that can have a placeholder.
+func (n *Node) placeholds() bool {
+	switch n.Kind {
+	case NTextField, NNumberField, NEmailField, NPhoneField:
+		return true
+	}
+
+	return false
+}
+
+// checkAttrs makes sure a field's constraints make sense together.
+func (n *Node) checkAttrs() error {
+	rti := func(s string) int { ret, _ := strconv.Atoi(s); return ret }
+
+	if v := n.Attrs["pattern"]; v != "" {
+		if _, err := regexp.Compile(v); err != nil {
+			return fmt.Errorf("bad pattern /%s/: %s", v, err)
+		}
+	}
+
+	// dates compare as strings, since they're all written the same way
+	if n.Kind == NDateField {
+		if n.Attrs["min"] != "" && n.Attrs["max"] != "" && n.Attrs["min"] > n.Attrs["max"] {
+			return fmt.Errorf("min %s is after max %s", n.Attrs["min"], n.Attrs["max"])
+		}
+	} else if n.Attrs["min"] != "" && n.Attrs["max"] != "" && rti(n.Attrs["min"]) > rti(n.Attrs["max"]) {
+		return fmt.Errorf("min %s is more than max %s", n.Attrs["min"], n.Attrs["max"])
+	}
+
+	if n.Kind == NRepeat && (rti(n.Attrs["min"]) < 0 || (n.Attrs["max"] != "" && rti(n.Attrs["max"]) <= 0)) {
+		return fmt.Errorf("a repeat needs a min of 0 or more, and a max of 1 or more")
+	}
+
+	if n.Attrs["step"] != "" && rti(n.Attrs["step"]) <= 0 {
+		return fmt.Errorf("step has to be positive")
+	}
+
+	if n.Attrs["rows"] != "" && rti(n.Attrs["rows"]) <= 0 {
+		return fmt.Errorf("rows has to be positive")
+	}
+
+	if n.Attrs["maxsize"] != "" && rti(n.Attrs["maxsize"]) <= 0 {
+		return fmt.Errorf("maxsize has to be positive")
+	}
+
+	if n.Attrs["minlen"] != "" && n.Attrs["maxlen"] != "" && rti(n.Attrs["minlen"]) > rti(n.Attrs["maxlen"]) {
+		return fmt.Errorf("minlen %s is more than maxlen %s", n.Attrs["minlen"], n.Attrs["maxlen"])
+	}
+
+	return nil
+}
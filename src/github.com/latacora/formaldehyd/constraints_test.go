@@ -0,0 +1,71 @@
+package formaldehyd
+
+import (
+	"strings"
+	"testing"
+)
+
+const constraintForm = `
+#name
+Name [                    ] {required, minlen 2, pattern /[A-Za-z ]+/}
+
+#age
+Age [  +/-] {min 1, max 10, step 1}
+
+#agree
+I agree [ ] {required}
+`
+
+func TestConstraints(t *testing.T) {
+	n, err := Parse([]byte(constraintForm))
+	ok(t, err)
+
+	ids := n.index()
+	if ids["name"].Attrs["pattern"] != "[A-Za-z ]+" || ids["age"].Attrs["max"] != "10" {
+		t.Fatalf("constraints weren't stored: %v %v", ids["name"].Attrs, ids["age"].Attrs)
+	}
+
+	errs := n.Validate(Answers{"name": "Jane Doe", "age": float64(5), "agree": true})
+	if len(errs) != 0 {
+		t.Fatalf("unexpected validation errors: %v", errs)
+	}
+
+	for _, c := range []struct {
+		answers Answers
+		field   string
+		want    string
+	}{
+		{Answers{"age": float64(5), "agree": true}, "name", "required"},
+		{Answers{"name": "J", "agree": true}, "name", "shorter"},
+		{Answers{"name": "J4ne", "agree": true}, "name", "match"},
+		{Answers{"name": "Jane", "age": float64(11), "agree": true}, "age", "more than"},
+		{Answers{"name": "Jane", "age": float64(0), "agree": true}, "age", "less than"},
+		{Answers{"name": "Jane", "agree": false}, "agree", "required"},
+	} {
+		found := false
+		for _, e := range n.Validate(c.answers) {
+			if e.Field == c.field && strings.Contains(e.Message, c.want) {
+				found = true
+			}
+		}
+
+		if !found {
+			t.Errorf("expected \"%s\" for %s given %v", c.want, c.field, c.answers)
+		}
+	}
+}
+
+func TestBadConstraints(t *testing.T) {
+	for src, want := range map[string]string{
+		"Name [          ] {min 3}\n":       "doesn't apply",
+		"Age [  +/-] {min ten}\n":           "whole number",
+		"Age [  +/-] {min 5, max 1}\n":      "more than max",
+		"Name [          ] {pattern /(/}\n": "bad pattern",
+		"{required}\n":                      "follow a field",
+	} {
+		_, err := Parse([]byte(src))
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("expected an error containing \"%s\", got %v", want, err)
+		}
+	}
+}
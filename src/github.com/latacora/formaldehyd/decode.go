@@ -0,0 +1,469 @@
+package formaldehyd
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// FromJSON rebuilds a tree from the JSON that Node.JSON emits, so
+// that a form kept as JSON (by a visual builder, say) goes through
+// the same checks as one parsed from source. IDs are worked out again
+// from labels and tags, exactly as they are for parsed forms, so the
+// "id" in the JSON is ignored; set "tag" to pin one. A node with a
+// "when" but no "opt" gets its condition from the "when".
+//
+// JSON that can't be decoded is a plain error. Anything wrong with
+// the form itself is a ParseErrors, with the tree alongside it.
+func FromJSON(buf []byte) (*Node, error) {
+	var doc struct {
+		Children []json.RawMessage `json:"children"`
+	}
+
+	if err := json.Unmarshal(buf, &doc); err != nil {
+		return nil, fmt.Errorf("can't decode form: %s", err)
+	}
+
+	d := &decoder{}
+	root := &Node{Kind: NDocument}
+
+	if err := d.children(root, doc.Children); err != nil {
+		return nil, err
+	}
+
+	d.errs = append(d.errs, assignIDs(root)...)
+	d.errs = append(d.errs, checkRepeats(root)...)
+	d.errs = append(d.errs, resolveConditions(root)...)
+	d.errs = append(d.errs, resolveComputed(root)...)
+	d.errs = append(d.errs, resolveActions(root)...)
+
+	if len(d.errs) > 0 {
+		return root, d.errs
+	}
+
+	return root, nil
+}
+
+type decoder struct {
+	errs ParseErrors
+}
+
+func (d *decoder) children(parent *Node, raws []json.RawMessage) error {
+	for i, raw := range raws {
+		n, err := d.node(parent, raw)
+		if err != nil {
+			where := "document"
+			switch parent.Kind {
+			case NPage:
+				where = fmt.Sprintf("page \"%s\"", parent.Attrs["label"])
+			case NRepeat:
+				where = fmt.Sprintf("repeat \"%s\"", parent.Attrs["label"])
+			}
+
+			return fmt.Errorf("can't decode child %d of %s: %s", i, where, err)
+		}
+
+		parent.Children = append(parent.Children, n)
+	}
+
+	return nil
+}
+
+// labels puts the labels of a localized dropdown or choice back on its
+// options.
+func (d *decoder) labels(n *Node, labels []string) {
+	if labels == nil {
+		return
+	}
+
+	if len(labels) != len(n.Children) {
+		d.errs = append(d.errs, nodeError(n, "%d labels for %d options", len(labels), len(n.Children)))
+		return
+	}
+
+	for i, opt := range n.Children {
+		if labels[i] != opt.Text {
+			opt.Attrs["label"] = labels[i]
+		}
+	}
+}
+
+func (d *decoder) node(parent *Node, raw json.RawMessage) (*Node, error) {
+	var head struct {
+		Kind string `json:"type"`
+	}
+
+	if err := json.Unmarshal(raw, &head); err != nil {
+		return nil, err
+	}
+
+	n := &Node{
+		Parent: parent,
+		Attrs:  map[string]string{},
+	}
+
+	common := func(tag, opt string, when *Condition, line int) {
+		n.Hash = tag
+		n.Opt = opt
+		n.Line = line
+
+		if opt == "" && when != nil {
+			n.Opt = when.String()
+		}
+	}
+
+	flag := func(k string, v bool) {
+		if v {
+			n.Attrs[k] = "t"
+		}
+	}
+
+	number := func(k string, v *int) {
+		if v != nil {
+			n.Attrs[k] = strconv.Itoa(*v)
+		}
+	}
+
+	switch head.Kind {
+	case "page":
+		var j JPage
+		if err := json.Unmarshal(raw, &j); err != nil {
+			return nil, err
+		}
+
+		if parent.Kind != NDocument {
+			return nil, fmt.Errorf("can't nest pages")
+		}
+
+		n.Kind = NPage
+		n.Attrs["label"] = j.Label
+		common(j.Tag, j.Opt, j.When, 0)
+
+		var kids struct {
+			Children []json.RawMessage `json:"children"`
+		}
+
+		if err := json.Unmarshal(raw, &kids); err != nil {
+			return nil, err
+		}
+
+		if err := d.children(n, kids.Children); err != nil {
+			return nil, err
+		}
+
+	case "button":
+		var j JButton
+		if err := json.Unmarshal(raw, &j); err != nil {
+			return nil, err
+		}
+
+		n.Kind = NButton
+		n.Text = j.Label
+		if j.Action != "" {
+			n.Attrs["action"] = j.Action
+		}
+		if j.Target != "" {
+			n.Attrs["target"] = j.Target
+		}
+		common(j.Tag, j.Opt, j.When, j.Line)
+
+	case "text", "heading":
+		var j JText
+		if err := json.Unmarshal(raw, &j); err != nil {
+			return nil, err
+		}
+
+		n.Kind = NText
+		if head.Kind == "heading" {
+			n.Kind = NHeading
+		}
+
+		// the markdown is the text as written; the text is just what
+		// it says, for renderers that don't do markup
+		n.Text = richText(j.Markdown)
+		if j.Markdown == "" {
+			n.Text = richText(j.Text)
+		}
+		common(j.Tag, j.Opt, j.When, 0)
+
+	case "textfield":
+		var j JTextField
+		if err := json.Unmarshal(raw, &j); err != nil {
+			return nil, err
+		}
+
+		n.Kind = NTextField
+		n.Attrs["label"] = j.Label
+		n.Attrs["default"] = j.Default
+		n.Attrs["width"] = strconv.Itoa(j.Width)
+		n.Attrs["height"] = strconv.Itoa(j.Height)
+		flag("required", j.Required)
+		number("minlen", j.MinLength)
+		number("maxlen", j.MaxLength)
+		number("rows", j.Rows)
+		if j.Pattern != "" {
+			n.Attrs["pattern"] = j.Pattern
+		}
+		common(j.Tag, j.Opt, j.When, j.Line)
+
+	case "date":
+		var j JDateField
+		if err := json.Unmarshal(raw, &j); err != nil {
+			return nil, err
+		}
+
+		n.Kind = NDateField
+		n.Attrs["label"] = j.Label
+		n.Attrs["default"] = ""
+		n.Attrs["width"] = strconv.Itoa(j.Width)
+		n.Attrs["height"] = strconv.Itoa(j.Height)
+		flag("required", j.Required)
+		if j.Min != "" {
+			n.Attrs["min"] = j.Min
+		}
+		if j.Max != "" {
+			n.Attrs["max"] = j.Max
+		}
+		common(j.Tag, j.Opt, j.When, j.Line)
+
+	case "email", "phone":
+		var j JEmailField
+		if err := json.Unmarshal(raw, &j); err != nil {
+			return nil, err
+		}
+
+		n.Kind = NEmailField
+		if head.Kind == "phone" {
+			n.Kind = NPhoneField
+		}
+
+		n.Attrs["label"] = j.Label
+		n.Attrs["default"] = j.Default
+		n.Attrs["width"] = strconv.Itoa(j.Width)
+		n.Attrs["height"] = strconv.Itoa(j.Height)
+		flag("required", j.Required)
+		common(j.Tag, j.Opt, j.When, j.Line)
+
+	case "file":
+		var j JFileField
+		if err := json.Unmarshal(raw, &j); err != nil {
+			return nil, err
+		}
+
+		n.Kind = NFileField
+		n.Attrs["label"] = j.Label
+		n.Attrs["default"] = ""
+		n.Attrs["width"] = strconv.Itoa(j.Width)
+		n.Attrs["height"] = strconv.Itoa(j.Height)
+		flag("required", j.Required)
+		number("maxsize", j.MaxSize)
+		if len(j.Accept) > 0 {
+			n.Attrs["accept"] = strings.Join(j.Accept, " ")
+		}
+		common(j.Tag, j.Opt, j.When, j.Line)
+
+	case "repeat":
+		var j JRepeat
+		if err := json.Unmarshal(raw, &j); err != nil {
+			return nil, err
+		}
+
+		if parent.Kind == NRepeat {
+			return nil, fmt.Errorf("can't nest repeats")
+		}
+
+		n.Kind = NRepeat
+		n.Attrs["label"] = j.Label
+		if j.Min != 0 {
+			n.Attrs["min"] = strconv.Itoa(j.Min)
+		}
+		number("max", j.Max)
+		common(j.Tag, j.Opt, j.When, j.Line)
+
+		var kids struct {
+			Children []json.RawMessage `json:"children"`
+		}
+
+		if err := json.Unmarshal(raw, &kids); err != nil {
+			return nil, err
+		}
+
+		if err := d.children(n, kids.Children); err != nil {
+			return nil, err
+		}
+
+	case "computed":
+		var j JComputedField
+		if err := json.Unmarshal(raw, &j); err != nil {
+			return nil, err
+		}
+
+		// a bad expression is reported by resolveComputed
+		expr := strings.TrimSpace(j.Expr)
+		if e, err := parseExpr(expr); err == nil {
+			expr = e.String()
+		}
+
+		n.Kind = NComputedField
+		n.Attrs["label"] = j.Label
+		n.Attrs["expr"] = expr
+		n.Attrs["width"] = strconv.Itoa(maxInt(j.Width, len(expr)+3))
+		common(j.Tag, j.Opt, j.When, j.Line)
+
+	case "numberfield":
+		var j JNumberField
+		if err := json.Unmarshal(raw, &j); err != nil {
+			return nil, err
+		}
+
+		n.Kind = NNumberField
+		n.Attrs["label"] = j.Label
+		n.Attrs["default"] = ""
+		if j.Default != 0 {
+			n.Attrs["default"] = strconv.Itoa(j.Default)
+		}
+		n.Attrs["width"] = strconv.Itoa(j.Width)
+		n.Attrs["height"] = strconv.Itoa(j.Height)
+		flag("required", j.Required)
+		flag("slider", j.Slider)
+		flag("plusminus", j.PlusMinus)
+		number("min", j.Min)
+		number("max", j.Max)
+		if j.Step != 0 {
+			n.Attrs["step"] = strconv.Itoa(j.Step)
+		}
+		common(j.Tag, j.Opt, j.When, j.Line)
+
+	case "check":
+		var j JCheckField
+		if err := json.Unmarshal(raw, &j); err != nil {
+			return nil, err
+		}
+
+		n.Kind = NCheckField
+		n.Attrs["label"] = j.Label
+		flag("required", j.Required)
+		flag("checked", j.Checked)
+		common(j.Tag, j.Opt, j.When, j.Line)
+
+	case "switch":
+		var j JSwitchField
+		if err := json.Unmarshal(raw, &j); err != nil {
+			return nil, err
+		}
+
+		n.Kind = NSwitchField
+		n.Attrs["label"] = j.Label
+		flag("required", j.Required)
+		flag("on", j.On)
+		common(j.Tag, j.Opt, j.When, j.Line)
+
+	case "radio":
+		var j JRadioField
+		if err := json.Unmarshal(raw, &j); err != nil {
+			return nil, err
+		}
+
+		n.Kind = NRadioField
+		n.Attrs["label"] = j.Label
+		flag("required", j.Required)
+		flag("selected", j.Selected)
+		common(j.Tag, j.Opt, j.When, j.Line)
+
+	case "select":
+		var j JDropField
+		if err := json.Unmarshal(raw, &j); err != nil {
+			return nil, err
+		}
+
+		n.Kind = NDropField
+		n.Attrs["label"] = j.Label
+		flag("required", j.Required)
+		common(j.Tag, j.Opt, j.When, j.Line)
+
+		for _, o := range j.Options {
+			n.Children = append(n.Children, &Node{
+				Kind:   NSelection,
+				Text:   o,
+				Parent: n,
+				Line:   n.Line,
+				Opt:    n.Opt,
+				Attrs:  map[string]string{},
+			})
+		}
+		d.labels(n, j.Labels)
+
+	case "choice":
+		var j JChoiceField
+		if err := json.Unmarshal(raw, &j); err != nil {
+			return nil, err
+		}
+
+		n.Kind = NChoiceField
+		n.Attrs["label"] = j.Label
+		flag("required", j.Required)
+		common(j.Tag, j.Opt, j.When, j.Line)
+
+		found := j.Default == ""
+		for _, o := range j.Options {
+			opt := &Node{
+				Kind:   NSelection,
+				Text:   o,
+				Parent: n,
+				Line:   n.Line,
+				Opt:    n.Opt,
+				Attrs:  map[string]string{},
+			}
+
+			if o == j.Default && !found {
+				opt.Attrs["selected"] = "t"
+				found = true
+			}
+
+			n.Children = append(n.Children, opt)
+		}
+
+		if !found {
+			d.errs = append(d.errs, nodeError(n, "default \"%s\" isn't one of the options", j.Default))
+		}
+		d.labels(n, j.Labels)
+
+	case "":
+		return nil, fmt.Errorf("no type")
+
+	default:
+		return nil, fmt.Errorf("unknown type \"%s\"", head.Kind)
+	}
+
+	// any field can have help, and any box a placeholder
+	if n.IsField() {
+		var j struct {
+			Help         string `json:"help"`
+			HelpMarkdown string `json:"helpmarkdown"`
+			Placeholder  string `json:"placeholder"`
+		}
+
+		if err := json.Unmarshal(raw, &j); err != nil {
+			return nil, err
+		}
+
+		// like a text's, the markdown is the help as written, and the
+		// help just what it says
+		if j.HelpMarkdown != "" {
+			n.Attrs["help"] = richText(j.HelpMarkdown)
+		} else if j.Help != "" {
+			n.Attrs["help"] = richText(j.Help)
+		}
+
+		if j.Placeholder != "" && n.placeholds() {
+			n.Attrs["placeholder"] = j.Placeholder
+		}
+	}
+
+	if err := n.checkAttrs(); err != nil {
+		d.errs = append(d.errs, nodeError(n, "%s", err))
+	}
+
+	return n, nil
+}
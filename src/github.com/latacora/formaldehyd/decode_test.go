@@ -0,0 +1,91 @@
+package formaldehyd
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestFromJSON(t *testing.T) {
+	src := fixture("pages.form")
+	src = append(src, []byte(`
+Page 5
+------
+
+#pick
+Pick one *-----
+         * a
+         * b
+         ----- {required}
+
+~pick is b~~~~~~~
+
+Why b? [        ] {minlen 2, pattern /[a-z]+/}
+How many? [  +/-] {min 1, max 5}
+
+~~~~~~~~~~~~~~~~~
+`)...)
+
+	n, err := Parse(src)
+	ok(t, err)
+
+	m, err := FromJSON([]byte(n.JSON()))
+	ok(t, err)
+
+	if !Equivalent(n, m) {
+		t.Fatalf("decoded tree isn't equivalent:\nbefore:\n%s\nafter:\n%s", n, m)
+	}
+
+	if n.JSON() != m.JSON() {
+		t.Fatalf("JSON doesn't round trip:\n%s", m.JSON())
+	}
+}
+
+func TestJSONEscapes(t *testing.T) {
+	n, err := Parse([]byte("#age\nAge < 18 & \"</script>\" [   ]\n"))
+	ok(t, err)
+
+	js := n.JSON()
+	if strings.ContainsAny(js, "<>&") {
+		t.Errorf("expected <, > and & escaped, got\n%s", js)
+	}
+
+	m, err := FromJSON([]byte(js))
+	ok(t, err)
+
+	if got := m.index()["age"].Attrs["label"]; got != `Age < 18 & "</script>"` {
+		t.Errorf("expected the label back, got %q", got)
+	}
+}
+
+func TestFromJSONWhen(t *testing.T) {
+	m, err := FromJSON([]byte(`{"children": [{"type": "page", "label": "One", "children": [
+		{"type": "check", "label": "Subscribe", "tag": "sub"},
+		{"type": "textfield", "label": "Email", "width": 20, "height": 1,
+		 "when": {"field": "sub", "op": "set"}}
+	]}]}`))
+	ok(t, err)
+
+	f := m.Children[0].Children[1]
+	if f.ID != "one.email" || f.Opt != "sub" || f.Cond == nil || f.Cond.Field != "sub" {
+		t.Fatalf("unexpected field: %+v", f)
+	}
+}
+
+func TestBadJSON(t *testing.T) {
+	for _, c := range []struct {
+		src, want string
+	}{
+		{`{"children": [{"type": "widget"}]}`, `unknown type "widget"`},
+		{`{"children": [{"label": "x"}]}`, `no type`},
+		{`{"children": [{"type": "page", "children": [{"type": "page"}]}]}`, `can't nest pages`},
+		{`{"children": [{"type": "numberfield", "label": "n", "min": 5, "max": 1}]}`, `min 5 is more than max 1`},
+		{`{"children": [{"type": "check", "label": "a"}, {"type": "check", "label": "a"}]}`, `duplicate id "a"`},
+		{`{"children": [{"type": "check", "label": "a", "opt": "b"}]}`, `isn't a field`},
+		{`{"children": 7}`, `can't decode form`},
+	} {
+		_, err := FromJSON([]byte(c.src))
+		if err == nil || !strings.Contains(err.Error(), c.want) {
+			t.Errorf("%s: expected %q, got %v", c.src, c.want, err)
+		}
+	}
+}
@@ -1,9 +1,10 @@
 package formaldehyd
 
 import (
+	"bytes"
 	"encoding/json"
-	"fmt"
 	"strconv"
+	"strings"
 )
 
 // type Node struct {
@@ -18,144 +19,531 @@ import (
 // }
 
 type JButton struct {
-	Kind  string `json:"type"`
-	Label string `json:"label"`
-	Line  int    `json:"line"`
-	Tag   string `json:"tag"`
-	Opt   string `json:"opt"`
+	Kind   string     `json:"type"`
+	ID     string     `json:"id"`
+	Label  string     `json:"label"`
+	Action string     `json:"action"`
+	Target string     `json:"target,omitempty"`
+	Line   int        `json:"line"`
+	Tag    string     `json:"tag"`
+	Opt    string     `json:"opt"`
+	When   *Condition `json:"when"`
 }
 
 type JDropField struct {
-	Kind    string   `json:"type"`
-	Label   string   `json:"label"`
-	Options []string `json:"options"`
-	Line    int      `json:"line"`
-	Tag     string   `json:"tag"`
-	Opt     string   `json:"opt"`
+	Kind         string     `json:"type"`
+	ID           string     `json:"id"`
+	Label        string     `json:"label"`
+	Required     bool       `json:"required"`
+	Options      []string   `json:"options"`
+	Labels       []string   `json:"labels,omitempty"`
+	Help         string     `json:"help"`
+	HelpMarkdown string     `json:"helpmarkdown"`
+	HelpHTML     string     `json:"helphtml"`
+	Line         int        `json:"line"`
+	Tag          string     `json:"tag"`
+	Opt          string     `json:"opt"`
+	When         *Condition `json:"when"`
+}
+
+type JChoiceField struct {
+	Kind         string     `json:"type"`
+	ID           string     `json:"id"`
+	Label        string     `json:"label"`
+	Required     bool       `json:"required"`
+	Options      []string   `json:"options"`
+	Labels       []string   `json:"labels,omitempty"`
+	Default      string     `json:"default"`
+	Help         string     `json:"help"`
+	HelpMarkdown string     `json:"helpmarkdown"`
+	HelpHTML     string     `json:"helphtml"`
+	Line         int        `json:"line"`
+	Tag          string     `json:"tag"`
+	Opt          string     `json:"opt"`
+	When         *Condition `json:"when"`
 }
 
 type JHeader struct {
-	Kind string `json:"type"`
-	Text string `json:"text"`
-	Tag  string `json:"tag"`
-	Opt  string `json:"opt"`
+	Kind     string     `json:"type"`
+	ID       string     `json:"id"`
+	Text     string     `json:"text"`
+	Markdown string     `json:"markdown"`
+	HTML     string     `json:"html"`
+	Tag      string     `json:"tag"`
+	Opt      string     `json:"opt"`
+	When     *Condition `json:"when"`
 }
 
 type JText struct {
-	Kind string `json:"type"`
-	Text string `json:"text"`
-	Tag  string `json:"tag"`
-	Opt  string `json:"opt"`
+	Kind     string     `json:"type"`
+	ID       string     `json:"id"`
+	Text     string     `json:"text"`
+	Markdown string     `json:"markdown"`
+	HTML     string     `json:"html"`
+	Tag      string     `json:"tag"`
+	Opt      string     `json:"opt"`
+	When     *Condition `json:"when"`
 }
 
 type JRadioField struct {
-	Kind     string `json:"type"`
-	Label    string `json:"label"`
-	Selected bool   `json:"selected"`
-	Line     int    `json:"line"`
-	Tag      string `json:"tag"`
-	Opt      string `json:"opt"`
+	Kind         string     `json:"type"`
+	ID           string     `json:"id"`
+	Label        string     `json:"label"`
+	Required     bool       `json:"required"`
+	Selected     bool       `json:"selected"`
+	Help         string     `json:"help"`
+	HelpMarkdown string     `json:"helpmarkdown"`
+	HelpHTML     string     `json:"helphtml"`
+	Line         int        `json:"line"`
+	Tag          string     `json:"tag"`
+	Opt          string     `json:"opt"`
+	When         *Condition `json:"when"`
+}
+
+type JSwitchField struct {
+	Kind         string     `json:"type"`
+	ID           string     `json:"id"`
+	Label        string     `json:"label"`
+	Required     bool       `json:"required"`
+	On           bool       `json:"on"`
+	Help         string     `json:"help"`
+	HelpMarkdown string     `json:"helpmarkdown"`
+	HelpHTML     string     `json:"helphtml"`
+	Line         int        `json:"line"`
+	Tag          string     `json:"tag"`
+	Opt          string     `json:"opt"`
+	When         *Condition `json:"when"`
 }
 
 type JCheckField struct {
-	Kind    string `json:"type"`
-	Label   string `json:"label"`
-	Checked bool   `json:"checked"`
-	Line    int    `json:"line"`
-	Tag     string `json:"tag"`
-	Opt     string `json:"opt"`
+	Kind         string     `json:"type"`
+	ID           string     `json:"id"`
+	Label        string     `json:"label"`
+	Required     bool       `json:"required"`
+	Checked      bool       `json:"checked"`
+	Help         string     `json:"help"`
+	HelpMarkdown string     `json:"helpmarkdown"`
+	HelpHTML     string     `json:"helphtml"`
+	Line         int        `json:"line"`
+	Tag          string     `json:"tag"`
+	Opt          string     `json:"opt"`
+	When         *Condition `json:"when"`
 }
 
 type JTextField struct {
-	Kind    string `json:"type"`
-	Label   string `json:"label"`
-	Default string `json:"default"`
-	Width   int    `json:"width"`
-	Height  int    `json:"height"`
-	Line    int    `json:"line"`
-	Tag     string `json:"tag"`
-	Opt     string `json:"opt"`
+	Kind         string     `json:"type"`
+	ID           string     `json:"id"`
+	Label        string     `json:"label"`
+	Required     bool       `json:"required"`
+	MinLength    *int       `json:"minlength"`
+	MaxLength    *int       `json:"maxlength"`
+	Pattern      string     `json:"pattern"`
+	Default      string     `json:"default"`
+	Placeholder  string     `json:"placeholder"`
+	Width        int        `json:"width"`
+	Height       int        `json:"height"`
+	Rows         *int       `json:"rows"`
+	Help         string     `json:"help"`
+	HelpMarkdown string     `json:"helpmarkdown"`
+	HelpHTML     string     `json:"helphtml"`
+	Line         int        `json:"line"`
+	Tag          string     `json:"tag"`
+	Opt          string     `json:"opt"`
+	When         *Condition `json:"when"`
+}
+
+type JDateField struct {
+	Kind         string     `json:"type"`
+	ID           string     `json:"id"`
+	Label        string     `json:"label"`
+	Required     bool       `json:"required"`
+	Min          string     `json:"min"`
+	Max          string     `json:"max"`
+	Width        int        `json:"width"`
+	Height       int        `json:"height"`
+	Help         string     `json:"help"`
+	HelpMarkdown string     `json:"helpmarkdown"`
+	HelpHTML     string     `json:"helphtml"`
+	Line         int        `json:"line"`
+	Tag          string     `json:"tag"`
+	Opt          string     `json:"opt"`
+	When         *Condition `json:"when"`
+}
+
+type JEmailField struct {
+	Kind         string     `json:"type"`
+	ID           string     `json:"id"`
+	Label        string     `json:"label"`
+	Required     bool       `json:"required"`
+	Default      string     `json:"default"`
+	Placeholder  string     `json:"placeholder"`
+	Width        int        `json:"width"`
+	Height       int        `json:"height"`
+	Help         string     `json:"help"`
+	HelpMarkdown string     `json:"helpmarkdown"`
+	HelpHTML     string     `json:"helphtml"`
+	Line         int        `json:"line"`
+	Tag          string     `json:"tag"`
+	Opt          string     `json:"opt"`
+	When         *Condition `json:"when"`
+}
+
+type JPhoneField struct {
+	Kind         string     `json:"type"`
+	ID           string     `json:"id"`
+	Label        string     `json:"label"`
+	Required     bool       `json:"required"`
+	Default      string     `json:"default"`
+	Placeholder  string     `json:"placeholder"`
+	Width        int        `json:"width"`
+	Height       int        `json:"height"`
+	Help         string     `json:"help"`
+	HelpMarkdown string     `json:"helpmarkdown"`
+	HelpHTML     string     `json:"helphtml"`
+	Line         int        `json:"line"`
+	Tag          string     `json:"tag"`
+	Opt          string     `json:"opt"`
+	When         *Condition `json:"when"`
+}
+
+type JFileField struct {
+	Kind         string     `json:"type"`
+	ID           string     `json:"id"`
+	Label        string     `json:"label"`
+	Required     bool       `json:"required"`
+	Accept       []string   `json:"accept"`
+	MaxSize      *int       `json:"maxsize"`
+	Width        int        `json:"width"`
+	Height       int        `json:"height"`
+	Help         string     `json:"help"`
+	HelpMarkdown string     `json:"helpmarkdown"`
+	HelpHTML     string     `json:"helphtml"`
+	Line         int        `json:"line"`
+	Tag          string     `json:"tag"`
+	Opt          string     `json:"opt"`
+	When         *Condition `json:"when"`
+}
+
+type JComputedField struct {
+	Kind         string     `json:"type"`
+	ID           string     `json:"id"`
+	Label        string     `json:"label"`
+	Expr         string     `json:"expr"`
+	AST          *Expr      `json:"ast"`
+	ValueType    string     `json:"valuetype"`
+	Width        int        `json:"width"`
+	Help         string     `json:"help"`
+	HelpMarkdown string     `json:"helpmarkdown"`
+	HelpHTML     string     `json:"helphtml"`
+	Line         int        `json:"line"`
+	Tag          string     `json:"tag"`
+	Opt          string     `json:"opt"`
+	When         *Condition `json:"when"`
 }
 
 type JNumberField struct {
-	Kind      string `json:"type"`
-	Label     string `json:"label"`
-	Default   int    `json:"default"`
-	Width     int    `json:"width"`
-	Height    int    `json:"height"`
-	Slider    bool   `json:"slider"`
-	PlusMinus bool   `json:"plusminus"`
-	Line      int    `json:"line"`
-	Tag       string `json:"tag"`
-	Opt       string `json:"opt"`
+	Kind         string     `json:"type"`
+	ID           string     `json:"id"`
+	Label        string     `json:"label"`
+	Required     bool       `json:"required"`
+	Min          *int       `json:"min"`
+	Max          *int       `json:"max"`
+	Step         int        `json:"step"`
+	Default      int        `json:"default"`
+	Placeholder  string     `json:"placeholder"`
+	Width        int        `json:"width"`
+	Height       int        `json:"height"`
+	Slider       bool       `json:"slider"`
+	PlusMinus    bool       `json:"plusminus"`
+	Help         string     `json:"help"`
+	HelpMarkdown string     `json:"helpmarkdown"`
+	HelpHTML     string     `json:"helphtml"`
+	Line         int        `json:"line"`
+	Tag          string     `json:"tag"`
+	Opt          string     `json:"opt"`
+	When         *Condition `json:"when"`
 }
 
 type JPage struct {
 	Kind     string        `json:"type"`
+	ID       string        `json:"id"`
 	Label    string        `json:"label"`
+	Tag      string        `json:"tag"`
+	Opt      string        `json:"opt"`
+	When     *Condition    `json:"when"`
 	Children []interface{} `json:"children"`
 }
 
+type JRepeat struct {
+	Kind         string            `json:"type"`
+	ID           string            `json:"id"`
+	Label        string            `json:"label"`
+	Min          int               `json:"min"`
+	Max          *int              `json:"max"`
+	Keys         map[string]string `json:"keys"`
+	Help         string            `json:"help"`
+	HelpMarkdown string            `json:"helpmarkdown"`
+	HelpHTML     string            `json:"helphtml"`
+	Line         int               `json:"line"`
+	Tag          string            `json:"tag"`
+	Opt          string            `json:"opt"`
+	When         *Condition        `json:"when"`
+	Children     []interface{}     `json:"children"`
+}
+
 type JDocument struct {
 	Children []interface{} `json:"children"`
 }
 
+// optionLabel is what to show for an option of a dropdown or choice:
+// the option itself, unless it's been translated (see Localize).
+func optionLabel(opt *Node) string {
+	if l := opt.Attrs["label"]; l != "" {
+		return l
+	}
+
+	return opt.Text
+}
+
+// optionLabels are the labels of all the options of a dropdown or
+// choice, if any of them aren't just the option.
+func optionLabels(n *Node) (ret []string) {
+	translated := false
+	for _, opt := range n.Children {
+		translated = translated || opt.Attrs["label"] != ""
+		ret = append(ret, optionLabel(opt))
+	}
+
+	if !translated {
+		return nil
+	}
+
+	return ret
+}
+
 func (n *Node) JSON() string {
 	d := &JDocument{}
+	ids := n.index()
 
 	rti := func(s string) int { ret, _ := strconv.Atoi(s); return ret }
+	rtp := func(s string) *int {
+		if s == "" {
+			return nil
+		}
+		ret := rti(s)
+		return &ret
+	}
 
-	handle := func(k *Node) interface{} {
+	var handle func(k *Node) interface{}
+	handle = func(k *Node) interface{} {
 		switch k.Kind {
 		case NButton:
 			return &JButton{
-				Kind:  "button",
-				Label: k.Text,
-				Tag:   k.Hash,
-				Opt:   k.Opt,
-				Line:  k.Line,
+				Kind:   "button",
+				ID:     k.ID,
+				Label:  k.Text,
+				Action: k.Attrs["action"],
+				Target: k.Attrs["target"],
+				Tag:    k.Hash,
+				Opt:    k.Opt,
+				When:   k.Cond,
+				Line:   k.Line,
 			}
 
 		case NText:
 			return &JText{
-				Kind: "text",
-				Text: k.Text,
-				Tag:  k.Hash,
-				Opt:  k.Opt,
+				Kind:     "text",
+				ID:       k.ID,
+				Text:     k.Plain(),
+				Markdown: k.Text,
+				HTML:     k.Markdown(),
+				Tag:      k.Hash,
+				Opt:      k.Opt,
+				When:     k.Cond,
 			}
 
 		case NHeading:
 			return &JHeader{
-				Kind: "heading",
-				Text: k.Text,
-				Tag:  k.Hash,
-				Opt:  k.Opt,
+				Kind:     "heading",
+				ID:       k.ID,
+				Text:     k.Plain(),
+				Markdown: k.Text,
+				HTML:     k.Markdown(),
+				Tag:      k.Hash,
+				Opt:      k.Opt,
+				When:     k.Cond,
 			}
 
 		case NNumberField:
 			return &JNumberField{
-				Kind:      "numberfield",
-				Label:     k.Attrs["label"],
-				Default:   rti(k.Attrs["default"]),
-				Slider:    k.Attrs["slider"] == "t",
-				PlusMinus: k.Attrs["plusminus"] == "t",
-				Width:     rti(k.Attrs["width"]),
-				Height:    rti(k.Attrs["height"]),
-				Line:      k.Line,
-				Tag:       k.Hash,
-				Opt:       k.Opt,
+				Kind:         "numberfield",
+				ID:           k.ID,
+				Label:        k.Attrs["label"],
+				Required:     k.Attrs["required"] == "t",
+				Min:          rtp(k.Attrs["min"]),
+				Max:          rtp(k.Attrs["max"]),
+				Step:         rti(k.Attrs["step"]),
+				Default:      rti(k.Attrs["default"]),
+				Placeholder:  k.Attrs["placeholder"],
+				Slider:       k.Attrs["slider"] == "t",
+				PlusMinus:    k.Attrs["plusminus"] == "t",
+				Width:        rti(k.Attrs["width"]),
+				Height:       rti(k.Attrs["height"]),
+				Help:         plain(k.Attrs["help"]),
+				HelpMarkdown: k.Attrs["help"],
+				HelpHTML:     markdown(k.Attrs["help"]),
+				Line:         k.Line,
+				Tag:          k.Hash,
+				Opt:          k.Opt,
+				When:         k.Cond,
 			}
 
 		case NTextField:
 			return &JTextField{
-				Kind:    "textfield",
-				Label:   k.Attrs["label"],
-				Default: k.Attrs["default"],
-				Width:   rti(k.Attrs["width"]),
-				Height:  rti(k.Attrs["height"]),
-				Line:    k.Line,
-				Tag:     k.Hash,
-				Opt:     k.Opt,
+				Kind:         "textfield",
+				ID:           k.ID,
+				Label:        k.Attrs["label"],
+				Required:     k.Attrs["required"] == "t",
+				MinLength:    rtp(k.Attrs["minlen"]),
+				MaxLength:    rtp(k.Attrs["maxlen"]),
+				Pattern:      k.Attrs["pattern"],
+				Default:      k.Attrs["default"],
+				Placeholder:  k.Attrs["placeholder"],
+				Width:        rti(k.Attrs["width"]),
+				Height:       rti(k.Attrs["height"]),
+				Rows:         rtp(k.Attrs["rows"]),
+				Help:         plain(k.Attrs["help"]),
+				HelpMarkdown: k.Attrs["help"],
+				HelpHTML:     markdown(k.Attrs["help"]),
+				Line:         k.Line,
+				Tag:          k.Hash,
+				Opt:          k.Opt,
+				When:         k.Cond,
+			}
+
+		case NDateField:
+			return &JDateField{
+				Kind:         "date",
+				ID:           k.ID,
+				Label:        k.Attrs["label"],
+				Required:     k.Attrs["required"] == "t",
+				Min:          k.Attrs["min"],
+				Max:          k.Attrs["max"],
+				Width:        rti(k.Attrs["width"]),
+				Height:       rti(k.Attrs["height"]),
+				Help:         plain(k.Attrs["help"]),
+				HelpMarkdown: k.Attrs["help"],
+				HelpHTML:     markdown(k.Attrs["help"]),
+				Line:         k.Line,
+				Tag:          k.Hash,
+				Opt:          k.Opt,
+				When:         k.Cond,
+			}
+
+		case NEmailField:
+			return &JEmailField{
+				Kind:         "email",
+				ID:           k.ID,
+				Label:        k.Attrs["label"],
+				Required:     k.Attrs["required"] == "t",
+				Default:      k.Attrs["default"],
+				Placeholder:  k.Attrs["placeholder"],
+				Width:        rti(k.Attrs["width"]),
+				Height:       rti(k.Attrs["height"]),
+				Help:         plain(k.Attrs["help"]),
+				HelpMarkdown: k.Attrs["help"],
+				HelpHTML:     markdown(k.Attrs["help"]),
+				Line:         k.Line,
+				Tag:          k.Hash,
+				Opt:          k.Opt,
+				When:         k.Cond,
+			}
+
+		case NPhoneField:
+			return &JPhoneField{
+				Kind:         "phone",
+				ID:           k.ID,
+				Label:        k.Attrs["label"],
+				Required:     k.Attrs["required"] == "t",
+				Default:      k.Attrs["default"],
+				Placeholder:  k.Attrs["placeholder"],
+				Width:        rti(k.Attrs["width"]),
+				Height:       rti(k.Attrs["height"]),
+				Help:         plain(k.Attrs["help"]),
+				HelpMarkdown: k.Attrs["help"],
+				HelpHTML:     markdown(k.Attrs["help"]),
+				Line:         k.Line,
+				Tag:          k.Hash,
+				Opt:          k.Opt,
+				When:         k.Cond,
+			}
+
+		case NFileField:
+			return &JFileField{
+				Kind:         "file",
+				ID:           k.ID,
+				Label:        k.Attrs["label"],
+				Required:     k.Attrs["required"] == "t",
+				Accept:       strings.Fields(k.Attrs["accept"]),
+				MaxSize:      rtp(k.Attrs["maxsize"]),
+				Width:        rti(k.Attrs["width"]),
+				Height:       rti(k.Attrs["height"]),
+				Help:         plain(k.Attrs["help"]),
+				HelpMarkdown: k.Attrs["help"],
+				HelpHTML:     markdown(k.Attrs["help"]),
+				Line:         k.Line,
+				Tag:          k.Hash,
+				Opt:          k.Opt,
+				When:         k.Cond,
+			}
+
+		case NRepeat:
+			r := &JRepeat{
+				Kind:         "repeat",
+				ID:           k.ID,
+				Label:        k.Attrs["label"],
+				Min:          rti(k.Attrs["min"]),
+				Max:          rtp(k.Attrs["max"]),
+				Keys:         map[string]string{},
+				Help:         plain(k.Attrs["help"]),
+				HelpMarkdown: k.Attrs["help"],
+				HelpHTML:     markdown(k.Attrs["help"]),
+				Line:         k.Line,
+				Tag:          k.Hash,
+				Opt:          k.Opt,
+				When:         k.Cond,
+			}
+
+			// what each field is keyed by in a row of the answer
+			for _, f := range k.Fields() {
+				r.Keys[f.ID] = k.rowKey(f)
+			}
+
+			for _, kid := range k.Children {
+				if j := handle(kid); j != nil {
+					r.Children = append(r.Children, j)
+				}
+			}
+
+			return r
+
+		case NComputedField:
+			ast, _ := parseExpr(k.Attrs["expr"])
+			return &JComputedField{
+				Kind:         "computed",
+				ID:           k.ID,
+				Label:        k.Attrs["label"],
+				Expr:         k.Attrs["expr"],
+				AST:          ast,
+				ValueType:    valueType(k, ids, map[*Node]bool{}),
+				Width:        rti(k.Attrs["width"]),
+				Help:         plain(k.Attrs["help"]),
+				HelpMarkdown: k.Attrs["help"],
+				HelpHTML:     markdown(k.Attrs["help"]),
+				Line:         k.Line,
+				Tag:          k.Hash,
+				Opt:          k.Opt,
+				When:         k.Cond,
 			}
 
 		case NCheckField:
@@ -164,12 +552,34 @@ func (n *Node) JSON() string {
 				checked = true
 			}
 			return &JCheckField{
-				Kind:    "check",
-				Label:   k.Attrs["label"],
-				Checked: checked,
-				Line:    k.Line,
-				Tag:     k.Hash,
-				Opt:     k.Opt,
+				Kind:         "check",
+				ID:           k.ID,
+				Label:        k.Attrs["label"],
+				Required:     k.Attrs["required"] == "t",
+				Checked:      checked,
+				Help:         plain(k.Attrs["help"]),
+				HelpMarkdown: k.Attrs["help"],
+				HelpHTML:     markdown(k.Attrs["help"]),
+				Line:         k.Line,
+				Tag:          k.Hash,
+				Opt:          k.Opt,
+				When:         k.Cond,
+			}
+
+		case NSwitchField:
+			return &JSwitchField{
+				Kind:         "switch",
+				ID:           k.ID,
+				Label:        k.Attrs["label"],
+				Required:     k.Attrs["required"] == "t",
+				On:           k.Attrs["on"] == "t",
+				Help:         plain(k.Attrs["help"]),
+				HelpMarkdown: k.Attrs["help"],
+				HelpHTML:     markdown(k.Attrs["help"]),
+				Line:         k.Line,
+				Tag:          k.Hash,
+				Opt:          k.Opt,
+				When:         k.Cond,
 			}
 
 		case NRadioField:
@@ -178,31 +588,70 @@ func (n *Node) JSON() string {
 				checked = true
 			}
 			return &JRadioField{
-				Kind:     "radio",
-				Label:    k.Attrs["label"],
-				Selected: checked,
-				Line:     k.Line,
-				Tag:      k.Hash,
-				Opt:      k.Opt,
+				Kind:         "radio",
+				ID:           k.ID,
+				Label:        k.Attrs["label"],
+				Required:     k.Attrs["required"] == "t",
+				Selected:     checked,
+				Help:         plain(k.Attrs["help"]),
+				HelpMarkdown: k.Attrs["help"],
+				HelpHTML:     markdown(k.Attrs["help"]),
+				Line:         k.Line,
+				Tag:          k.Hash,
+				Opt:          k.Opt,
+				When:         k.Cond,
 			}
 
 		case NDropField:
 			drop := &JDropField{
-				Kind:  "select",
-				Label: k.Attrs["label"],
-				Line:  k.Line,
-				Tag:   k.Hash,
-				Opt:   k.Opt,
+				Kind:         "select",
+				ID:           k.ID,
+				Label:        k.Attrs["label"],
+				Required:     k.Attrs["required"] == "t",
+				Help:         plain(k.Attrs["help"]),
+				HelpMarkdown: k.Attrs["help"],
+				HelpHTML:     markdown(k.Attrs["help"]),
+				Line:         k.Line,
+				Tag:          k.Hash,
+				Opt:          k.Opt,
+				When:         k.Cond,
 			}
 
 			for _, dcur := range k.Children {
 				drop.Options = append(drop.Options, dcur.Text)
 			}
+			drop.Labels = optionLabels(k)
 
 			return drop
+
+		case NChoiceField:
+			choice := &JChoiceField{
+				Kind:         "choice",
+				ID:           k.ID,
+				Label:        k.Attrs["label"],
+				Required:     k.Attrs["required"] == "t",
+				Help:         plain(k.Attrs["help"]),
+				HelpMarkdown: k.Attrs["help"],
+				HelpHTML:     markdown(k.Attrs["help"]),
+				Line:         k.Line,
+				Tag:          k.Hash,
+				Opt:          k.Opt,
+				When:         k.Cond,
+			}
+
+			for _, ccur := range k.Children {
+				choice.Options = append(choice.Options, ccur.Text)
+				if ccur.Attrs["selected"] == "t" {
+					choice.Default = ccur.Text
+				}
+			}
+			choice.Labels = optionLabels(k)
+
+			return choice
 		}
 
-		panic(fmt.Sprintf("notreached: %d", k.Kind))
+		// nothing else turns up in a parsed tree; leave it out
+		// rather than emit something FromJSON can't read back
 		return nil
 	}
 
@@ -211,20 +660,33 @@ func (n *Node) JSON() string {
 		case NPage:
 			p := &JPage{
 				Kind:  "page",
+				ID:    cur.ID,
 				Label: cur.Attrs["label"],
+				Tag:   cur.Hash,
+				Opt:   cur.Opt,
+				When:  cur.Cond,
 			}
 
 			for _, pcur := range cur.Children {
-				p.Children = append(p.Children, handle(pcur))
+				if j := handle(pcur); j != nil {
+					p.Children = append(p.Children, j)
+				}
 			}
 
 			d.Children = append(d.Children, p)
 
 		default:
-			d.Children = append(d.Children, handle(cur))
+			if j := handle(cur); j != nil {
+				d.Children = append(d.Children, j)
+			}
 		}
 	}
 
-	buf, _ := json.MarshalIndent(d, "", "  ")
-	return string(buf)
+	// <, > and & stay escaped, so the JSON can go in a <script> too
+	b := &bytes.Buffer{}
+	enc := json.NewEncoder(b)
+	enc.SetIndent("", "  ")
+	enc.Encode(d)
+
+	return strings.TrimSuffix(b.String(), "\n")
 }
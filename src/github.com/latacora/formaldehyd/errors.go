@@ -0,0 +1,116 @@
+package formaldehyd
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// A ParseError is a problem with the form source, pinned to where it
+// happened, with the column counted in characters. Warnings are
+// problems the parser could work around.
+type ParseError struct {
+	Line    int    `json:"line"`
+	Column  int    `json:"column"`
+	Excerpt string `json:"excerpt"`
+	Message string `json:"message"`
+	Warning bool   `json:"warning"`
+}
+
+func (e *ParseError) Error() string {
+	w := &bytes.Buffer{}
+
+	if e.Warning {
+		w.WriteString("warning: ")
+	}
+
+	switch {
+	case e.Line > 0 && e.Column > 0:
+		fmt.Fprintf(w, "line %d, column %d: %s", e.Line, e.Column, e.Message)
+	case e.Line > 0:
+		fmt.Fprintf(w, "line %d: %s", e.Line, e.Message)
+	default:
+		w.WriteString(e.Message)
+	}
+
+	if e.Excerpt == "" {
+		return w.String()
+	}
+
+	fmt.Fprintf(w, "\n    %s", e.Excerpt)
+
+	if e.Column > 0 {
+		// keep tabs so the caret lines up under them
+		caret := []rune{}
+		for i, r := range []rune(e.Excerpt) {
+			if i >= e.Column-1 {
+				break
+			}
+
+			if r == '\t' {
+				caret = append(caret, '\t')
+			} else {
+				caret = append(caret, ' ')
+			}
+		}
+
+		fmt.Fprintf(w, "\n    %s^", string(caret))
+	}
+
+	return w.String()
+}
+
+// ParseErrors is every problem found in a form.
+type ParseErrors []*ParseError
+
+func (errs ParseErrors) Error() string {
+	msgs := []string{}
+	for _, e := range errs {
+		msgs = append(msgs, e.Error())
+	}
+
+	return strings.Join(msgs, "\n\n")
+}
+
+// Errors returns just the errors, leaving out warnings.
+func (errs ParseErrors) Errors() (ret ParseErrors) {
+	for _, e := range errs {
+		if !e.Warning {
+			ret = append(ret, e)
+		}
+	}
+
+	return ret
+}
+
+// Warnings returns just the warnings.
+func (errs ParseErrors) Warnings() (ret ParseErrors) {
+	for _, e := range errs {
+		if e.Warning {
+			ret = append(ret, e)
+		}
+	}
+
+	return ret
+}
+
+// excerpt fills in the source line for errors that don't have one.
+func (errs ParseErrors) excerpt(buf []byte) {
+	lines := strings.Split(string(buf), "\n")
+
+	for _, e := range errs {
+		if e.Excerpt == "" && e.Line > 0 && e.Line <= len(lines) {
+			e.Excerpt = strings.TrimRight(lines[e.Line-1], "\r")
+		}
+	}
+}
+
+// nodeError is a ParseError for a problem with a node found after
+// parsing.
+func nodeError(n *Node, format string, args ...interface{}) *ParseError {
+	return &ParseError{
+		Line:    n.Line,
+		Column:  n.Col,
+		Message: fmt.Sprintf(format, args...),
+	}
+}
@@ -0,0 +1,79 @@
+package formaldehyd
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseErrors(t *testing.T) {
+	src := `
+Page 1
+------
+
+Name [          ]
+
+Pick [x ]
+
+Broken [( 
+Also [ ] =
+
+Name [          ]
+`
+
+	n, errs := ParseAll([]byte(src))
+	if n == nil {
+		t.Fatalf("expected a partial tree")
+	}
+
+	errors := errs.Errors()
+	if len(errors) != 2 {
+		t.Fatalf("expected two errors, got %d: %s", len(errors), errs)
+	}
+
+	if e := errors[0]; e.Line != 9 || e.Column != 8 || !strings.Contains(e.Message, `got "[("`) {
+		t.Errorf("unexpected first error: %+v", e)
+	}
+
+	if e := errors[1]; e.Line != 12 || !strings.Contains(e.Message, "duplicate id") {
+		t.Errorf("unexpected second error: %+v", e)
+	}
+
+	warnings := errs.Warnings()
+	if len(warnings) != 1 || warnings[0].Line != 10 || warnings[0].Column != 10 {
+		t.Fatalf("expected a warning for the =, got %s", warnings)
+	}
+
+	msg := errors[1].Error()
+	if !strings.Contains(msg, "\n    Name [          ]\n         ^") {
+		t.Errorf("expected an excerpt with a caret, got:\n%s", msg)
+	}
+
+	// the fields around the errors still made it into the tree
+	labels := []string{}
+	for _, f := range n.Fields() {
+		labels = append(labels, f.Attrs["label"])
+	}
+
+	if strings.Join(labels, ",") != "Name,Pick,Also,Name" {
+		t.Errorf("unexpected fields: %v", labels)
+	}
+
+	if _, err := Parse([]byte(src)); err == nil {
+		t.Errorf("expected Parse to fail")
+	}
+}
+
+func TestParseErrorColumns(t *testing.T) {
+	// columns count characters, so the caret lands under the field
+	// whatever the label is written in
+	_, err := Parse([]byte("Prénom [          ]\n\nPrénom [          ]\n"))
+
+	errs, _ := err.(ParseErrors)
+	if len(errs) != 1 || errs[0].Column != 8 {
+		t.Fatalf("expected one error at column 8, got %v", err)
+	}
+
+	if msg := errs[0].Error(); !strings.Contains(msg, "\n    Prénom [          ]\n           ^") {
+		t.Errorf("expected the caret under the box, got:\n%s", msg)
+	}
+}
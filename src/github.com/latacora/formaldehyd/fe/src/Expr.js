@@ -0,0 +1,124 @@
+// Evaluates the "ast" of a computed field against the answers given
+// so far, the same way the server does (see computed.go), so a total
+// can keep up as the form is filled in. The server works it out again
+// on submission; this is only for show.
+
+// ------------------------------------------------------------
+
+function toNumber(v) {
+  if (v === true) {
+    return 1;
+  }
+  if (v === false || v === undefined || v === null || v === '') {
+    return 0;
+  }
+
+  let n = parseFloat(v);
+  return isNaN(n) ? null : n;
+}
+
+function truthy(v) {
+  return !(v === undefined || v === null || v === false || v === '' || v === 0);
+}
+
+function evaluate(e, answers) {
+  switch (e.op) {
+    case 'num':
+      return e.value;
+    case 'str':
+      return e.value || '';
+    case 'bool':
+      return e.value || false;
+    case 'field':
+      return answers[e.field];
+    default:
+  }
+
+  let args = (e.args || []).map((a) => evaluate(a, answers));
+
+  switch (e.op) {
+    case 'and':
+      return truthy(args[0]) && truthy(args[1]);
+    case 'or':
+      return truthy(args[0]) || truthy(args[1]);
+    case 'not':
+      return !truthy(args[0]);
+    case 'if':
+      return truthy(args[0]) ? args[1] : args[2];
+    default:
+  }
+
+  if (typeof args[0] === 'string' && typeof args[1] === 'string' && isNaN(parseFloat(args[0]))) {
+    switch (e.op) {
+      case '=':
+        return args[0] === args[1];
+      case '!=':
+        return args[0] !== args[1];
+      case '<':
+        return args[0] < args[1];
+      case '<=':
+        return args[0] <= args[1];
+      case '>':
+        return args[0] > args[1];
+      case '>=':
+        return args[0] >= args[1];
+      default:
+        return null;
+    }
+  }
+
+  let n = args.map(toNumber);
+  if (n.some((x) => x === null)) {
+    return null;
+  }
+
+  let ret;
+  switch (e.op) {
+    case '=':
+      return n[0] === n[1];
+    case '!=':
+      return n[0] !== n[1];
+    case '<':
+      return n[0] < n[1];
+    case '<=':
+      return n[0] <= n[1];
+    case '>':
+      return n[0] > n[1];
+    case '>=':
+      return n[0] >= n[1];
+    case 'neg':
+      ret = -n[0];
+      break;
+    case '+':
+      ret = n[0] + n[1];
+      break;
+    case '-':
+      ret = n[0] - n[1];
+      break;
+    case '*':
+      ret = n[0] * n[1];
+      break;
+    case '/':
+      ret = n[0] / n[1];
+      break;
+    case '%':
+      ret = n[0] % n[1];
+      break;
+    case 'min':
+      ret = Math.min(...n);
+      break;
+    case 'max':
+      ret = Math.max(...n);
+      break;
+    case 'round':
+      let scale = Math.pow(10, Math.round(n[1] || 0));
+      ret = Math.round(n[0] * scale) / scale;
+      break;
+    default:
+      return null;
+  }
+
+  return isFinite(ret) ? ret : null;
+}
+
+export { evaluate };
@@ -13,15 +13,22 @@ import Paragraph from 'grommet/components/Paragraph';
 import Heading from 'grommet/components/Heading';
 
 import TextField from './TextField';
+import { evaluate } from './Expr';
 
 class Textfield extends BaseComponent {
   state = {
     value: this.props.obj.default,
   }
 
-  onChange = (event) => this.setState({
-    value: event.target.value
-  });
+  onChange = (event) => {
+    this.setState({
+      value: event.target.value
+    });
+
+    if (this.props.onAnswer) {
+      this.props.onAnswer(this.props.obj.id, event.target.value);
+    }
+  }
 
 
   render() {
@@ -30,7 +37,10 @@ class Textfield extends BaseComponent {
         <TextField value={ this.state.value }
                    onChange={ this.onChange }
                    height={ parseInt(this.props.obj.height || "1") }
-                   legend={ this.props.obj.label } />
+                   legend={ this.props.obj.label }
+                   placeholder={ this.props.obj.placeholder }
+                   help={ this.props.obj.helpmarkdown &&
+                          <Markdown content={ this.props.obj.helpmarkdown } /> } />
       </div>
       );
   }
@@ -55,11 +65,73 @@ class RadioField extends BaseComponent {
   }
 }
 
+// a computed field keeps up with the answers on the page; see Expr.js
+const Computed = function(props) {
+  let value = evaluate(props.obj.ast, props.answers || {});
+
+  return (
+    <Box pad={ {horizontal: 'medium', vertical: 'small'} }>
+      <legend>
+        { props.obj.label }
+      </legend>
+      <output data-expr={ props.obj.expr }>
+        { value === null || value === undefined ? '' : String(value) }
+      </output>
+      { props.obj.helpmarkdown && <Markdown content={ props.obj.helpmarkdown } /> }
+    </Box>
+    );
+}
+
+// a repeat is answered as a list of rows, each an object keyed by
+// obj.keys; rows can be added up to max and taken away down to min
+class Repeat extends BaseComponent {
+  state = {
+    rows: Array.from({ length: Math.max(this.props.obj.min, 1) }, () => ({})),
+  }
+
+  update = (rows) => {
+    this.setState({ rows: rows });
+
+    if (this.props.onAnswer) {
+      this.props.onAnswer(this.props.obj.id, rows);
+    }
+  }
+
+  onAnswer = (i) => (id, value) => this.update(this.state.rows.map((row, j) =>
+    j === i ? Object.assign({}, row, { [this.props.obj.keys[id]]: value }) : row
+  ));
+
+  add = () => this.update(this.state.rows.concat([{}]));
+
+  remove = (i) => () => this.update(this.state.rows.filter((row, j) => j !== i));
+
+  render() {
+    let obj = this.props.obj;
+    let full = obj.max !== null && this.state.rows.length >= obj.max;
+
+    return (
+      <Box pad={ {horizontal: 'medium', vertical: 'small'} }>
+        <legend>
+          { obj.label }
+        </legend>
+        { obj.helpmarkdown && <Markdown content={ obj.helpmarkdown } /> }
+        { this.state.rows.map((row, i) => (
+          <Box key={ i } pad={ {vertical: 'small'} }>
+            <Page children={ obj.children } onAnswer={ this.onAnswer(i) } />
+            { this.state.rows.length > obj.min &&
+              <button type="button" onClick={ this.remove(i) }>Remove</button> }
+          </Box>
+        )) }
+        { !full && <button type="button" onClick={ this.add }>Add another</button> }
+      </Box>
+      );
+  }
+}
+
 const Text = function(props) {
   return (
-    <Paragraph size="large">
-      { props.obj.text }
-    </Paragraph>
+    <Markdown content={ props.obj.markdown || props.obj.text }
+      components={{ p: { props: { size: 'large' } } }} />
     );
 }
 
@@ -73,6 +145,18 @@ const Head = function(props) {
 
 export default class Page extends BaseComponent {
   state = {
+    answers: {},
+  }
+
+  onAnswer = (id, value) => {
+    this.setState((prev) => ({
+      answers: Object.assign({}, prev.answers, { [id]: value })
+    }));
+
+    // a page in a row of a repeat passes its answers up
+    if (this.props.onAnswer) {
+      this.props.onAnswer(id, value);
+    }
   }
 
   constructor(props) {
@@ -84,7 +168,15 @@ export default class Page extends BaseComponent {
       switch (kid.type) {
         case 'textfield':
           return <Textfield obj={ kid }
-                            parent={ this } />;
+                            parent={ this }
+                            onAnswer={ this.onAnswer } />;
+        case 'repeat':
+          return <Repeat obj={ kid }
+                         parent={ this }
+                         onAnswer={ this.onAnswer } />;
+        case 'computed':
+          return <Computed obj={ kid }
+                           answers={ this.state.answers } />;
         case 'text':
           return <Text obj={ kid }
                        parent={ this } />;
@@ -19,9 +19,10 @@ export default class TextField extends BaseComponent {
     let inner;
     if (this.props.height > 1) {
       inner = (
-        <FormField label={ this.props.help }>
+        <FormField help={ this.props.help }>
           <textarea rows={ this.props.height }
                     type='text'
+                    placeholder={ this.props.placeholder }
                     onChange={ this.props.onChange } />
         </FormField>
       );
@@ -31,6 +32,7 @@ export default class TextField extends BaseComponent {
         <FormField help={ this.props.help }>
           <TextInput id='text-input'
                      value={ this.props.value }
+                     placeholder={ this.props.placeholder }
                      onDOMChange={ this.props.onChange } />
         </FormField>
       );
@@ -50,6 +52,7 @@ export default class TextField extends BaseComponent {
 TextField.propTypes = {
   legend: PropTypes.string,
   help: PropTypes.node,
+  placeholder: PropTypes.string,
   value: PropTypes.string.isRequired,
   onChange: PropTypes.func.isRequired,
   height: PropTypes.number.isRequired,
@@ -1,8 +1,12 @@
 package formaldehyd
 
 import (
+	"flag"
 	"fmt"
 	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -24,20 +28,82 @@ func ok(t *testing.T, err error) bool {
 	return true
 }
 
-func TestScrap(t *testing.T) {
-	buf := fixture("form.1")
+// Every fixtures/*.form is parsed, and what comes out is checked
+// against the golden files next to it: the tree (.ast) and its JSON
+// (.json) if it parses, and the errors and warnings (.err) if there
+// are any. Forms that parse also have to survive a round trip
+// through Format. Run with -update to rewrite the golden files after
+// a change to the parser; then look at the diff.
+var update = flag.Bool("update", false, "rewrite the golden files in fixtures/")
 
-	tokens := tokenize(buf)
-	_ = tokens
+func golden(src []byte) map[string]string {
+	n, errs := ParseAll(src)
 
-	// for _, t := range tokens {
-	//  	dbg("%s", ToString(buf, t))
-	// }
+	ret := map[string]string{".ast": "", ".json": "", ".err": ""}
 
-	n, err := Parse(buf)
+	if len(errs) > 0 {
+		ret[".err"] = errs.Error() + "\n"
+	}
+
+	if len(errs.Errors()) == 0 {
+		ret[".ast"] = n.String()
+		ret[".json"] = n.JSON() + "\n"
+	}
+
+	return ret
+}
+
+func TestGolden(t *testing.T) {
+	forms, err := filepath.Glob("fixtures/*.form")
 	ok(t, err)
 
-	dbg("%s", n.String())
+	if len(forms) == 0 {
+		t.Fatalf("no fixtures")
+	}
+
+	for _, form := range forms {
+		form := form
+		base := strings.TrimSuffix(form, ".form")
+
+		t.Run(filepath.Base(base), func(t *testing.T) {
+			src, err := ioutil.ReadFile(form)
+			ok(t, err)
 
-	dbg("%s", n.JSON())
+			for ext, got := range golden(src) {
+				path := base + ext
+
+				if *update {
+					if got == "" {
+						os.Remove(path)
+						continue
+					}
+
+					ok(t, ioutil.WriteFile(path, []byte(got), 0644))
+					continue
+				}
+
+				expect, err := ioutil.ReadFile(path)
+				switch {
+				case os.IsNotExist(err) && got == "":
+					continue
+
+				case os.IsNotExist(err):
+					t.Errorf("no %s; run with -update to create it. got:\n%s", path, got)
+
+				case err != nil:
+					t.Fatal(err)
+
+				case got == "":
+					t.Errorf("expected %s, got nothing", path)
+
+				case string(expect) != got:
+					t.Errorf("%s doesn't match; expected:\n%s\ngot:\n%s", path, expect, got)
+				}
+			}
+
+			if _, err := Parse(src); err == nil {
+				roundTrip(t, src)
+			}
+		})
+	}
 }
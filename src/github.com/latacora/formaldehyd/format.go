@@ -0,0 +1,458 @@
+package formaldehyd
+
+import (
+	"bytes"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// Format renders a tree back into form source, laid out the same way
+// every time: each field on its own line, runs of fields with their
+// boxes lined up, blank lines between everything else. Parsing the
+// output of Format gives back a tree Equivalent to the one Parse
+// produced in the first place.
+func Format(root *Node) []byte {
+	f := &formatter{}
+	f.nodes(root.Children)
+	f.setOpt("")
+	return f.w.Bytes()
+}
+
+// Equivalent reports whether two trees describe the same form,
+// ignoring where in the source things came from.
+func Equivalent(a, b *Node) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+
+	if a.Kind != b.Kind || a.Text != b.Text || a.ID != b.ID || a.Hash != b.Hash || a.Opt != b.Opt {
+		return false
+	}
+
+	if !reflect.DeepEqual(a.Cond, b.Cond) {
+		return false
+	}
+
+	if len(a.Attrs) != len(b.Attrs) || len(a.Children) != len(b.Children) {
+		return false
+	}
+
+	for k, v := range a.Attrs {
+		if bv, ok := b.Attrs[k]; !ok || bv != v {
+			return false
+		}
+	}
+
+	for i := range a.Children {
+		if !Equivalent(a.Children[i], b.Children[i]) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// lines that can sit directly under each other; everything else gets
+// a blank line around it
+const (
+	runNone = iota
+	runField
+	runButton
+)
+
+type formatter struct {
+	w     bytes.Buffer
+	opt   string
+	run   int
+	width int
+	radio bool
+}
+
+func (f *formatter) line(s string) {
+	f.w.WriteString(s)
+	f.w.WriteByte('\n')
+}
+
+func (f *formatter) block(run int) {
+	if f.w.Len() > 0 && (run == runNone || run != f.run) {
+		f.w.WriteByte('\n')
+	}
+
+	f.run = run
+}
+
+// setOpt closes the current ~~~ block, if there is one, and opens a
+// new one if the next node needs it.
+func (f *formatter) setOpt(opt string) {
+	if opt == f.opt {
+		return
+	}
+
+	if f.opt != "" {
+		f.block(runNone)
+		f.line(strings.Repeat("~", len(f.opt)+4))
+	}
+
+	if opt != "" {
+		f.block(runNone)
+		f.line("~" + opt + "~~~")
+	}
+
+	f.opt = opt
+}
+
+// tag puts a node's #tag on the line above it, since a tag names
+// whatever comes after it.
+func (f *formatter) tag(n *Node) {
+	if n.Hash != "" {
+		f.line("#" + n.Hash)
+	}
+}
+
+func (f *formatter) nodes(ns []*Node) {
+	for i, n := range ns {
+		f.setOpt(n.Opt)
+
+		switch {
+		case n.Kind == NPage:
+			label := n.Attrs["label"]
+
+			f.block(runNone)
+			f.tag(n)
+			f.line(label)
+			f.line(strings.Repeat("-", maxInt(len(label), 3)))
+			f.nodes(n.Children)
+
+		case n.Kind == NText:
+			f.block(runNone)
+			f.tag(n)
+			f.line(n.Text)
+
+		case n.Kind == NHeading:
+			f.block(runNone)
+			f.tag(n)
+			f.line("# " + n.Text)
+
+		case n.Kind == NButton:
+			f.block(runButton)
+			f.tag(n)
+			f.line("[( " + n.Text + " )]" + trailer(n))
+
+		case n.Kind == NDropField:
+			f.block(runNone)
+			f.tag(n)
+			f.dropdown(n)
+
+		case n.Kind == NChoiceField:
+			f.block(runNone)
+			f.tag(n)
+			f.choice(n)
+
+		case n.Kind == NRepeat:
+			f.repeat(n)
+
+		case inline(n):
+			// two radio buttons in a row would read back as a choice
+			if n.Kind == NRadioField && f.radio {
+				f.run = runNone
+			}
+
+			if f.run != runField {
+				f.width = runWidth(ns[i:])
+			}
+
+			f.block(runField)
+			f.tag(n)
+			f.line(pad(n.Attrs["label"], f.width) + " " + widget(n) + trailer(n))
+
+		case boxed(n):
+			f.block(runNone)
+			f.tag(n)
+			f.textArea(n)
+		}
+
+		// a repeat's help goes under its first line, not its last
+		if n.Kind != NRepeat {
+			f.help(n)
+		}
+		f.radio = n.Kind == NRadioField
+	}
+}
+
+// inline fields fit on one line, and runs of them get lined up.
+func inline(n *Node) bool {
+	switch n.Kind {
+	case NCheckField, NRadioField, NSwitchField, NComputedField:
+		return true
+	}
+
+	return boxed(n) && atoi(n.Attrs["height"]) <= 1
+}
+
+// boxed fields are drawn as a text box, one line or several.
+func boxed(n *Node) bool {
+	switch n.Kind {
+	case NTextField, NNumberField, NDateField, NEmailField, NPhoneField, NFileField:
+		return true
+	}
+
+	return false
+}
+
+func runWidth(ns []*Node) (width int) {
+	for i, n := range ns {
+		if !inline(n) || n.Opt != ns[0].Opt {
+			break
+		}
+
+		if i > 0 && n.Kind == NRadioField && ns[i-1].Kind == NRadioField {
+			break
+		}
+
+		width = maxInt(width, len(n.Attrs["label"]))
+	}
+
+	return width
+}
+
+func widget(n *Node) string {
+	switch n.Kind {
+	case NCheckField:
+		if n.Attrs["checked"] != "" {
+			return "[*]"
+		}
+		return "[ ]"
+
+	case NRadioField:
+		if n.Attrs["selected"] != "" {
+			return "(*)"
+		}
+		return "( )"
+
+	case NSwitchField:
+		if n.Attrs["on"] != "" {
+			return "(*_)"
+		}
+		return "(_*)"
+
+	case NDateField:
+		return "[" + pad("yyyy-mm-dd", atoi(n.Attrs["width"])) + "]"
+
+	case NComputedField:
+		return "[" + pad("= "+n.Attrs["expr"]+" ", atoi(n.Attrs["width"])) + "]"
+
+	default:
+		b := box(n.Attrs["default"], atoi(n.Attrs["width"]))
+		if b == " " && n.Kind == NTextField {
+			// "[ ]" is a checkbox
+			b = "|"
+		}
+		return "[" + b + closer(n)
+	}
+}
+
+// closer is what ends the box of a field, saying what kind it is.
+func closer(n *Node) string {
+	switch n.Kind {
+	case NNumberField:
+		return spinner(n) + "]"
+	case NEmailField:
+		return "@]"
+	case NPhoneField:
+		return "#]"
+	case NFileField:
+		return "^]"
+	}
+
+	return "]"
+}
+
+// box pads a default out to the width of its field. A default
+// butting up against what follows it would get glued to it.
+func box(def string, width int) string {
+	if def != "" {
+		def += " "
+	}
+
+	return pad(def, width)
+}
+
+func spinner(n *Node) string {
+	if n.Attrs["slider"] != "" {
+		return "-o-"
+	}
+	return "+/-"
+}
+
+// textArea draws a multi-line text box, with the continuation lines
+// marked by a | under the opening bracket. The width of a box counts
+// everything between its brackets, newlines and bars included, so the
+// padding on the last line makes up the difference.
+func (f *formatter) textArea(n *Node) {
+	label := n.Attrs["label"]
+	def := n.Attrs["default"]
+	if n.Kind == NDateField {
+		def = "yyyy-mm-dd"
+	}
+	extra := atoi(n.Attrs["height"]) - 1
+	avail := atoi(n.Attrs["width"]) - len(def)
+
+	indent, bar := len(label)+1, "|"
+	for indent > 0 && extra*(indent+2) > avail {
+		indent--
+	}
+
+	if extra*(indent+2) > avail {
+		bar = ""
+	}
+
+	lines := []string{label + " [" + def}
+	for i := 0; i < extra; i++ {
+		lines = append(lines, strings.Repeat(" ", indent)+bar)
+	}
+
+	last := len(lines) - 1
+	lines[last] += strings.Repeat(" ", maxInt(avail-extra*(indent+len(bar)+1), 0))
+
+	lines[last] += closer(n) + trailer(n)
+
+	for _, l := range lines {
+		f.line(l)
+	}
+}
+
+// help puts a field's help on the lines under it, paragraphs
+// separated by a line with just a ? on it.
+func (f *formatter) help(n *Node) {
+	help := n.Attrs["help"]
+	if help == "" || !n.IsField() {
+		return
+	}
+
+	for _, l := range strings.Split(help, "\n") {
+		f.line(strings.TrimSpace("? " + l))
+	}
+}
+
+// repeat writes a repeat as a block between lines of +s. A ~~~ block
+// opened inside it is closed before it ends.
+func (f *formatter) repeat(n *Node) {
+	label := n.Attrs["label"]
+
+	f.block(runNone)
+	f.tag(n)
+	f.line("+ " + label + " +++" + trailer(n))
+	f.help(n)
+
+	f.nodes(n.Children)
+	f.setOpt(n.Opt)
+
+	f.block(runNone)
+	f.line(strings.Repeat("+", len(label)+6))
+}
+
+func (f *formatter) dropdown(n *Node) {
+	label := n.Attrs["label"]
+	indent := strings.Repeat(" ", len(label)+1)
+
+	width := 0
+	for _, kid := range n.Children {
+		width = maxInt(width, len(kid.Text))
+	}
+
+	rule := strings.Repeat("-", maxInt(width+2, 3))
+
+	f.line(label + " *" + rule)
+	for _, kid := range n.Children {
+		f.line(indent + "* " + kid.Text)
+	}
+	f.line(indent + rule + trailer(n))
+}
+
+// choice puts the question on its own line, with the options under it,
+// one to a line. Its #tag goes above the question, and its attribute
+// block after the last option.
+func (f *formatter) choice(n *Node) {
+	if label := n.Attrs["label"]; label != "" {
+		f.line(label)
+	}
+
+	width := 0
+	for _, kid := range n.Children {
+		width = maxInt(width, len(kid.Text))
+	}
+
+	for i, kid := range n.Children {
+		line := pad(kid.Text, width) + " ( )"
+		if kid.Attrs["selected"] != "" {
+			line = pad(kid.Text, width) + " (*)"
+		}
+
+		if i == len(n.Children)-1 {
+			line += trailer(n)
+		}
+
+		f.line(line)
+	}
+}
+
+// trailer is what follows a field or button on its line: its
+// attribute block.
+func trailer(n *Node) (ret string) {
+	items := []string{}
+
+	// buttons only say what they do if their label doesn't
+	if a := n.Attrs["action"]; a != "" && a != inferAction(n.Text) {
+		items = append(items, strings.TrimSpace(a+" "+n.Attrs["target"]))
+	}
+
+	if n.Attrs["required"] != "" {
+		items = append(items, "required")
+	}
+
+	for _, k := range []string{"min", "max", "step", "minlen", "maxlen", "rows", "maxsize"} {
+		if v := n.Attrs[k]; v != "" {
+			items = append(items, k+" "+v)
+		}
+	}
+
+	if v := n.Attrs["accept"]; v != "" {
+		items = append(items, `accept "`+exprQuote.Replace(v)+`"`)
+	}
+
+	if v := n.Attrs["placeholder"]; v != "" {
+		items = append(items, `placeholder "`+exprQuote.Replace(v)+`"`)
+	}
+
+	if v := n.Attrs["pattern"]; v != "" {
+		items = append(items, "pattern /"+strings.Replace(v, "/", `\/`, -1)+"/")
+	}
+
+	if len(items) > 0 {
+		ret += " {" + strings.Join(items, ", ") + "}"
+	}
+
+	return ret
+}
+
+func pad(s string, width int) string {
+	if len(s) >= width {
+		return s
+	}
+
+	return s + strings.Repeat(" ", width-len(s))
+}
+
+func atoi(s string) int {
+	ret, _ := strconv.Atoi(s)
+	return ret
+}
+
+// maxInt is the larger of a and b.
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,156 @@
+package formaldehyd
+
+import (
+	"testing"
+)
+
+func roundTrip(t *testing.T, src []byte) []byte {
+	t.Helper()
+
+	n, err := Parse(src)
+	ok(t, err)
+
+	out := Format(n)
+
+	m, err := Parse(out)
+	if err != nil {
+		t.Fatalf("formatted source doesn't parse: %s\n%s", err, out)
+	}
+
+	if !Equivalent(n, m) {
+		t.Fatalf("formatted source isn't equivalent:\n%s\nbefore:\n%s\nafter:\n%s", out, n, m)
+	}
+
+	if again := Format(m); string(again) != string(out) {
+		t.Fatalf("formatting isn't stable:\n%s\nthen:\n%s", out, again)
+	}
+
+	return out
+}
+
+func TestFormatFixture(t *testing.T) {
+	roundTrip(t, fixture("pages.form"))
+}
+
+func TestFormat(t *testing.T) {
+	src := `
+Some words up front.
+
+About you
+---------
+
+Name [                    ] {required, minlen 2, pattern /[a-z\/]+/}
+#age
+Age [ 30 +/-] {min 1, max 120}
+Bio [
+    |
+    |                 ]
+
+#color
+Color *-----
+      * red
+      * green
+        ish
+      -----
+
+#hi
+# Hello
+
+Subscribe (*_)
+
+~color is red~~~~~~~~~~~~~~~~
+
+Why red? [          ]
+Really? [*]
+
+~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+
+Closing text before the next page.
+
+Done
+----
+
+Thanks.
+#go
+[( Submit )]
+[( Cancel )]
+
+Trailing text.
+`
+
+	want := `Some words up front.
+
+About you
+---------
+
+Name [                    ] {required, minlen 2, pattern /[a-z\/]+/}
+#age
+Age  [30  +/-] {min 1, max 120}
+
+Bio [
+    |
+    |                 ]
+
+#color
+Color *-----------
+      * red
+      * green ish
+      -----------
+
+#hi
+# Hello
+
+Subscribe (*_)
+
+~color is red~~~
+
+Why red? [          ]
+Really?  [*]
+
+~~~~~~~~~~~~~~~~
+
+Closing text before the next page.
+
+Done
+----
+
+Thanks.
+
+#go
+[( Submit )]
+[( Cancel )]
+
+Trailing text.
+`
+
+	if out := roundTrip(t, []byte(src)); string(out) != want {
+		t.Fatalf("unexpected format:\n%s", out)
+	}
+}
+
+func TestFormatQuotes(t *testing.T) {
+	src := []byte(`Dir  [          ] {placeholder "C:\\"}
+Say  [          ] {placeholder "a}b \"c\""}
+CV   [          ^] {maxsize 100, accept ".pdf, .doc"}
+Code [          ] {pattern /[a-z}\/\\]+/}
+`)
+
+	n, err := Parse(src)
+	ok(t, err)
+
+	ids := n.index()
+	for id, want := range map[string][2]string{
+		"dir":  {"placeholder", `C:\`},
+		"say":  {"placeholder", `a}b "c"`},
+		"cv":   {"accept", ".pdf, .doc"},
+		"code": {"pattern", `[a-z}/\\]+`},
+	} {
+		if got := ids[id].Attrs[want[0]]; got != want[1] {
+			t.Errorf("expected %s %s to be %q, got %q", id, want[0], want[1], got)
+		}
+	}
+
+	if out := roundTrip(t, src); string(out) != string(src) {
+		t.Errorf("expected the form back as it was, got\n%s", out)
+	}
+}
@@ -0,0 +1,114 @@
+package formaldehyd
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// FuzzParse throws arbitrary source at the tokenizer and parser. Run it
+// with
+//
+//	go test -fuzz FuzzParse
+//
+// Inputs that break it land in testdata/fuzz/FuzzParse, and are run
+// as ordinary tests from then on; keep them.
+func FuzzParse(f *testing.F) {
+	forms, _ := filepath.Glob("fixtures/*.form")
+	for _, form := range forms {
+		buf, err := ioutil.ReadFile(form)
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(buf)
+	}
+
+	f.Fuzz(func(t *testing.T, src []byte) {
+		checkTokens(t, src)
+
+		n, errs := ParseAll(src)
+		if n == nil {
+			t.Fatalf("no tree")
+		}
+
+		lines := strings.Count(string(src), "\n") + 1
+		checkLines(t, n, lines)
+
+		for _, e := range errs {
+			if e.Line < 0 || e.Line > lines {
+				t.Fatalf("error on line %d of %d: %s", e.Line, lines, e)
+			}
+		}
+
+		j := n.JSON()
+		if !json.Valid([]byte(j)) {
+			t.Fatalf("JSON doesn't marshal:\n%s", j)
+		}
+
+		if len(errs.Errors()) > 0 {
+			return
+		}
+
+		if _, err := FromJSON([]byte(j)); err != nil {
+			t.Fatalf("JSON doesn't read back: %s\n%s", err, j)
+		}
+
+		Format(n)
+	})
+}
+
+// checkTokens makes sure the tokens cover the whole of src, in order,
+// with nothing left out but carriage returns.
+func checkTokens(t *testing.T, src []byte) {
+	end := 0
+
+	for i, tok := range tokenize(src) {
+		if tok.Start >= tok.End {
+			t.Fatalf("token %d is empty, at %d", i, tok.Start)
+		}
+
+		for ; end < tok.Start; end++ {
+			if src[end] != '\r' {
+				t.Fatalf("token %d starts at %d, skipping %q", i, tok.Start, src[end:tok.Start])
+			}
+		}
+
+		if tok.Start != end {
+			t.Fatalf("token %d starts at %d, overlapping the last one", i, tok.Start)
+		}
+
+		end = tok.End
+	}
+
+	for ; end < len(src); end++ {
+		if src[end] != '\r' {
+			t.Fatalf("tokens stop at %d of %d", end, len(src))
+		}
+	}
+}
+
+// checkLines makes sure the nodes in a tree are in the order they came
+// in the source, and that they came from somewhere in it.
+func checkLines(t *testing.T, root *Node, lines int) {
+	last := 0
+
+	var walk func(*Node)
+	walk = func(n *Node) {
+		for _, kid := range n.Children {
+			if kid.Parent != n {
+				t.Fatalf("%s on line %d has the wrong parent", nodeNames[kid.Kind], kid.Line)
+			}
+
+			if kid.Line < last || kid.Line > lines {
+				t.Fatalf("%s on line %d comes after line %d (of %d):\n%s", nodeNames[kid.Kind], kid.Line, last, lines, root)
+			}
+
+			last = kid.Line
+			walk(kid)
+		}
+	}
+
+	walk(root)
+}
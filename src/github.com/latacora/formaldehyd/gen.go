@@ -0,0 +1,300 @@
+package formaldehyd
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"path"
+	"strings"
+	"unicode"
+)
+
+// GoOptions control the Go source GoSource generates.
+type GoOptions struct {
+	// Package is the package the file is in.
+	Package string
+
+	// Type is the name of the struct; enum types and constants for
+	// options are named after it too. It defaults to the name of the
+	// Source file, or Submission.
+	Type string
+
+	// Source is the file the form came from, for the header comment.
+	Source string
+}
+
+// goName turns an ID (or a label, or an option) into an exported Go
+// identifier: "page-2.first-name" is "Page2FirstName".
+func goName(s string) string {
+	var b strings.Builder
+
+	up := true
+	for _, r := range s {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			up = true
+			continue
+		}
+
+		if up {
+			r = unicode.ToUpper(r)
+			up = false
+		}
+
+		b.WriteRune(r)
+	}
+
+	ret := b.String()
+	if ret != "" && !unicode.IsLetter([]rune(ret)[0]) {
+		ret = "F" + ret
+	}
+
+	return ret
+}
+
+// goField is a struct field GoSource generates for a form field.
+type goField struct {
+	node *Node
+	key  string // what the answer is keyed by
+	name string
+	typ  string
+	enum []goConst
+
+	// a repeat is a slice of structs of its own, one per row
+	row    []*goField
+	rowTyp string
+}
+
+type goConst struct {
+	name  string
+	value string
+}
+
+// goFields names the struct fields for a form: after the last part of
+// a field's ID if that's unique, and after the whole ID if it isn't.
+// The fields of a row of a repeat go the same way, after what they're
+// keyed by in the row.
+func goFields(n *Node, typ string) (ret []*goField) {
+	return goFieldsOf(n.Fields(), n.index(), func(f *Node) string { return f.ID }, typ)
+}
+
+func goFieldsOf(fields []*Node, ids map[string]*Node, key func(*Node) string, typ string) (ret []*goField) {
+	short := func(f *Node) string {
+		k := key(f)
+		return goName(k[strings.LastIndex(k, ".")+1:])
+	}
+
+	count := map[string]int{}
+	for _, f := range fields {
+		count[short(f)]++
+	}
+
+	for _, f := range fields {
+		g := &goField{node: f, key: key(f), name: short(f)}
+		if count[g.name] > 1 {
+			g.name = goName(g.key)
+		}
+
+		switch f.Kind {
+		case NTextField, NEmailField, NPhoneField:
+			g.typ = "string"
+
+		case NDateField:
+			g.typ = "time.Time"
+
+		case NFileField:
+			g.typ = "*formaldehyd.File"
+
+		case NNumberField:
+			g.typ = "int"
+
+		case NCheckField, NRadioField, NSwitchField:
+			g.typ = "bool"
+
+		case NRepeat:
+			g.rowTyp = typ + g.name + "Row"
+			g.typ = "[]" + g.rowTyp
+			g.row = goFieldsOf(f.Fields(), ids, f.rowKey, g.rowTyp)
+
+		case NComputedField:
+			g.typ = map[string]string{
+				typeNumber:  "float64",
+				typeBoolean: "bool",
+				typeText:    "string",
+			}[valueType(f, ids, map[*Node]bool{})]
+
+		case NDropField, NChoiceField:
+			g.typ = typ + g.name
+
+			seen := map[string]bool{}
+			for i, o := range options(f) {
+				c := goConst{name: g.typ + goName(o), value: o}
+				if c.name == g.typ || seen[c.name] {
+					c.name = fmt.Sprintf("%sOption%d", g.typ, i+1)
+				}
+				seen[c.name] = true
+
+				g.enum = append(g.enum, c)
+			}
+		}
+
+		ret = append(ret, g)
+	}
+
+	return ret
+}
+
+// GoSource generates Go source for working with submissions to the
+// form: a struct with a typed field per form field (text, email
+// addresses and phone numbers are strings, numbers are ints, dates are
+// time.Times, uploads are *formaldehyd.Files, checkboxes, switches and
+// lone radio buttons are bools, computed fields are whatever they work
+// out to, repeats are slices of structs of their own, and the options
+// of a dropdown or choice are constants of their own string type), and
+// functions to fill one in from a submission, as JSON or as Answers.
+// Fields that weren't answered are left at their zero value.
+func (n *Node) GoSource(opts *GoOptions) ([]byte, error) {
+	typ := opts.Type
+	if typ == "" {
+		typ = goName(strings.TrimSuffix(opts.Source, path.Ext(opts.Source)))
+	}
+	if typ == "" {
+		typ = "Submission"
+	}
+
+	fields := goFields(n, typ)
+
+	b := &bytes.Buffer{}
+	p := func(format string, args ...interface{}) {
+		fmt.Fprintf(b, format, args...)
+	}
+
+	p("// Code generated by formaldehyd gen")
+	if opts.Source != "" {
+		p(" from %s", opts.Source)
+	}
+	p("; DO NOT EDIT.\n\n")
+
+	// the fields of every row, too
+	all := []*goField{}
+	for _, g := range fields {
+		all = append(all, g)
+		all = append(all, g.row...)
+	}
+
+	p("package %s\n\n", opts.Package)
+	p("import (\n\"encoding/json\"\n\"fmt\"\n")
+	for _, g := range all {
+		if g.typ == "time.Time" {
+			p("\"time\"\n")
+			break
+		}
+	}
+	p("\n\"github.com/latacora/formaldehyd\"\n)\n\n")
+
+	for _, g := range all {
+		if g.enum == nil {
+			continue
+		}
+
+		p("// %s is an option for %s.\n", g.typ, g.node.ID)
+		p("type %s string\n\n", g.typ)
+
+		p("const (\n")
+		for _, c := range g.enum {
+			p("%s %s = %q\n", c.name, g.typ, c.value)
+		}
+		p(")\n\n")
+
+		p("// Valid is whether v is one of the options.\n")
+		p("func (v %s) Valid() bool {\n", g.typ)
+		p("switch v {\ncase ")
+		for i, c := range g.enum {
+			if i > 0 {
+				p(", ")
+			}
+			p("%s", c.name)
+		}
+		p(":\nreturn true\n}\n\nreturn false\n}\n\n")
+	}
+
+	structOf := func(typ string, fields []*goField) {
+		p("type %s struct {\n", typ)
+		for _, g := range fields {
+			p("%s %s `json:%q`", g.name, g.typ, g.key)
+			if label := g.node.Attrs["label"]; label != "" {
+				p(" // %s", strings.Replace(label, "\n", " ", -1))
+			}
+			p("\n")
+		}
+		p("}\n\n")
+	}
+
+	fromAnswers := func(typ string, fields []*goField) {
+		p("func %sFromAnswers(answers formaldehyd.Answers) (ret *%s, err error) {\n", typ, typ)
+		p("ret = &%s{}\n\n", typ)
+
+		for _, g := range fields {
+			switch g.typ {
+			case "string":
+				p("if ret.%s, err = answers.String(%q); err != nil {\nreturn nil, err\n}\n\n", g.name, g.key)
+			case "int":
+				p("if ret.%s, err = answers.Int(%q); err != nil {\nreturn nil, err\n}\n\n", g.name, g.key)
+			case "float64":
+				p("if ret.%s, err = answers.Float(%q); err != nil {\nreturn nil, err\n}\n\n", g.name, g.key)
+			case "bool":
+				p("if ret.%s, err = answers.Bool(%q); err != nil {\nreturn nil, err\n}\n\n", g.name, g.key)
+			case "time.Time":
+				p("if ret.%s, err = answers.Date(%q); err != nil {\nreturn nil, err\n}\n\n", g.name, g.key)
+			case "*formaldehyd.File":
+				p("if ret.%s, err = answers.File(%q); err != nil {\nreturn nil, err\n}\n\n", g.name, g.key)
+			default:
+				v := "v" + g.name
+
+				if g.row != nil {
+					p("%s, err := answers.Rows(%q)\n", v, g.key)
+					p("if err != nil {\nreturn nil, err\n}\n\n")
+					p("for i, row := range %s {\n", v)
+					p("r, err := %sFromAnswers(row)\n", g.rowTyp)
+					p("if err != nil {\nreturn nil, fmt.Errorf(%q, i, err)\n}\n", g.key+"[%d].%s")
+					p("ret.%s = append(ret.%s, *r)\n}\n\n", g.name, g.name)
+					continue
+				}
+
+				p("%s, err := answers.String(%q)\n", v, g.key)
+				p("if err != nil {\nreturn nil, err\n}\n\n")
+				p("if ret.%s = %s(%s); %s != \"\" && !ret.%s.Valid() {\n", g.name, g.typ, v, v, g.name)
+				p("return nil, fmt.Errorf(\"%s: %%q isn't one of the options\", %s)\n}\n\n", g.key, v)
+			}
+		}
+
+		p("return ret, nil\n}\n")
+	}
+
+	for _, g := range fields {
+		if g.row == nil {
+			continue
+		}
+
+		p("// %s is a row of %s.\n", g.rowTyp, g.node.ID)
+		structOf(g.rowTyp, g.row)
+
+		p("// %sFromAnswers fills in a %s from the answers in a row.\n", g.rowTyp, g.rowTyp)
+		fromAnswers(g.rowTyp, g.row)
+		p("\n")
+	}
+
+	p("// %s is a submission to the form.\n", typ)
+	structOf(typ, fields)
+
+	p("// Decode%s fills in a %s from the JSON of a submission.\n", typ, typ)
+	p("func Decode%s(buf []byte) (*%s, error) {\n", typ, typ)
+	p("answers := formaldehyd.Answers{}\n")
+	p("if err := json.Unmarshal(buf, &answers); err != nil {\n")
+	p("return nil, fmt.Errorf(\"can't decode submission: %%s\", err)\n}\n\n")
+	p("return %sFromAnswers(answers)\n}\n\n", typ)
+
+	p("// %sFromAnswers fills in a %s from the answers to the form.\n", typ, typ)
+	fromAnswers(typ, fields)
+
+	return format.Source(b.Bytes())
+}
@@ -0,0 +1,84 @@
+package formaldehyd
+
+import (
+	goparser "go/parser"
+	"go/token"
+	"strings"
+	"testing"
+)
+
+func TestGoSource(t *testing.T) {
+	n, err := Parse([]byte(`
+Name    [          ]
+Age     [   +/-]
+Agree   [ ]
+
+Color *-------------
+      * red
+      * dark green
+      --------------
+
+Pets
+----
+
+Name    [          ]
+`))
+	ok(t, err)
+
+	src, err := n.GoSource(&GoOptions{Package: "forms", Source: "person.form"})
+	ok(t, err)
+
+	if _, err := goparser.ParseFile(token.NewFileSet(), "person.go", src, 0); err != nil {
+		t.Fatalf("generated source doesn't parse: %s\n%s", err, src)
+	}
+
+	for _, want := range []string{
+		"// Code generated by formaldehyd gen from person.form; DO NOT EDIT.",
+		"type PersonColor string",
+		`PersonColorDarkGreen PersonColor = "dark green"`,
+		"type Person struct {",
+		"Name     string      `json:\"name\"`      // Name",
+		"Age      int         `json:\"age\"`       // Age",
+		"Color    PersonColor `json:\"color\"`     // Color",
+		"PetsName string      `json:\"pets.name\"` // Name",
+		"func DecodePerson(buf []byte) (*Person, error) {",
+		`if ret.Age, err = answers.Int("age"); err != nil {`,
+		`if ret.Color = PersonColor(vColor); vColor != "" && !ret.Color.Valid() {`,
+	} {
+		if !strings.Contains(string(src), want) {
+			t.Errorf("expected %q in\n%s", want, src)
+		}
+	}
+}
+
+func TestTypedAnswers(t *testing.T) {
+	a := Answers{"name": "alice", "age": float64(30), "n": "12", "agree": true, "bad": 1.5}
+
+	if s, err := a.String("name"); err != nil || s != "alice" {
+		t.Fatalf("expected alice, got %q, %v", s, err)
+	}
+
+	if i, err := a.Int("age"); err != nil || i != 30 {
+		t.Fatalf("expected 30, got %d, %v", i, err)
+	}
+
+	if i, err := a.Int("n"); err != nil || i != 12 {
+		t.Fatalf("expected 12, got %d, %v", i, err)
+	}
+
+	if b, err := a.Bool("agree"); err != nil || !b {
+		t.Fatalf("expected true, got %v, %v", b, err)
+	}
+
+	if s, err := a.String("missing"); err != nil || s != "" {
+		t.Fatalf("expected nothing, got %q, %v", s, err)
+	}
+
+	if _, err := a.Int("bad"); err == nil {
+		t.Fatalf("expected an error for a fraction")
+	}
+
+	if _, err := a.Bool("name"); err == nil {
+		t.Fatalf("expected an error for text")
+	}
+}
@@ -0,0 +1,127 @@
+package formaldehyd
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+const helpForm = `
+Email [                    @] {required, placeholder "jane@example.com"}
+? Only for the __receipt__.
+
+Zip   [ 60601          ] {placeholder}
+`
+
+func TestHelp(t *testing.T) {
+	n, err := Parse([]byte(helpForm))
+	ok(t, err)
+
+	ids := n.index()
+	if h := ids["email"].Attrs["help"]; h != "Only for the __receipt__." {
+		t.Errorf("expected help on the email field, got %q", h)
+	}
+
+	if zip := ids["zip"]; zip.Attrs["placeholder"] != "60601" || zip.Attrs["default"] != "" {
+		t.Errorf("expected a bare placeholder to take over the default, got %v", zip.Attrs)
+	}
+
+	w := &bytes.Buffer{}
+	ok(t, n.HTML(w, &HTMLOptions{
+		Answers: Answers{},
+		Errors:  n.Validate(Answers{}),
+	}))
+
+	out := w.String()
+	for _, want := range []string{
+		`placeholder="jane@example.com"`,
+		`placeholder="60601"`,
+		`aria-invalid="true" aria-describedby="h-email e-email"`,
+		`<div class="help" id="h-email">` + "\n" + `<p>Only for the <strong>receipt</strong>.</p>`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("missing %s", want)
+		}
+	}
+
+	if t.Failed() {
+		t.Logf("%s", out)
+	}
+
+	if d := n.Schema().Properties["email"].Description; d != "Only for the receipt." {
+		t.Errorf("expected the help as the description, got %q", d)
+	}
+
+	// like a text's, help comes as written, as plain text and as HTML
+	js := n.JSON()
+	for _, want := range []string{
+		`"help": "Only for the receipt."`,
+		`"helpmarkdown": "Only for the __receipt__."`,
+		`"helphtml": "\u003cp\u003eOnly for the \u003cstrong\u003ereceipt`,
+	} {
+		if !strings.Contains(js, want) {
+			t.Errorf("missing %s in\n%s", want, js)
+		}
+	}
+
+	back, err := FromJSON([]byte(js))
+	ok(t, err)
+
+	if !Equivalent(n, back) {
+		t.Errorf("expected help and placeholders to survive JSON, got %v", back)
+	}
+
+	msgs := n.Messages()
+	if msgs["email:help"] != "Only for the __receipt__." || msgs["zip:placeholder"] != "60601" {
+		t.Errorf("expected help and placeholders in the catalog, got %v", msgs)
+	}
+
+	fr := n.Localize(Catalog{"email:help": "Seulement pour   le reçu.", "zip:placeholder": "75001"})
+	if ids := fr.index(); ids["email"].Attrs["help"] != "Seulement pour le reçu." || ids["zip"].Attrs["placeholder"] != "75001" {
+		t.Errorf("expected translated help and placeholder, got %v", fr)
+	}
+}
+
+func TestChoiceHelp(t *testing.T) {
+	n, err := Parse([]byte("Size S ( ) M ( ) L ( )\n? Pick one.\n"))
+	ok(t, err)
+
+	if c := n.Children[0]; c.Kind != NChoiceField || c.Attrs["help"] != "Pick one." {
+		t.Errorf("expected help on the choice, got %v", c)
+	}
+
+	// help under a radio in the middle isn't the choice's
+	n, err = Parse([]byte("Color\nRed ( )\n? Warm.\nBlue ( )\n? Cool.\n"))
+	ok(t, err)
+
+	for _, kid := range n.Children {
+		if kid.Kind == NChoiceField {
+			t.Errorf("expected the help to split the radios, got %v", kid)
+		}
+	}
+}
+
+func TestBadHelp(t *testing.T) {
+	for src, want := range map[string]string{
+		"? Nothing to help with":                    "on the line after a field",
+		"Name [          ]\n\n? Too far down":       "on the line after a field",
+		"[( Next )]\n? Buttons don't get help":      "on the line after a field",
+		"Name [          ] ? Same line":             "on the line after a field",
+		"Agree [ ] {placeholder \"yes\"}":           `"placeholder" doesn't apply to a checkfield`,
+		"Name [          ] {placeholder}":           "placeholder needs some text",
+		"Born [ yyyy-mm-dd ] {placeholder \"now\"}": `"placeholder" doesn't apply to a datefield`,
+	} {
+		_, err := Parse([]byte(src))
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%q: expected an error about %q, got %v", src, want, err)
+		}
+	}
+
+	// a question mark anywhere else is just a question mark
+	n, err := Parse([]byte("Is this right ? Or not\nName [          ]\n"))
+	ok(t, err)
+
+	if n.Children[0].Text != "Is this right ? Or not" {
+		t.Errorf("expected the ? in the text, got %v", n)
+	}
+}
@@ -0,0 +1,704 @@
+package formaldehyd
+
+import (
+	"encoding/base64"
+	"fmt"
+	"html/template"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// HTML renders a form as plain HTML that works without JavaScript: a
+// real <form> that posts back with ordinary form encoding (see
+// FormAnswers), or multipart if there's a file to upload (see
+// FormFiles), a <fieldset> per page, labels bound to their inputs,
+// and a radio group per choice. Conditional parts of the form are all
+// rendered, marked with a data-when attribute for scripts that want
+// to hide them; the server sorts out which answers count when the
+// form is submitted.
+//
+// The markup comes from templates. HTMLTemplates returns a copy of the
+// defaults; {{define}} any of them again to change them.
+
+// HTMLOptions are everything HTML needs besides the form itself.
+type HTMLOptions struct {
+	Title     string
+	Action    string
+	Templates *template.Template // nil for HTMLTemplates()
+	Template  string             // "document" if empty; "form" leaves out <html>
+	Lang      string             // the language the form is in; "en" if empty (see Localize)
+
+	// the ID of the one page to show, for a form filled out a page
+	// at a time; all of them if empty
+	Page string
+
+	// to re-render a submission that didn't validate
+	Answers Answers
+	Errors  []*FieldError
+
+	// to thank someone for a submission that did
+	Submission string
+}
+
+// HTMLForm is what the templates are executed with.
+type HTMLForm struct {
+	Title      string
+	Action     string
+	Errors     []*FieldError
+	Nodes      []*HTMLNode
+	Submission string
+	Multipart  bool
+	Lang       string
+}
+
+// An HTMLNode is one thing on the form, with its values worked out
+// for the templates. Kind picks the template it's rendered with.
+type HTMLNode struct {
+	Kind        string
+	ID          string
+	Label       string
+	Text        string
+	HTML        template.HTML // Text rendered; see Node.Markdown
+	Help        template.HTML // the field's help, rendered the same way
+	Placeholder string
+	Action      string
+	Value       string
+	Checked     bool
+	Required    bool
+	Size        int
+	Rows        int
+	MinLength   string
+	MaxLength   string
+	Pattern     string
+	Min         string
+	Max         string
+	Step        string
+	Accept      string
+	Expr        string
+	Options     []*HTMLOption
+	When        *Condition
+	Error       string
+	Children    []*HTMLNode
+	RepeatRows  [][]*HTMLNode // a repeat's fields, a row at a time
+}
+
+type HTMLOption struct {
+	Value    string
+	Label    string
+	Selected bool
+}
+
+const defaultHTML = `
+{{define "document"}}<!DOCTYPE html>
+<html lang="{{or .Lang "en"}}">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Title}}</title>
+</head>
+<body>
+<main>
+<h1>{{.Title}}</h1>
+{{if .Submission}}{{template "done" .}}{{else}}{{template "form" .}}{{end}}
+</main>
+</body>
+</html>
+{{end}}
+
+{{define "done"}}<p role="status">Thanks! Your response has been recorded.</p>{{end}}
+
+{{define "form"}}<form class="formaldehyd" method="post" action="{{.Action}}"{{if .Multipart}} enctype="multipart/form-data"{{end}} novalidate>
+{{template "errors" .}}
+{{range .Nodes}}{{template "node" .}}{{end}}
+</form>
+{{end}}
+
+{{define "errors"}}{{if .Errors}}<div class="errors" role="alert">
+<p>Some answers need another look:</p>
+<ul>
+{{range .Errors}}<li><a href="#f-{{.Field}}">{{or .Label .Field}}</a>: {{.Message}}</li>
+{{end}}</ul>
+</div>{{end}}{{end}}
+
+{{define "node"}}{{if eq .Kind "page"}}{{template "page" .}}
+{{else if eq .Kind "text"}}{{template "text" .}}
+{{else if eq .Kind "heading"}}{{template "heading" .}}
+{{else if eq .Kind "textfield"}}{{template "textfield" .}}
+{{else if eq .Kind "textarea"}}{{template "textarea" .}}
+{{else if eq .Kind "date"}}{{template "date" .}}
+{{else if eq .Kind "email"}}{{template "email" .}}
+{{else if eq .Kind "phone"}}{{template "phone" .}}
+{{else if eq .Kind "file"}}{{template "file" .}}
+{{else if eq .Kind "number"}}{{template "number" .}}
+{{else if eq .Kind "computed"}}{{template "computed" .}}
+{{else if eq .Kind "slider"}}{{template "slider" .}}
+{{else if eq .Kind "check"}}{{template "check" .}}
+{{else if eq .Kind "switch"}}{{template "switch" .}}
+{{else if eq .Kind "radio"}}{{template "radio" .}}
+{{else if eq .Kind "choice"}}{{template "choice" .}}
+{{else if eq .Kind "select"}}{{template "select" .}}
+{{else if eq .Kind "button"}}{{template "button" .}}
+{{else if eq .Kind "repeat"}}{{template "repeat" .}}
+{{end}}{{end}}
+
+{{define "page"}}<fieldset id="{{.ID}}"{{with .When}} data-when="{{.String}}"{{end}}>
+<legend>{{.Label}}</legend>
+{{range .Children}}{{template "node" .}}{{end}}</fieldset>{{end}}
+
+{{define "text"}}<div class="text"{{with .When}} data-when="{{.String}}"{{end}}>
+{{.HTML}}
+</div>{{end}}
+
+{{define "heading"}}<h2{{with .When}} data-when="{{.String}}"{{end}}>{{.HTML}}</h2>{{end}}
+
+{{define "textfield"}}<div class="field textfield"{{with .When}} data-when="{{.String}}"{{end}}>
+<label for="f-{{.ID}}">{{.Label}}</label>
+<input type="text" id="f-{{.ID}}" name="{{.ID}}" value="{{.Value}}" size="{{.Size}}"{{with .Placeholder}} placeholder="{{.}}"{{end}}{{if .Required}} required{{end}}{{with .MinLength}} minlength="{{.}}"{{end}}{{with .MaxLength}} maxlength="{{.}}"{{end}}{{with .Pattern}} pattern="{{.}}"{{end}}{{template "describedby" .}}>
+{{template "help" .}}{{template "error" .}}</div>{{end}}
+
+{{define "textarea"}}<div class="field textarea"{{with .When}} data-when="{{.String}}"{{end}}>
+<label for="f-{{.ID}}">{{.Label}}</label>
+<textarea id="f-{{.ID}}" name="{{.ID}}" rows="{{.Rows}}" cols="{{.Size}}"{{with .Placeholder}} placeholder="{{.}}"{{end}}{{if .Required}} required{{end}}{{with .MinLength}} minlength="{{.}}"{{end}}{{with .MaxLength}} maxlength="{{.}}"{{end}}{{template "describedby" .}}>{{.Value}}</textarea>
+{{template "help" .}}{{template "error" .}}</div>{{end}}
+
+{{define "date"}}<div class="field date"{{with .When}} data-when="{{.String}}"{{end}}>
+<label for="f-{{.ID}}">{{.Label}}</label>
+<input type="date" id="f-{{.ID}}" name="{{.ID}}" value="{{.Value}}"{{if .Required}} required{{end}}{{with .Min}} min="{{.}}"{{end}}{{with .Max}} max="{{.}}"{{end}}{{template "describedby" .}}>
+{{template "help" .}}{{template "error" .}}</div>{{end}}
+
+{{define "email"}}<div class="field email"{{with .When}} data-when="{{.String}}"{{end}}>
+<label for="f-{{.ID}}">{{.Label}}</label>
+<input type="email" id="f-{{.ID}}" name="{{.ID}}" value="{{.Value}}" size="{{.Size}}"{{with .Placeholder}} placeholder="{{.}}"{{end}} autocomplete="email"{{if .Required}} required{{end}}{{template "describedby" .}}>
+{{template "help" .}}{{template "error" .}}</div>{{end}}
+
+{{define "phone"}}<div class="field phone"{{with .When}} data-when="{{.String}}"{{end}}>
+<label for="f-{{.ID}}">{{.Label}}</label>
+<input type="tel" id="f-{{.ID}}" name="{{.ID}}" value="{{.Value}}" size="{{.Size}}"{{with .Placeholder}} placeholder="{{.}}"{{end}} autocomplete="tel"{{if .Required}} required{{end}}{{template "describedby" .}}>
+{{template "help" .}}{{template "error" .}}</div>{{end}}
+
+{{define "file"}}<div class="field file"{{with .When}} data-when="{{.String}}"{{end}}>
+<label for="f-{{.ID}}">{{.Label}}</label>
+<input type="file" id="f-{{.ID}}" name="{{.ID}}"{{with .Accept}} accept="{{.}}"{{end}}{{if .Required}} required{{end}}{{template "describedby" .}}>
+{{with .Value}}<span class="uploaded">{{.}}</span>
+{{end}}{{template "help" .}}{{template "error" .}}</div>{{end}}
+
+{{define "number"}}<div class="field number"{{with .When}} data-when="{{.String}}"{{end}}>
+<label for="f-{{.ID}}">{{.Label}}</label>
+<input type="number" id="f-{{.ID}}" name="{{.ID}}" value="{{.Value}}"{{with .Placeholder}} placeholder="{{.}}"{{end}}{{if .Required}} required{{end}}{{with .Min}} min="{{.}}"{{end}}{{with .Max}} max="{{.}}"{{end}}{{with .Step}} step="{{.}}"{{end}}{{template "describedby" .}}>
+{{template "help" .}}{{template "error" .}}</div>{{end}}
+
+{{define "computed"}}<div class="field computed"{{with .When}} data-when="{{.String}}"{{end}}>
+<label for="f-{{.ID}}">{{.Label}}</label>
+<output id="f-{{.ID}}" name="{{.ID}}" data-expr="{{.Expr}}"{{template "describedby" .}}>{{.Value}}</output>
+{{template "help" .}}{{template "error" .}}</div>{{end}}
+
+{{define "slider"}}<div class="field slider"{{with .When}} data-when="{{.String}}"{{end}}>
+<label for="f-{{.ID}}">{{.Label}}</label>
+<input type="range" id="f-{{.ID}}" name="{{.ID}}" value="{{.Value}}"{{if .Required}} required{{end}}{{with .Min}} min="{{.}}"{{end}}{{with .Max}} max="{{.}}"{{end}}{{with .Step}} step="{{.}}"{{end}}{{template "describedby" .}}>
+{{template "help" .}}{{template "error" .}}</div>{{end}}
+
+{{define "check"}}<div class="field check"{{with .When}} data-when="{{.String}}"{{end}}>
+<input type="checkbox" id="f-{{.ID}}" name="{{.ID}}" value="on"{{if .Checked}} checked{{end}}{{if .Required}} required{{end}}{{template "describedby" .}}>
+<label for="f-{{.ID}}">{{.Label}}</label>
+{{template "help" .}}{{template "error" .}}</div>{{end}}
+
+{{define "switch"}}<div class="field switch"{{with .When}} data-when="{{.String}}"{{end}}>
+<input type="checkbox" role="switch" id="f-{{.ID}}" name="{{.ID}}" value="on"{{if .Checked}} checked{{end}}{{if .Required}} required{{end}}{{template "describedby" .}}>
+<label for="f-{{.ID}}">{{.Label}}</label>
+{{template "help" .}}{{template "error" .}}</div>{{end}}
+
+{{define "radio"}}<div class="field radio"{{with .When}} data-when="{{.String}}"{{end}}>
+<input type="radio" id="f-{{.ID}}" name="{{.ID}}" value="on"{{if .Checked}} checked{{end}}{{if .Required}} required{{end}}{{template "describedby" .}}>
+<label for="f-{{.ID}}">{{.Label}}</label>
+{{template "help" .}}{{template "error" .}}</div>{{end}}
+
+{{define "choice"}}<fieldset class="field choice" id="f-{{.ID}}" role="radiogroup"{{with .When}} data-when="{{.String}}"{{end}}{{template "describedby" .}}>
+{{with .Label}}<legend>{{.}}</legend>
+{{end}}{{$field := .}}{{range $i, $o := .Options}}<div class="option">
+<input type="radio" id="f-{{$field.ID}}-{{$i}}" name="{{$field.ID}}" value="{{$o.Value}}"{{if $o.Selected}} checked{{end}}{{if $field.Required}} required{{end}}>
+<label for="f-{{$field.ID}}-{{$i}}">{{$o.Label}}</label>
+</div>
+{{end}}{{template "help" .}}{{template "error" .}}</fieldset>{{end}}
+
+{{define "select"}}<div class="field select"{{with .When}} data-when="{{.String}}"{{end}}>
+<label for="f-{{.ID}}">{{.Label}}</label>
+<select id="f-{{.ID}}" name="{{.ID}}"{{if .Required}} required{{end}}{{template "describedby" .}}>
+<option value="">Choose one</option>
+{{range .Options}}<option{{if ne .Label .Value}} value="{{.Value}}"{{end}}{{if .Selected}} selected{{end}}>{{.Label}}</option>
+{{end}}</select>
+{{template "help" .}}{{template "error" .}}</div>{{end}}
+
+{{define "repeat"}}<fieldset class="field repeat" id="f-{{.ID}}"{{with .Min}} data-min="{{.}}"{{end}}{{with .Max}} data-max="{{.}}"{{end}}{{with .When}} data-when="{{.String}}"{{end}}{{template "describedby" .}}>
+<legend>{{.Label}}</legend>
+{{template "help" .}}{{range $i, $row := .RepeatRows}}<div class="row" data-row="{{$i}}">
+{{range $row}}{{template "node" .}}{{end}}</div>
+{{end}}{{template "error" .}}</fieldset>{{end}}
+
+{{define "button"}}<button type="submit" name="_button" value="{{.ID}}" data-action="{{.Action}}"{{with .When}} data-when="{{.String}}"{{end}}>{{.Label}}</button>{{end}}
+
+{{define "help"}}{{with .Help}}<div class="help" id="h-{{$.ID}}">
+{{.}}
+</div>
+{{end}}{{end}}
+
+{{define "error"}}{{if .Error}}<span class="error" id="e-{{.ID}}">{{.Error}}</span>
+{{end}}{{end}}
+
+{{define "describedby"}}{{if .Error}} aria-invalid="true"{{end}}{{if or .Help .Error}} aria-describedby="{{if .Help}}h-{{.ID}}{{end}}{{if and .Help .Error}} {{end}}{{if .Error}}e-{{.ID}}{{end}}"{{end}}{{end}}
+`
+
+var defaultTemplates = HTMLTemplates()
+
+// HTMLTemplates returns a fresh copy of the default templates, ready
+// to have overrides parsed into it.
+func HTMLTemplates() *template.Template {
+	return template.Must(template.New("formaldehyd").Parse(defaultHTML))
+}
+
+// HTML writes the form out as HTML. It's an error to ask for a page
+// the form doesn't have.
+func (n *Node) HTML(w io.Writer, opts *HTMLOptions) error {
+	t := opts.Templates
+	if t == nil {
+		t = defaultTemplates
+	}
+
+	name := opts.Template
+	if name == "" {
+		name = "document"
+	}
+
+	errs := map[string]string{}
+	for _, e := range opts.Errors {
+		if _, ok := errs[e.Field]; !ok {
+			errs[e.Field] = e.Message
+		}
+	}
+
+	nodes := n.Children
+	if opts.Page != "" {
+		nodes = nil
+		for _, pg := range n.Pages() {
+			if pg.ID == opts.Page {
+				nodes = []*Node{pg}
+			}
+		}
+
+		if nodes == nil {
+			return fmt.Errorf("no page \"%s\" in the form", opts.Page)
+		}
+	}
+
+	form := &HTMLForm{
+		Title:      opts.Title,
+		Action:     opts.Action,
+		Errors:     labelErrors(n, opts.Errors),
+		Nodes:      htmlNodes(nodes, opts.Answers, errs),
+		Submission: opts.Submission,
+		Lang:       opts.Lang,
+	}
+
+	// uploads only come through multipart
+	for _, k := range nodes {
+		for _, f := range append([]*Node{k}, k.Fields()...) {
+			form.Multipart = form.Multipart || f.Kind == NFileField
+		}
+	}
+
+	return t.ExecuteTemplate(w, name, form)
+}
+
+// labelErrors labels errors with the fields they're about as they
+// appear on the form being rendered, which might not be the form they
+// were found with (see Localize).
+func labelErrors(n *Node, errs []*FieldError) (ret []*FieldError) {
+	ids := n.index()
+
+	for _, e := range errs {
+		if f := ids[e.Field]; f != nil && f.Attrs["label"] != "" {
+			c := *e
+			c.Label = f.Attrs["label"]
+			e = &c
+		}
+
+		ret = append(ret, e)
+	}
+
+	return ret
+}
+
+func htmlNodes(ns []*Node, answers Answers, errs map[string]string) (ret []*HTMLNode) {
+	for _, n := range ns {
+		if h := htmlNode(n, answers, errs); h != nil {
+			ret = append(ret, h)
+		}
+	}
+
+	return ret
+}
+
+func htmlNode(n *Node, answers Answers, errs map[string]string) *HTMLNode {
+	h := &HTMLNode{
+		ID:       n.ID,
+		Label:    n.label(),
+		Text:     n.Text,
+		Required: n.Attrs["required"] == "t",
+		When:     n.Cond,
+		Error:    errs[n.ID],
+	}
+
+	if n.IsField() {
+		h.Help = template.HTML(markdown(n.Attrs["help"]))
+		h.Placeholder = n.Attrs["placeholder"]
+	}
+
+	answer, answered := answers[n.ID]
+
+	value := func() {
+		h.Value = n.Attrs["default"]
+		if answered && answer != nil {
+			h.Value = fmt.Sprint(answer)
+		}
+	}
+
+	checked := func(attr string) {
+		h.Checked = n.Attrs[attr] == "t"
+		if b, ok := answer.(bool); answered && ok {
+			h.Checked = b
+		}
+	}
+
+	switch n.Kind {
+	case NPage:
+		h.Kind = "page"
+		h.Children = htmlNodes(n.Children, answers, errs)
+
+	case NText:
+		h.Kind = "text"
+		h.Text = n.Plain()
+		h.HTML = template.HTML(n.Markdown())
+
+	case NHeading:
+		h.Kind = "heading"
+		h.Text = n.Plain()
+		h.HTML = template.HTML(n.Markdown())
+
+	case NButton:
+		h.Kind = "button"
+		h.Action = n.Attrs["action"]
+
+	case NTextField:
+		h.Kind = "textfield"
+		h.Size = atoi(n.Attrs["width"])
+		h.Rows = n.rows()
+		h.MinLength = n.Attrs["minlen"]
+		h.MaxLength = n.Attrs["maxlen"]
+		h.Pattern = n.Attrs["pattern"]
+		value()
+
+		if h.Rows > 1 {
+			h.Kind = "textarea"
+		}
+
+		if h.MaxLength == "" && h.Size*h.Rows > 0 {
+			h.MaxLength = strconv.Itoa(h.Size * h.Rows)
+		}
+
+	case NDateField:
+		h.Kind = "date"
+		h.Min = n.Attrs["min"]
+		h.Max = n.Attrs["max"]
+		value()
+
+	case NEmailField, NPhoneField:
+		h.Kind = "email"
+		if n.Kind == NPhoneField {
+			h.Kind = "phone"
+		}
+
+		h.Size = atoi(n.Attrs["width"])
+		value()
+
+	case NFileField:
+		h.Kind = "file"
+		h.Accept = strings.Join(strings.Fields(n.Attrs["accept"]), ",")
+
+		// there's no putting a file back in the box, but it can say
+		// what's already been sent
+		if f, ok := file(answer); answered && ok {
+			h.Value = f.Name
+		}
+
+	case NNumberField:
+		h.Kind = "number"
+		if n.Attrs["slider"] == "t" {
+			h.Kind = "slider"
+		}
+
+		h.Min = n.Attrs["min"]
+		h.Max = n.Attrs["max"]
+		h.Step = n.Attrs["step"]
+		value()
+
+	case NComputedField:
+		h.Kind = "computed"
+		h.Expr = n.Attrs["expr"]
+
+		// only there once the answers have been through Compute
+		if f, ok := answer.(float64); ok {
+			h.Value = strconv.FormatFloat(f, 'f', -1, 64)
+		} else if answered && answer != nil {
+			h.Value = fmt.Sprint(answer)
+		}
+
+	case NRepeat:
+		h.Kind = "repeat"
+		h.Min = n.Attrs["min"]
+		h.Max = n.Attrs["max"]
+		h.RepeatRows = htmlRows(n, answer, errs)
+
+	case NCheckField:
+		h.Kind = "check"
+		checked("checked")
+
+	case NSwitchField:
+		h.Kind = "switch"
+		checked("on")
+
+	case NRadioField:
+		h.Kind = "radio"
+		checked("selected")
+
+	case NDropField:
+		h.Kind = "select"
+		value()
+
+		for _, kid := range n.Children {
+			h.Options = append(h.Options, &HTMLOption{
+				Value:    kid.Text,
+				Label:    optionLabel(kid),
+				Selected: kid.Text == h.Value,
+			})
+		}
+
+	case NChoiceField:
+		h.Kind = "choice"
+
+		for _, kid := range n.Children {
+			if kid.Attrs["selected"] == "t" {
+				h.Value = kid.Text
+			}
+		}
+
+		if answered && answer != nil {
+			h.Value = fmt.Sprint(answer)
+		}
+
+		for _, kid := range n.Children {
+			h.Options = append(h.Options, &HTMLOption{
+				Value:    kid.Text,
+				Label:    optionLabel(kid),
+				Selected: kid.Text == h.Value,
+			})
+		}
+
+	default:
+		return nil
+	}
+
+	if h.Label == "" {
+		h.Label = h.ID
+	}
+
+	return h
+}
+
+// htmlRows renders the rows of a repeat: the ones already answered,
+// and a blank one to add another with, if there's room, or as many
+// blank ones as it needs at least. The fields in a row are named like
+// the errors about them, "dependants[0].name"; see FormAnswers.
+func htmlRows(r *Node, answer interface{}, errs map[string]string) (ret [][]*HTMLNode) {
+	rs, _ := rows(answer)
+
+	count := len(rs)
+	if max := r.Attrs["max"]; max == "" || count < atoi(max) {
+		count++
+	}
+	if min := atoi(r.Attrs["min"]); count < min {
+		count = min
+	}
+
+	for i := 0; i < count; i++ {
+		answers, rowErrs, names := Answers{}, map[string]string{}, map[string]string{}
+
+		for _, f := range r.Fields() {
+			key := r.rowKey(f)
+			names[f.ID] = rowName(r, i, key)
+			rowErrs[f.ID] = errs[names[f.ID]]
+
+			if i < len(rs) {
+				if v, ok := rs[i][key]; ok {
+					answers[f.ID] = v
+				}
+			}
+		}
+
+		var rename func(hs []*HTMLNode)
+		rename = func(hs []*HTMLNode) {
+			for _, h := range hs {
+				if name, ok := names[h.ID]; ok {
+					h.ID = name
+				}
+				rename(h.Children)
+			}
+		}
+
+		row := htmlNodes(r.Children, answers, rowErrs)
+		rename(row)
+
+		ret = append(ret, row)
+	}
+
+	return ret
+}
+
+// FormAnswers turns an HTML form submission into Answers, the way a
+// JSON submission would have decoded. A checkbox, switch or radio
+// button that isn't in the submission is false, since browsers leave
+// those out; anything else that's missing or empty is unanswered.
+// The rows of a repeat come in as fields named "dependants[0].name"
+// and so on; rows left entirely blank are dropped.
+func (n *Node) FormAnswers(values url.Values) Answers {
+	answers := Answers{}
+
+	for _, f := range n.Fields() {
+		if f.Kind == NRepeat {
+			if rs := formRows(f, values); len(rs) > 0 {
+				answers[f.ID] = rs
+			}
+			continue
+		}
+
+		if v, ok := formValue(f, values.Get(f.ID)); ok {
+			answers[f.ID] = v
+		}
+	}
+
+	return answers
+}
+
+// formValue is the answer to f in an HTML form submission, where it
+// came in as v, if it was answered.
+func formValue(f *Node, v string) (interface{}, bool) {
+	switch f.Kind {
+	case NCheckField, NSwitchField, NRadioField:
+		return v != "", true
+
+	case NNumberField:
+		if v == "" {
+			return nil, false
+		}
+
+		// leave it as a string if it isn't a number, so that
+		// Validate can say so
+		if num, err := strconv.ParseFloat(v, 64); err == nil {
+			return num, true
+		}
+		return v, true
+
+	case NFileField:
+		// see FormFiles
+
+	case NComputedField:
+		// see Compute
+
+	default:
+		if v != "" {
+			return v, true
+		}
+	}
+
+	return nil, false
+}
+
+// formRows collects the rows of repeat r from an HTML form submission.
+func formRows(r *Node, values url.Values) (ret []Answers) {
+	// only the rows that were sent, in order; a form can't make us
+	// count up to a row numbered in the billions
+	seen := map[int]bool{}
+	indexes := []int{}
+	for name := range values {
+		var i int
+		if !strings.HasPrefix(name, r.ID+"[") {
+			continue
+		}
+
+		if _, err := fmt.Sscanf(name[len(r.ID):], "[%d].", &i); err == nil && !seen[i] {
+			seen[i] = true
+			indexes = append(indexes, i)
+		}
+	}
+
+	sort.Ints(indexes)
+
+	for _, i := range indexes {
+		row, blank := Answers{}, true
+
+		for _, f := range r.Fields() {
+			key := r.rowKey(f)
+			v := values.Get(rowName(r, i, key))
+			blank = blank && v == ""
+
+			if a, ok := formValue(f, v); ok {
+				row[key] = a
+			}
+		}
+
+		if !blank {
+			ret = append(ret, row)
+		}
+	}
+
+	return ret
+}
+
+// FormFiles adds the files uploaded with a multipart HTML form
+// submission to its answers, as the objects a JSON submission would
+// have had (see File). File fields nothing was uploaded to are left
+// unanswered.
+func (n *Node) FormFiles(answers Answers, files map[string][]*multipart.FileHeader) error {
+	for _, f := range n.Fields() {
+		if f.Kind != NFileField || len(files[f.ID]) == 0 {
+			continue
+		}
+
+		fh := files[f.ID][0]
+		if fh.Filename == "" {
+			continue
+		}
+
+		r, err := fh.Open()
+		if err != nil {
+			return fmt.Errorf("can't read upload to %s: %s", f.ID, err)
+		}
+
+		data, err := ioutil.ReadAll(r)
+		r.Close()
+		if err != nil {
+			return fmt.Errorf("can't read upload to %s: %s", f.ID, err)
+		}
+
+		answers[f.ID] = map[string]interface{}{
+			"name": fh.Filename,
+			"type": fh.Header.Get("Content-Type"),
+			"data": base64.StdEncoding.EncodeToString(data),
+		}
+	}
+
+	return nil
+}
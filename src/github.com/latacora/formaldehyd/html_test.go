@@ -0,0 +1,114 @@
+package formaldehyd
+
+import (
+	"bytes"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestHTML(t *testing.T) {
+	n, err := Parse(fixture("pages.form"))
+	ok(t, err)
+
+	w := &bytes.Buffer{}
+	ok(t, n.HTML(w, &HTMLOptions{Title: "Test", Action: "/form/test/html"}))
+
+	out := w.String()
+	for _, want := range []string{
+		`<form class="formaldehyd" method="post" action="/form/test/html"`,
+		`<fieldset id="page-1">`,
+		`<legend>Page 1</legend>`,
+		`<label for="f-page-1.test">Test</label>`,
+		`<input type="text" id="f-page-1.test" name="page-1.test" value="test" size="9"`,
+		`<textarea id="f-page-1.test3" name="page-1.test3" rows="3"`,
+		`<fieldset class="field choice" id="f-page-2.test" role="radiogroup">`,
+		`<input type="radio" id="f-page-2.test-1" name="page-2.test" value="7">`,
+		`<input type="radio" id="f-page-2.test-9" name="page-2.test-9" value="on" checked>`,
+		`<input type="checkbox" id="f-zk"`,
+		`data-when="zk"`,
+		`<option>three and four</option>`,
+		`<input type="number" id="f-count"`,
+		`<input type="range" id="f-level"`,
+		`<button type="submit" name="_button" value="page-4.submit" data-action="submit">Submit</button>`,
+		`<h2>This is a test of the emergency broadcast system</h2>`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("missing %s", want)
+		}
+	}
+
+	if t.Failed() {
+		t.Logf("%s", out)
+	}
+}
+
+func TestHTMLSubmission(t *testing.T) {
+	n, err := Parse([]byte(`
+About you
+---------
+
+Name [          ] {required}
+Age  [  +/-] {min 1}
+Agree [ ] {required}
+`))
+	ok(t, err)
+
+	answers := n.FormAnswers(url.Values{
+		"about-you.name": {"<bob>"},
+		"about-you.age":  {"0"},
+	})
+
+	errs := n.Validate(answers)
+	if len(errs) != 2 {
+		t.Fatalf("expected two errors, got %v", errs)
+	}
+
+	w := &bytes.Buffer{}
+	ok(t, n.HTML(w, &HTMLOptions{Template: "form", Answers: answers, Errors: errs}))
+
+	out := w.String()
+	for _, want := range []string{
+		`value="&lt;bob&gt;"`,
+		`value="0"`,
+		`aria-invalid="true" aria-describedby="e-about-you.age"`,
+		`<span class="error" id="e-about-you.agree">required</span>`,
+		`<a href="#f-about-you.age">Age</a>: less than 1`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("missing %s", want)
+		}
+	}
+
+	if strings.Contains(out, "<html") {
+		t.Errorf("expected just the form")
+	}
+
+	if t.Failed() {
+		t.Logf("%s", out)
+	}
+}
+
+func TestHTMLOverride(t *testing.T) {
+	n, err := Parse([]byte("Name [          ]\n"))
+	ok(t, err)
+
+	tmpl := HTMLTemplates()
+	_, err = tmpl.Parse(`{{define "textfield"}}<x-text name="{{.ID}}"></x-text>{{end}}`)
+	ok(t, err)
+
+	w := &bytes.Buffer{}
+	ok(t, n.HTML(w, &HTMLOptions{Templates: tmpl}))
+
+	if !strings.Contains(w.String(), `<x-text name="name"></x-text>`) {
+		t.Errorf("override didn't take:\n%s", w)
+	}
+
+	// and the defaults are untouched
+	w.Reset()
+	ok(t, n.HTML(w, &HTMLOptions{}))
+
+	if strings.Contains(w.String(), "x-text") {
+		t.Errorf("override leaked into the defaults")
+	}
+}
@@ -0,0 +1,216 @@
+package formaldehyd
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Forms are written in one language. Everything a person filling one
+// out reads (labels, headings, text, options and buttons) can be
+// translated with a Catalog: a message per string, keyed by what the
+// string belongs to rather than by the string itself, so that fixing
+// a typo in one label doesn't lose the translations of every other.
+//
+// Fields, pages and buttons are keyed by ID:
+//
+//	about-you.name:label
+//	about-you.name:help
+//	about-you.name:placeholder
+//	about-you.color:option:red
+//	about-you.next:label
+//
+// Text and headings don't have IDs unless they're #tagged, so they're
+// keyed by a hash of what they say instead:
+//
+//	text:3f9a2c1b7e04
+//
+// Messages extracts a catalog with the form's own strings in it, for
+// translators to start from; Localize applies one.
+
+// A Catalog maps message keys to translations. On disk it's a JSON
+// object.
+type Catalog map[string]string
+
+// messageKey is the key for one of the strings on n: "label", "text",
+// or an option.
+func messageKey(n *Node, what string) string {
+	if n.ID != "" {
+		return n.ID + ":" + what
+	}
+
+	sum := sha256.Sum256([]byte(n.Text))
+	return what + ":" + hex.EncodeToString(sum[:6])
+}
+
+// messages calls f with what each translatable string under n is
+// ("label", "text", "help", "placeholder" or "option"), its key and
+// its source text.
+func (n *Node) messages(f func(k *Node, what, key, source string)) {
+	for _, k := range n.Children {
+		switch {
+		case k.Kind == NText || k.Kind == NHeading:
+			f(k, "text", messageKey(k, "text"), k.Text)
+
+		case k.Kind == NButton:
+			f(k, "label", messageKey(k, "label"), k.Text)
+
+		case k.Kind == NPage || k.IsField():
+			for _, what := range []string{"label", "help", "placeholder"} {
+				if s := k.Attrs[what]; s != "" {
+					f(k, what, messageKey(k, what), s)
+				}
+			}
+		}
+
+		if k.Kind == NDropField || k.Kind == NChoiceField {
+			for _, opt := range k.Children {
+				f(opt, "option", k.ID+":option:"+opt.Text, opt.Text)
+			}
+			continue
+		}
+
+		k.messages(f)
+	}
+}
+
+// Messages extracts every translatable string in the form into a
+// catalog, each mapped to itself.
+func (n *Node) Messages() Catalog {
+	ret := Catalog{}
+	n.messages(func(_ *Node, _, key, source string) {
+		ret[key] = source
+	})
+
+	return ret
+}
+
+// Merge brings a translated catalog up to date with the form, for
+// when the form has changed since it was translated: translations
+// of strings still in the form are kept, new strings are added
+// untranslated, and the keys of the ones that went away are returned.
+func (c Catalog) Merge(source Catalog) (Catalog, []string) {
+	ret := Catalog{}
+	for key, text := range source {
+		ret[key] = text
+		if t, ok := c[key]; ok && t != "" {
+			ret[key] = t
+		}
+	}
+
+	stale := []string{}
+	for key := range c {
+		if _, ok := source[key]; !ok {
+			stale = append(stale, key)
+		}
+	}
+
+	sort.Strings(stale)
+	return ret, stale
+}
+
+// Localize returns a copy of the form with its strings translated.
+// Anything the catalog doesn't have (or has as "") stays as it was.
+// IDs, conditions and attributes are untouched, so answers to the
+// copy are answers to the original; in particular an option's Text
+// is still what's submitted when it's picked, and its translation
+// goes in its "label" attribute instead.
+func (n *Node) Localize(c Catalog) *Node {
+	ret := n.clone(nil)
+
+	ret.messages(func(k *Node, what, key, _ string) {
+		t := c[key]
+		if t == "" {
+			return
+		}
+
+		// what's translated is written like the source, so text is
+		// cleaned up the way the parser would have
+		switch {
+		case what == "help":
+			k.Attrs[what] = richText(t)
+		case what == "placeholder":
+			k.Attrs[what] = t
+		case k.Kind == NText || k.Kind == NHeading:
+			k.Text = richText(t)
+		case k.Kind == NButton:
+			k.Text = t
+		default:
+			k.Attrs["label"] = t
+		}
+	})
+
+	return ret
+}
+
+// clone makes a deep copy of n, under parent.
+func (n *Node) clone(parent *Node) *Node {
+	ret := *n
+	ret.Parent = parent
+	ret.Attrs = map[string]string{}
+	for k, v := range n.Attrs {
+		ret.Attrs[k] = v
+	}
+
+	ret.Children = nil
+	for _, kid := range n.Children {
+		ret.Children = append(ret.Children, kid.clone(&ret))
+	}
+
+	return &ret
+}
+
+// MatchLocale picks the locale to show a form in, given the value of an
+// Accept-Language header and the locales there are catalogs for. An
+// exact match wins, then one in the same language ("fr-CA" for "fr",
+// or the other way around); "" means none of them will do and the form
+// should be shown as written.
+func MatchLocale(accept string, available []string) string {
+	type pref struct {
+		tag string
+		q   float64
+	}
+
+	prefs := []pref{}
+	for _, part := range strings.Split(accept, ",") {
+		fields := strings.Split(part, ";")
+
+		p := pref{tag: strings.ToLower(strings.TrimSpace(fields[0])), q: 1}
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if q, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					p.q = q
+				}
+			}
+		}
+
+		if p.tag != "" && p.tag != "*" && p.q > 0 {
+			prefs = append(prefs, p)
+		}
+	}
+
+	sort.SliceStable(prefs, func(i, j int) bool { return prefs[i].q > prefs[j].q })
+
+	lang := func(tag string) string {
+		return strings.SplitN(strings.ToLower(tag), "-", 2)[0]
+	}
+
+	for _, p := range prefs {
+		for _, a := range available {
+			if strings.ToLower(a) == p.tag {
+				return a
+			}
+		}
+
+		for _, a := range available {
+			if lang(a) == lang(p.tag) {
+				return a
+			}
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,164 @@
+package formaldehyd
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const i18nForm = `
+About you
+---------
+
+Please tell us a little about yourself.
+
+Name [                    ] {required}
+
+Color *---------
+      * red
+      * green
+      ---------
+
+[( Next )]
+`
+
+func TestMessages(t *testing.T) {
+	n, err := Parse([]byte(i18nForm))
+	ok(t, err)
+
+	got := n.Messages()
+	text := messageKey(&Node{Text: "Please tell us a little about yourself."}, "text")
+
+	want := Catalog{
+		"about-you:label":              "About you",
+		text:                           "Please tell us a little about yourself.",
+		"about-you.name:label":         "Name",
+		"about-you.color:label":        "Color",
+		"about-you.color:option:red":   "red",
+		"about-you.color:option:green": "green",
+		"about-you.next:label":         "Next",
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+
+	merged, stale := Catalog{
+		"about-you.name:label":  "Nom",
+		"about-you.color:label": "",
+		"about-you.age:label":   "Âge",
+	}.Merge(got)
+
+	if merged["about-you.name:label"] != "Nom" || merged["about-you.color:label"] != "Color" {
+		t.Errorf("expected translations kept and gaps filled from the form, got %v", merged)
+	}
+
+	if !reflect.DeepEqual(stale, []string{"about-you.age:label"}) {
+		t.Errorf("expected about-you.age to be stale, got %v", stale)
+	}
+}
+
+func TestLocalize(t *testing.T) {
+	n, err := Parse([]byte(i18nForm))
+	ok(t, err)
+
+	fr := n.Localize(Catalog{
+		"about-you:label":            "À propos de vous",
+		"about-you.name:label":       "Nom",
+		"about-you.color:label":      "Couleur",
+		"about-you.color:option:red": "rouge",
+		"about-you.next:label":       "Suivant",
+	})
+
+	ids := fr.index()
+	if ids["about-you.name"].Attrs["label"] != "Nom" || ids["about-you.next"].Text != "Suivant" {
+		t.Errorf("expected translated labels, got %v", fr)
+	}
+
+	if n.index()["about-you.name"].Attrs["label"] != "Name" {
+		t.Errorf("localizing shouldn't touch the original")
+	}
+
+	// translated text is tidied up like the source's
+	intro := n.Children[0].Children[0]
+	spaced := n.Localize(Catalog{messageKey(intro, "text"): "  Parlez-nous   un peu\n de vous.  "})
+	if got := spaced.Children[0].Children[0].Text; got != "Parlez-nous un peu de vous." {
+		t.Errorf("expected the translated text normalized, got %q", got)
+	}
+
+	// answers are still in the source language
+	if errs := fr.Validate(Answers{"about-you.name": "Marie", "about-you.color": "red"}); len(errs) != 0 {
+		t.Errorf("unexpected validation errors: %v", errs)
+	}
+
+	var doc struct {
+		Children []struct {
+			Children []struct {
+				Options []string `json:"options"`
+				Labels  []string `json:"labels"`
+			} `json:"children"`
+		} `json:"children"`
+	}
+
+	ok(t, json.Unmarshal([]byte(fr.JSON()), &doc))
+
+	color := doc.Children[0].Children[2]
+	if !reflect.DeepEqual(color.Options, []string{"red", "green"}) || !reflect.DeepEqual(color.Labels, []string{"rouge", "green"}) {
+		t.Errorf("expected options in English and labels in French, got %+v", color)
+	}
+
+	back, err := FromJSON([]byte(fr.JSON()))
+	ok(t, err)
+
+	opts := back.Children[0].Children[2].Children
+	if opts[0].Text != "red" || opts[0].Attrs["label"] != "rouge" || opts[1].Attrs["label"] != "" {
+		t.Errorf("expected option labels to survive JSON, got %v", back)
+	}
+
+	w := &bytes.Buffer{}
+	ok(t, fr.HTML(w, &HTMLOptions{
+		Lang:    "fr",
+		Answers: Answers{},
+		Errors:  fr.Validate(Answers{}),
+	}))
+
+	out := w.String()
+	for _, want := range []string{
+		`<html lang="fr">`,
+		`<legend>À propos de vous</legend>`,
+		`<option value="red">rouge</option>`,
+		`<option>green</option>`,
+		`<a href="#f-about-you.name">Nom</a>: required`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("missing %s", want)
+		}
+	}
+
+	if t.Failed() {
+		t.Logf("%s", out)
+	}
+}
+
+func TestMatchLocale(t *testing.T) {
+	available := []string{"de", "fr-CA", "pt-BR"}
+
+	for accept, want := range map[string]string{
+		"":                         "",
+		"de":                       "de",
+		"DE-at":                    "de",
+		"fr":                       "fr-CA",
+		"en-US,en;q=0.9":           "",
+		"en;q=0.9,pt-BR":           "pt-BR",
+		"de;q=0.5,fr-CA;q=0.8":     "fr-CA",
+		"fr-CA;q=0, de":            "de",
+		"*":                        "",
+		"es, pt-PT;q=0.7, de;q=.3": "pt-BR",
+	} {
+		if got := MatchLocale(accept, available); got != want {
+			t.Errorf("%q: expected %q, got %q", accept, want, got)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package formaldehyd
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// slug squashes a label down to something usable as (part of) an ID:
+// lowercase letters and digits, with runs of anything else turned
+// into a single dash.
+func slug(label string) string {
+	var b strings.Builder
+
+	dash := false
+	for _, r := range strings.ToLower(label) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			if dash && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			b.WriteRune(r)
+			dash = false
+		} else {
+			dash = true
+		}
+	}
+
+	return b.String()
+}
+
+// identifiable nodes get an ID; text and headings only get one if
+// they've been tagged.
+func identifiable(n *Node) bool {
+	return n.IsField() || n.Kind == NPage || n.Kind == NButton
+}
+
+func (n *Node) label() string {
+	if n.Kind == NButton {
+		return n.Text
+	}
+
+	return n.Attrs["label"]
+}
+
+// assignIDs gives every page, field and button in the tree a stable
+// ID. A #tag always wins; otherwise the ID is derived from the page
+// label and the field label, so that it survives edits that move the
+// field around. Two nodes with the same ID are an error, since
+// answers are stored under it.
+func assignIDs(root *Node) (errs ParseErrors) {
+	seen := map[string]*Node{}
+
+	claim := func(n *Node) {
+		if n.ID == "" {
+			return
+		}
+
+		if prev, ok := seen[n.ID]; ok {
+			errs = append(errs, nodeError(n, `duplicate id "%s" (already used at line %d); `+
+				`give one of them a #tag to tell them apart`, n.ID, prev.Line))
+			return
+		}
+
+		seen[n.ID] = n
+	}
+
+	// A node with nothing to slug is named after the nearest labelled
+	// node before it in its scope, and numbered among the unlabelled
+	// nodes of its kind since then, so that inserting a field only
+	// renumbers its unlabelled neighbours.
+	var walk func(scope *Node, prefix string)
+	walk = func(scope *Node, prefix string) {
+		anchor := ""
+		counts := map[int]int{}
+
+		for _, n := range scope.Children {
+			switch {
+			case n.Hash != "":
+				n.ID = n.Hash
+
+			case identifiable(n):
+				s := slug(n.label())
+				if s != "" {
+					anchor = s
+					counts = map[int]int{}
+				} else {
+					counts[n.Kind]++
+					s = fmt.Sprintf("%s-%d", strings.ToLower(nodeNames[n.Kind]), counts[n.Kind])
+					if anchor != "" {
+						s = anchor + "-" + s
+					}
+				}
+
+				n.ID = prefix + s
+			}
+
+			claim(n)
+
+			if n.Kind == NPage || n.Kind == NRepeat {
+				walk(n, n.ID+".")
+			}
+		}
+	}
+
+	walk(root, "")
+	return errs
+}
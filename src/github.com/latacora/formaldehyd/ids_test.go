@@ -0,0 +1,77 @@
+package formaldehyd
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestIDs(t *testing.T) {
+	n, err := Parse(fixture("pages.form"))
+	ok(t, err)
+
+	ids := map[string]string{}
+	for _, f := range n.Fields() {
+		ids[f.ID] = f.Attrs["label"]
+	}
+
+	for id, label := range map[string]string{
+		"page-1.test2":  "Test2",
+		"page-2.test-5": "Test 5",
+		"zk":            "Test",
+		"details":       "Test",
+		"page-4.test":   "test",
+	} {
+		if ids[id] != label {
+			t.Errorf("expected %s to be the id of \"%s\", got \"%s\"", id, label, ids[id])
+		}
+	}
+}
+
+func TestDuplicateIDs(t *testing.T) {
+	_, err := Parse([]byte(`
+Name [          ]
+
+Name [          ]
+`))
+	if err == nil || !strings.Contains(err.Error(), "duplicate id") {
+		t.Fatalf("expected a duplicate id error, got %v", err)
+	}
+
+	_, err = Parse([]byte(`
+Name [          ]
+
+#other
+Name [          ]
+`))
+	ok(t, err)
+
+	// pages.form untagged collides four times, and all four are reported
+	_, err = Parse(fixture("bad-ids.form"))
+	if err == nil || strings.Count(err.Error(), "duplicate id") != 4 {
+		t.Fatalf("expected four duplicate ids, got %v", err)
+	}
+}
+
+func TestIDsUnlabelled(t *testing.T) {
+	ids := func(src string) map[string]bool {
+		n, err := Parse([]byte(src))
+		ok(t, err)
+
+		ret := map[string]bool{}
+		for id := range n.index() {
+			ret[id] = true
+		}
+		return ret
+	}
+
+	before := ids("Name [          ]\n[( -> )]\n\nAge [  +/-]\n[( -> )]\n")
+	after := ids("Pet [          ]\n[( -> )]\n\nName [          ]\n[( -> )]\n\nAge [  +/-]\n[( -> )]\n")
+
+	// a button with nothing to name it by is named after the field
+	// before it, so adding a field doesn't renumber the rest
+	for _, id := range []string{"name-button-1", "age-button-1"} {
+		if !before[id] || !after[id] {
+			t.Errorf("expected %s before and after adding a field, got %v and %v", id, before, after)
+		}
+	}
+}
@@ -0,0 +1,170 @@
+package formaldehyd
+
+import (
+	"bytes"
+	"mime/multipart"
+	"strings"
+	"testing"
+)
+
+const kindsForm = `
+Born   [ yyyy-mm-dd ] {required, min 1900-01-01, max 2010-12-31}
+Email  [                    @] {required}
+Phone  [                #]
+Resume [                ^] {accept ".pdf image/*", maxsize 10}
+Bio    [                              ] {rows 4}
+`
+
+func TestKinds(t *testing.T) {
+	n, err := Parse([]byte(kindsForm))
+	ok(t, err)
+
+	ids := n.index()
+	for id, kind := range map[string]int{
+		"born":   NDateField,
+		"email":  NEmailField,
+		"phone":  NPhoneField,
+		"resume": NFileField,
+		"bio":    NTextField,
+	} {
+		if ids[id] == nil || ids[id].Kind != kind {
+			t.Fatalf("expected %s to be a %s, got %v", id, nodeNames[kind], ids[id])
+		}
+	}
+
+	if w := ids["email"].Attrs["width"]; w != "20" {
+		t.Errorf("the @ shouldn't count toward the width of the box, got %s", w)
+	}
+
+	if d := ids["born"].Attrs["default"]; d != "" {
+		t.Errorf("yyyy-mm-dd isn't a default, got %s", d)
+	}
+
+	pdf := map[string]interface{}{"name": "cv.pdf", "type": "application/pdf", "data": "AAAA"}
+	good := Answers{
+		"born":   "1980-02-29",
+		"email":  "jane@example.com",
+		"phone":  "+1 (555) 010-0199",
+		"resume": pdf,
+		"bio":    strings.Repeat("x", 100),
+	}
+
+	if errs := n.Validate(good); len(errs) != 0 {
+		t.Fatalf("unexpected validation errors: %v", errs)
+	}
+
+	for _, c := range []struct {
+		field string
+		value interface{}
+		want  string
+	}{
+		{"born", "1980-02-30", "expected a date"},
+		{"born", "29/02/1980", "expected a date"},
+		{"born", "1899-12-31", "before 1900-01-01"},
+		{"born", "2011-01-01", "after 2010-12-31"},
+		{"email", "jane", "isn't an email address"},
+		{"email", "Jane <jane@example.com>", "isn't an email address"},
+		{"email", "jane@localhost", "isn't an email address"},
+		{"phone", "555-CALL-NOW", "isn't a phone number"},
+		{"phone", "555 01", "isn't a phone number"},
+		{"resume", "cv.pdf", "expected a file"},
+		{"resume", map[string]interface{}{"name": "cv.exe", "data": "AAAA"}, "isn't one of"},
+		{"resume", map[string]interface{}{"name": "me.jpg", "type": "image/jpeg", "data": "AAAAAAAAAAAAAAAA"}, "larger than 10 bytes"},
+		{"bio", strings.Repeat("x", 121), "longer than 120"},
+	} {
+		answers := Answers{}
+		for k, v := range good {
+			answers[k] = v
+		}
+		answers[c.field] = c.value
+
+		found := false
+		for _, e := range n.Validate(answers) {
+			if e.Field == c.field && strings.Contains(e.Message, c.want) {
+				found = true
+			}
+		}
+
+		if !found {
+			t.Errorf("expected \"%s\" for %s given %v, got %v", c.want, c.field, c.value, n.Validate(answers))
+		}
+	}
+
+	if when, err := good.Date("born"); err != nil || when.Year() != 1980 {
+		t.Errorf("expected 1980, got %v (%v)", when, err)
+	}
+
+	if f, err := good.File("resume"); err != nil || f.Name != "cv.pdf" || len(f.Data) != 3 {
+		t.Errorf("expected cv.pdf with 3 bytes, got %+v (%v)", f, err)
+	}
+}
+
+func TestBadKinds(t *testing.T) {
+	for src, want := range map[string]string{
+		"Born [ yyyy-mm-dd ] {min 1.1.1900}\n":                 "wants a date",
+		"Born [ yyyy-mm-dd ] {min 2000-01-01, max 1999-01-01}": "after max",
+		"Born [ yyyy-mm-dd ] {rows 3}\n":                       "doesn't apply",
+		"CV [          ^] {maxsize big}\n":                     "whole number",
+		"CV [          ^] {accept \"\"}\n":                     "accept needs",
+		"Bio [          ] {rows 0}\n":                          "rows has to be positive",
+		"Email [          @] {accept \".pdf\"}\n":              "doesn't apply",
+	} {
+		_, err := Parse([]byte(src))
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("expected an error containing \"%s\", got %v", want, err)
+		}
+	}
+}
+
+func TestFormFiles(t *testing.T) {
+	n, err := Parse([]byte(kindsForm))
+	ok(t, err)
+
+	body := &bytes.Buffer{}
+	mw := multipart.NewWriter(body)
+	ok(t, mw.WriteField("email", "jane@example.com"))
+
+	fw, err := mw.CreateFormFile("resume", "cv.pdf")
+	ok(t, err)
+	fw.Write([]byte("%PDF"))
+	ok(t, mw.Close())
+
+	form, err := multipart.NewReader(body, mw.Boundary()).ReadForm(1 << 20)
+	ok(t, err)
+
+	answers := n.FormAnswers(form.Value)
+	ok(t, n.FormFiles(answers, form.File))
+
+	f, err := answers.File("resume")
+	ok(t, err)
+
+	if f == nil || f.Name != "cv.pdf" || string(f.Data) != "%PDF" {
+		t.Fatalf("expected cv.pdf to come through, got %+v", f)
+	}
+
+	if answers["email"] != "jane@example.com" {
+		t.Errorf("expected the email address to come through, got %v", answers)
+	}
+
+	w := &bytes.Buffer{}
+	ok(t, n.HTML(w, &HTMLOptions{Template: "form", Answers: answers}))
+
+	out := w.String()
+	for _, want := range []string{
+		`enctype="multipart/form-data"`,
+		`<input type="date" id="f-born" name="born" value="" required min="1900-01-01" max="2010-12-31">`,
+		`<input type="email" id="f-email" name="email" value="jane@example.com"`,
+		`<input type="tel" id="f-phone"`,
+		`<input type="file" id="f-resume" name="resume" accept=".pdf,image/*">`,
+		`<span class="uploaded">cv.pdf</span>`,
+		`<textarea id="f-bio" name="bio" rows="4"`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("missing %s", want)
+		}
+	}
+
+	if t.Failed() {
+		t.Logf("%s", out)
+	}
+}
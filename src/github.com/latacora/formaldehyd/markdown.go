@@ -0,0 +1,252 @@
+package formaldehyd
+
+import (
+	"html"
+	"strings"
+	"unicode"
+)
+
+// Text and headings are a small subset of Markdown:
+//
+//	Paragraphs are separated by blank lines; the lines of
+//	one run together.
+//
+//	- a line starting with a dash is a list item
+//	- _emphasis_, __strong__, `code`
+//	- [links](https://example.com)
+//
+// So is the ? help under a field. Node.Text (or the "help"
+// attribute) keeps the source, tidied up by richText. Markdown renders
+// it as HTML that's safe to put on a page as is: everything is
+// escaped, and links only go to http, https and mailto URLs (or to
+// somewhere on the same site). Plain strips the markup instead.
+
+// richText tidies up the source of a run of text: whitespace inside a
+// line collapses, the lines of a paragraph are joined, and paragraphs
+// are separated by exactly one blank line. List items stay on lines
+// of their own.
+func richText(src string) string {
+	paras := []string{}
+	lines := []string{}
+
+	end := func() {
+		if len(lines) > 0 {
+			paras = append(paras, strings.Join(lines, "\n"))
+			lines = nil
+		}
+	}
+
+	for _, line := range strings.Split(src, "\n") {
+		line = strings.Join(strings.Fields(line), " ")
+
+		switch {
+		case line == "":
+			end()
+		case isItem(line) || len(lines) == 0 || isItem(lines[len(lines)-1]):
+			lines = append(lines, line)
+		default:
+			lines[len(lines)-1] += " " + line
+		}
+	}
+
+	end()
+	return strings.Join(paras, "\n\n")
+}
+
+func isItem(line string) bool {
+	return strings.HasPrefix(line, "- ")
+}
+
+// Markdown renders the text of a text or heading node as HTML:
+// paragraphs and lists for text, just the inline markup for headings.
+func (n *Node) Markdown() string {
+	if n.Kind != NText {
+		return spans(n.Text, true)
+	}
+
+	return markdown(n.Text)
+}
+
+// Plain is the text of a text or heading node without the markup.
+func (n *Node) Plain() string {
+	return plain(n.Text)
+}
+
+// markdown renders text tidied up by richText as paragraphs and lists.
+func markdown(text string) string {
+	var b strings.Builder
+
+	for _, para := range strings.Split(text, "\n\n") {
+		list, lines := false, []string{}
+
+		flush := func() {
+			if len(lines) > 0 {
+				b.WriteString("<p>" + spans(strings.Join(lines, " "), true) + "</p>\n")
+				lines = nil
+			}
+		}
+
+		for _, line := range strings.Split(para, "\n") {
+			if line == "" {
+				continue
+			}
+
+			if isItem(line) {
+				flush()
+				if !list {
+					b.WriteString("<ul>\n")
+					list = true
+				}
+				b.WriteString("<li>" + spans(line[2:], true) + "</li>\n")
+				continue
+			}
+
+			if list {
+				b.WriteString("</ul>\n")
+				list = false
+			}
+			lines = append(lines, line)
+		}
+
+		flush()
+		if list {
+			b.WriteString("</ul>\n")
+		}
+	}
+
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+// plain strips the markup from text tidied up by richText, running
+// its lines together.
+func plain(text string) string {
+	lines := []string{}
+	for _, line := range strings.Split(text, "\n") {
+		if line != "" {
+			lines = append(lines, spans(line, false))
+		}
+	}
+
+	return strings.Join(lines, " ")
+}
+
+// spans renders emphasis, code and links in one line of text, as
+// HTML or as plain text.
+func spans(s string, markup bool) string {
+	var b strings.Builder
+
+	text := func(s string) {
+		if markup {
+			s = html.EscapeString(s)
+		}
+		b.WriteString(s)
+	}
+
+	tag := func(name, body string) {
+		if markup {
+			b.WriteString("<" + name + ">" + body + "</" + name + ">")
+		} else {
+			b.WriteString(body)
+		}
+	}
+
+	for i := 0; i < len(s); {
+		rest := s[i:]
+
+		switch {
+		case rest[0] == '`':
+			if j := strings.IndexByte(rest[1:], '`'); j >= 0 {
+				body := rest[1 : j+1]
+				if markup {
+					body = html.EscapeString(body)
+				}
+				tag("code", body)
+				i += j + 2
+				continue
+			}
+
+		case rest[0] == '[':
+			mid := strings.Index(rest, "](")
+			if mid < 0 {
+				break
+			}
+
+			end := strings.IndexByte(rest[mid:], ')')
+			if end < 0 {
+				break
+			}
+
+			label, url := rest[1:mid], strings.TrimSpace(rest[mid+2:mid+end])
+			if !safeURL(url) {
+				break
+			}
+
+			if markup {
+				b.WriteString(`<a href="` + html.EscapeString(url) + `">` + spans(label, true) + "</a>")
+			} else {
+				b.WriteString(spans(label, false))
+			}
+
+			i += mid + end + 1
+			continue
+
+		case rest[0] == '_' && (i == 0 || !wordy(s[i-1])):
+			delim, name := "_", "em"
+			if strings.HasPrefix(rest, "__") {
+				delim, name = "__", "strong"
+			}
+
+			if j := closing(rest, delim); j > 0 && rest[len(delim)] != ' ' {
+				tag(name, spans(rest[len(delim):j], markup))
+				i += j + len(delim)
+				continue
+			}
+		}
+
+		text(s[i : i+1])
+		i++
+	}
+
+	return b.String()
+}
+
+// closing finds the delimiter that closes an emphasis opened at the
+// start of s: one that isn't right after the opening or a space, and
+// isn't in the middle of a word (so snake_case stays as it is).
+func closing(s, delim string) int {
+	for j := len(delim) + 1; j+len(delim) <= len(s); j++ {
+		if !strings.HasPrefix(s[j:], delim) {
+			continue
+		}
+
+		after := j + len(delim)
+		if (after == len(s) || !wordy(s[after])) && s[j-1] != ' ' {
+			return j
+		}
+	}
+
+	return -1
+}
+
+func wordy(c byte) bool {
+	return c == '_' || c >= 0x80 || unicode.IsLetter(rune(c)) || unicode.IsDigit(rune(c))
+}
+
+// safeURL is whether a link can go to url: anywhere on the web, an
+// email address, or somewhere on the same site.
+func safeURL(url string) bool {
+	lower := strings.ToLower(url)
+
+	for _, prefix := range []string{"http://", "https://", "mailto:"} {
+		if strings.HasPrefix(lower, prefix) {
+			return true
+		}
+	}
+
+	// browsers read //host and /\host as another site entirely
+	if strings.HasPrefix(url, "/") {
+		return len(url) == 1 || (url[1] != '/' && url[1] != '\\')
+	}
+
+	return strings.HasPrefix(url, "#")
+}
@@ -0,0 +1,107 @@
+package formaldehyd
+
+import (
+	"strings"
+	"testing"
+)
+
+const markdownForm = `
+About you
+---------
+
+Tell us about yourself in   a few
+words -- just the _basics_.
+
+Things we'll ask for:
+
+- your name
+- your __favorite__ color
+- a [link](https://example.com/about) to your ` + "`home page`" + `
+
+Name [                    ]
+`
+
+func TestRichText(t *testing.T) {
+	n, err := Parse([]byte(markdownForm))
+	ok(t, err)
+
+	page := n.Children[0]
+
+	texts := []*Node{}
+	for _, k := range page.Children {
+		if k.Kind == NText {
+			texts = append(texts, k)
+		}
+	}
+
+	if len(texts) != 1 {
+		t.Fatalf("expected one run of text, got %v", n)
+	}
+
+	text := texts[0]
+
+	want := "Tell us about yourself in a few words -- just the _basics_.\n\n" +
+		"Things we'll ask for:\n\n" +
+		"- your name\n" +
+		"- your __favorite__ color\n" +
+		"- a [link](https://example.com/about) to your `home page`"
+
+	if text.Text != want {
+		t.Fatalf("expected %q, got %q", want, text.Text)
+	}
+
+	html := "<p>Tell us about yourself in a few words -- just the <em>basics</em>.</p>\n" +
+		"<p>Things we&#39;ll ask for:</p>\n" +
+		"<ul>\n" +
+		"<li>your name</li>\n" +
+		"<li>your <strong>favorite</strong> color</li>\n" +
+		`<li>a <a href="https://example.com/about">link</a> to your <code>home page</code></li>` + "\n" +
+		"</ul>"
+
+	if got := text.Markdown(); got != html {
+		t.Errorf("expected %q, got %q", html, got)
+	}
+
+	plain := "Tell us about yourself in a few words -- just the basics. " +
+		"Things we'll ask for: - your name - your favorite color - a link to your home page"
+
+	if got := text.Plain(); got != plain {
+		t.Errorf("expected %q, got %q", plain, got)
+	}
+
+	if ids := n.index(); ids["about-you.name"] == nil {
+		t.Errorf("expected the name field after the list, got %v", n)
+	}
+
+	roundTrip(t, []byte(markdownForm))
+}
+
+func TestSpans(t *testing.T) {
+	for src, want := range map[string]string{
+		"plain <b>text</b> & more":          "plain &lt;b&gt;text&lt;/b&gt; &amp; more",
+		"snake_case_name stays":             "snake_case_name stays",
+		"_ not emphasis_":                   "_ not emphasis_",
+		"__strong__ and _em_, _two_ words":  "<strong>strong</strong> and <em>em</em>, <em>two</em> words",
+		"`<code>` and `unclosed":            "<code>&lt;code&gt;</code> and `unclosed",
+		"[safe](mailto:a@example.com)":      `<a href="mailto:a@example.com">safe</a>`,
+		"[local](/help#top)":                `<a href="/help#top">local</a>`,
+		"[bad](javascript:alert(1))":        "[bad](javascript:alert(1))",
+		"[other site](//evil.example.com)":  "[other site](//evil.example.com)",
+		`[other site](/\evil.example.com)`:  `[other site](/\evil.example.com)`,
+		"[home](/)":                         `<a href="/">home</a>`,
+		`[quote](https://x.com/?a="b")`:     `<a href="https://x.com/?a=&#34;b&#34;">quote</a>`,
+		"[_em_ label](https://example.com)": `<a href="https://example.com"><em>em</em> label</a>`,
+	} {
+		if got := spans(src, true); got != want {
+			t.Errorf("%q: expected %q, got %q", src, want, got)
+		}
+	}
+
+	if got := spans("[a _b_ `c`](https://example.com)", false); got != "a b c" {
+		t.Errorf("expected markup stripped, got %q", got)
+	}
+
+	if got := (&Node{Kind: NHeading, Text: "About _you_"}).Markdown(); strings.Contains(got, "<p>") || got != "About <em>you</em>" {
+		t.Errorf("expected headings to be inline only, got %q", got)
+	}
+}
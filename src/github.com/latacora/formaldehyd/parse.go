@@ -4,8 +4,10 @@ import (
 	"bytes"
 	"fmt"
 	"io"
+	"sort"
 	"strconv"
 	"strings"
+	"unicode/utf8"
 
 	"github.com/latacora/scan"
 )
@@ -22,6 +24,14 @@ const (
 	tokCButton
 	tokSwitchOn
 	tokSwitchOff
+	tokAttrs
+	tokDate
+	tokEmail
+	tokPhone
+	tokFile
+	tokComputed
+	tokPlusLine
+	tokUnknown
 )
 
 func ToString(buf []byte, t scan.Token) string {
@@ -50,6 +60,22 @@ func ToString(buf []byte, t scan.Token) string {
 		return fmt.Sprintf("SwitchOn: <%s>", val)
 	case tokSwitchOff:
 		return fmt.Sprintf("SwitchOff: <%s>", val)
+	case tokAttrs:
+		return fmt.Sprintf("Attrs: <%s>", val)
+	case tokDate:
+		return fmt.Sprintf("Date: <%s>", val)
+	case tokEmail:
+		return fmt.Sprintf("Email: <%s>", val)
+	case tokPhone:
+		return fmt.Sprintf("Phone: <%s>", val)
+	case tokFile:
+		return fmt.Sprintf("File: <%s>", val)
+	case tokComputed:
+		return fmt.Sprintf("Computed: <%s>", val)
+	case tokPlusLine:
+		return fmt.Sprintf("PlusLine: <%s>", val)
+	case tokUnknown:
+		return fmt.Sprintf("Unknown: <%s>", val)
 	case scan.TokEOF:
 		return fmt.Sprintf("EOF: <%s>", val)
 	default:
@@ -57,10 +83,6 @@ func ToString(buf []byte, t scan.Token) string {
 	}
 }
 
-func dbg(format string, args ...interface{}) {
-	fmt.Printf(format+"\n", args...)
-}
-
 var (
 	startAlnum = strings.Join([]string{scan.CharsIdent, ""}, "")
 	innerAlnum = strings.Join([]string{scan.CharsIdent, ".!?,;\"*'(@+-`#)&:<>/\\{}[]"}, "")
@@ -84,6 +106,80 @@ func scanPhrase(s *scan.Scanner) {
 	s.Emit(tokPhrase)
 }
 
+// scanAttrs takes a whole {...} attribute block as one token, so that
+// what's inside it (a /regex/, say) doesn't have to survive the rest
+// of the tokenizer. The block ends at the closing brace or the end of
+// the line, whichever comes first; a brace in a /regex/ or a "string"
+// doesn't count.
+func scanAttrs(s *scan.Scanner) {
+	s.Accept("{")
+
+	for !s.IsEOF() && !s.Peek("}\n") {
+		quote := ""
+		switch {
+		case s.Accept("/"):
+			quote = "/"
+		case s.Accept("\""):
+			quote = "\""
+		}
+
+		if quote != "" {
+			for !s.IsEOF() && !s.Peek(quote+"\n") {
+				if s.Accept("\\") && s.Peek("\n") {
+					break
+				}
+				s.Next()
+			}
+
+			s.Accept(quote)
+			continue
+		}
+
+		s.Next()
+	}
+
+	s.Accept("}")
+	s.Emit(tokAttrs)
+}
+
+// scanCode takes a `code span` as a phrase, whatever's in it, so that
+// it reads back exactly as it was written. A span that isn't closed
+// runs to the end of the line.
+func scanCode(s *scan.Scanner) {
+	s.Accept("`")
+
+	for !s.IsEOF() && !s.Peek("`\n") {
+		s.Next()
+	}
+
+	s.Accept("`")
+	s.Emit(tokPhrase)
+}
+
+// scanComputed takes a whole [= expression ] as one token, for the
+// same reason as scanAttrs. It ends at the closing bracket, unless
+// that's in a "string", or the end of the line.
+func scanComputed(s *scan.Scanner) {
+	for !s.IsEOF() && !s.Peek("]\n") {
+		if s.Accept("\"") {
+			for !s.IsEOF() && !s.Peek("\"\n") {
+				if s.Accept("\\") && s.Peek("\n") {
+					break
+				}
+				s.Next()
+			}
+
+			s.Accept("\"")
+			continue
+		}
+
+		s.Next()
+	}
+
+	s.Accept("]")
+	s.Emit(tokComputed)
+}
+
 func tokenize(buf []byte) (ret []scan.Token) {
 	tokens := []scan.Token{}
 	s := scan.New(buf, func(t scan.Token) { tokens = append(tokens, t) })
@@ -114,6 +210,9 @@ func tokenize(buf []byte) (ret []scan.Token) {
 		case s.AcceptExact("[("):
 			s.Emit(tokOButton)
 
+		case s.AcceptExact("[= "):
+			scanComputed(s)
+
 		case s.AcceptExact(")]"):
 			s.Emit(tokCButton)
 
@@ -123,6 +222,18 @@ func tokenize(buf []byte) (ret []scan.Token) {
 		case s.AcceptExact("_*"):
 			s.Emit(tokSwitchOff)
 
+		case s.AcceptExact("yyyy-mm-dd"):
+			s.Emit(tokDate)
+
+		case s.AcceptExact("@]"):
+			s.Emit(tokEmail)
+
+		case s.AcceptExact("#]"):
+			s.Emit(tokPhone)
+
+		case s.AcceptExact("^]"):
+			s.Emit(tokFile)
+
 		case s.Peek(startAlnum):
 			scanPhrase(s)
 
@@ -147,15 +258,28 @@ func tokenize(buf []byte) (ret []scan.Token) {
 		case s.Peek("|"):
 			s.AcceptAndEmit("|", scan.Code('|'))
 
+		case s.Peek("?"):
+			s.AcceptAndEmit("?", scan.Code('?'))
+
 		case s.Peek("-"):
 			s.EmitRun("-", tokDashLine)
 
 		case s.Peek("~"):
 			s.EmitRun("~", tokSquigLine)
 
+		case s.Peek("+"):
+			s.EmitRun("+", tokPlusLine)
+
+		case s.Peek("{"):
+			scanAttrs(s)
+
+		case s.Peek("`"):
+			scanCode(s)
+
 		default:
-			dbg("eat: <%s>", s.CurrentString())
+			// the parser skips these, with a warning
 			s.Next()
+			s.Emit(tokUnknown)
 		}
 	}
 
@@ -177,6 +301,13 @@ const (
 	NButton
 	NNumberField
 	NSwitchField
+	NChoiceField
+	NDateField
+	NEmailField
+	NPhoneField
+	NFileField
+	NComputedField
+	NRepeat
 )
 
 var nodeNames = []string{
@@ -194,6 +325,13 @@ var nodeNames = []string{
 	"Button",
 	"NumberField",
 	"SwitchField",
+	"ChoiceField",
+	"DateField",
+	"EmailField",
+	"PhoneField",
+	"FileField",
+	"ComputedField",
+	"Repeat",
 }
 
 type Node struct {
@@ -202,14 +340,21 @@ type Node struct {
 	Parent   *Node
 	Children []*Node
 	Line     int
+	Col      int
+	ID       string
 	Hash     string
 	Opt      string
+	Cond     *Condition
 	Attrs    map[string]string
+
+	above bool // see groupChoices
 }
 
 type parser struct {
 	buf         []byte
 	tokens      []scan.Token
+	lines       []int
+	cols        []int
 	off         int
 	optTag      string
 	state       int
@@ -217,40 +362,130 @@ type parser struct {
 	current     *Node
 	twidth      int
 	line        int
-	err         error
+	err         *ParseError
+	errs        ParseErrors
 	currentHash string
+	last        *Node
+	lastLine    int
+	helpSrc     string
 }
 
-func (p *parser) next() *scan.Token {
-	if (p.off + 1) >= len(p.tokens) {
-		return nil
+// locate works out the line and column of every token, for error
+// messages, and warns about the characters the tokenizer didn't know
+// what to do with. Columns count characters, not bytes, so that the
+// caret under an excerpt lines up.
+func (p *parser) locate() {
+	line, start := 1, 0
+
+	p.lines = make([]int, len(p.tokens))
+	p.cols = make([]int, len(p.tokens))
+
+	for i, t := range p.tokens {
+		col := utf8.RuneCount(p.buf[start:t.Start]) + 1
+
+		p.lines[i] = line
+		p.cols[i] = col
+
+		if t.Code == tokUnknown {
+			p.errs = append(p.errs, &ParseError{
+				Line:    line,
+				Column:  col,
+				Message: fmt.Sprintf("ignoring unexpected character \"%s\"", scan.TokenText(p.buf, []scan.Token{t})),
+				Warning: true,
+			})
+		}
+
+		if t.Code == tokNewline {
+			line++
+			start = t.End
+		}
 	}
+}
 
-	p.off++
+func (p *parser) next() *scan.Token {
+	for {
+		if (p.off + 1) >= len(p.tokens) {
+			return nil
+		}
 
-	if p.tokens[p.off].Code == tokNewline {
-		p.line++
-	}
+		p.off++
+
+		switch p.tokens[p.off].Code {
+		case tokUnknown:
+			continue
+		case tokNewline:
+			p.line++
+		}
 
-	return &p.tokens[p.off]
+		return &p.tokens[p.off]
+	}
 }
 
 func (p *parser) neednext() *scan.Token {
 	t := p.next()
 	if t == nil {
-		p.err = fmt.Errorf("unexpected end of input at")
+		p.fail("unexpected end of input")
 		return &scan.Token{Code: scan.TokEOF}
 	}
 
 	return t
 }
 
+// fail records an error at the current token. Whatever was being
+// parsed stops, and document() picks up again on the next line.
+func (p *parser) fail(format string, args ...interface{}) {
+	e := &ParseError{
+		Line:    p.line,
+		Message: fmt.Sprintf(format, args...),
+	}
+
+	if p.off >= 0 && p.off < len(p.tokens) {
+		e.Line = p.lines[p.off]
+		e.Column = p.cols[p.off]
+	}
+
+	p.err = e
+}
+
+// recover gets the parser back to a known state after an error: the
+// half-built field is dropped, and parsing resumes at the start of
+// the next line.
+func (p *parser) recover() {
+	p.errs = append(p.errs, p.err)
+	p.err = nil
+
+	for p.current.Kind != NDocument && p.current.Kind != NPage && p.current.Kind != NRepeat {
+		parent := p.current.Parent
+
+		for i, kid := range parent.Children {
+			if kid == p.current {
+				parent.Children = append(parent.Children[:i], parent.Children[i+1:]...)
+				break
+			}
+		}
+
+		p.current = parent
+	}
+
+	p.resetAccum()
+	p.twidth = 0
+	p.currentHash = ""
+	p.last = nil
+
+	for p.off >= 0 && p.off < len(p.tokens) && p.tokens[p.off].Code != tokNewline {
+		if p.next() == nil {
+			break
+		}
+	}
+}
+
 func (p *parser) addChild(kind int, tox []scan.Token) *Node {
 	new := &Node{
 		Kind:   kind,
 		Text:   cleansingFire(scan.TokenText(p.buf, tox)),
 		Parent: p.current,
 		Line:   p.line,
+		Col:    p.col(),
 		Hash:   p.currentHash,
 		Opt:    p.optTag,
 		Attrs:  map[string]string{},
@@ -262,6 +497,37 @@ func (p *parser) addChild(kind int, tox []scan.Token) *Node {
 	return new
 }
 
+// addText adds text (or a heading) made of toks, placed where the
+// text starts rather than where the parser has got to, which for a
+// paragraph can be several lines on. Text keeps its paragraphs and
+// list items; see richText.
+func (p *parser) addText(kind int, toks []scan.Token) *Node {
+	n := p.addChild(kind, toks)
+	n.Text = richText(scan.TokenText(p.buf, toks))
+
+	for _, t := range toks {
+		if t.Code == tokWs || t.Code == tokNewline {
+			continue
+		}
+
+		i := sort.Search(len(p.tokens), func(i int) bool { return p.tokens[i].Start >= t.Start })
+		if i < len(p.tokens) {
+			n.Line, n.Col = p.lines[i], p.cols[i]
+		}
+		break
+	}
+
+	return n
+}
+
+func (p *parser) col() int {
+	if p.off >= 0 && p.off < len(p.cols) {
+		return p.cols[p.off]
+	}
+
+	return 0
+}
+
 func (p *parser) at(off int) scan.Code {
 	if off >= len(p.tokens) {
 		return scan.TokEOF
@@ -273,10 +539,7 @@ func (p *parser) at(off int) scan.Code {
 func (p *parser) unexpected(t *scan.Token, context, message string) {
 	val := scan.TokenText(p.buf, []scan.Token{*t})
 
-	p.err = fmt.Errorf(`at line %d:
-while %s,
-got "%s"
-but expected %s`, p.line, context, val, message)
+	p.fail(`while %s, got "%s" but expected %s`, context, val, message)
 }
 
 func (p *parser) addAccum(t *scan.Token) {
@@ -287,37 +550,75 @@ func (p *parser) resetAccum() {
 	p.accum = []scan.Token{}
 }
 
+// textField takes the rest of a text box, up to the ] that closes it.
 func (p *parser) textField() {
 	for p.err == nil {
-		t := p.neednext()
+		if p.textFieldToken(p.neednext()) {
+			return
+		}
+	}
+}
 
-		if t.Code == scan.Code(']') {
-			l := 1
-			for _, nt := range p.accum {
-				if nt.Code == tokNewline {
-					l += 1
-				}
+// markers are the tokens that close a text box and make it something
+// more specific: [    @] is an email address, [    #] a phone number,
+// and [    ^] a file upload.
+var markers = map[scan.Code]int{
+	tokEmail: NEmailField,
+	tokPhone: NPhoneField,
+	tokFile:  NFileField,
+}
+
+// textFieldToken takes one token of a text box, and says whether it
+// was the one that closed it.
+func (p *parser) textFieldToken(t *scan.Token) bool {
+	kind, marker := markers[t.Code]
+
+	switch {
+	case t.Code == scan.Code(']') || marker:
+		if marker {
+			p.current.Kind = kind
+		}
+
+		l := 1
+		for _, nt := range p.accum {
+			if nt.Code == tokNewline {
+				l += 1
 			}
+		}
 
-			p.current.Attrs["width"] = strconv.Itoa(p.twidth)
-			p.current.Attrs["height"] = strconv.Itoa(l)
-			p.current.Attrs["default"] = cleansingFire(scan.TokenText(p.buf, p.accum))
-			p.twidth = 0
-			p.current = p.current.Parent
-			return
+		p.current.Attrs["width"] = strconv.Itoa(p.twidth)
+		p.current.Attrs["height"] = strconv.Itoa(l)
+		p.current.Attrs["default"] = cleansingFire(scan.TokenText(p.buf, p.accum))
+
+		// what's in a date box is a hint, not an answer, and nothing
+		// in a file box could be
+		if p.current.Kind == NDateField || p.current.Kind == NFileField {
+			p.current.Attrs["default"] = ""
 		}
 
-		if t.Code == tokPlusMinus {
-			p.current.Kind = NNumberField
-			p.current.Attrs["plusminus"] = "t"
-		} else if t.Code == tokSlider {
-			p.current.Kind = NNumberField
-			p.current.Attrs["slider"] = "t"
-		} else {
-			p.twidth += scan.TokenSpan([]scan.Token{*t})
-			p.addAccum(t)
+		p.resetAccum()
+		p.twidth = 0
+		p.current = p.current.Parent
+		return true
+
+	case t.Code == tokPlusMinus:
+		p.current.Kind = NNumberField
+		p.current.Attrs["plusminus"] = "t"
+
+	case t.Code == tokSlider:
+		p.current.Kind = NNumberField
+		p.current.Attrs["slider"] = "t"
+
+	default:
+		if t.Code == tokDate && p.current.Kind == NTextField {
+			p.current.Kind = NDateField
 		}
+
+		p.twidth += scan.TokenSpan([]scan.Token{*t})
+		p.addAccum(t)
 	}
+
+	return false
 }
 
 func (p *parser) checkOrText() {
@@ -348,9 +649,9 @@ func (p *parser) checkOrText() {
 			return
 		}
 
-		p.addAccum(t)
-		p.twidth += w
-		p.textField()
+		if !p.textFieldToken(t) {
+			p.textField()
+		}
 	}
 }
 
@@ -359,7 +660,7 @@ func (p *parser) dropNextField() {
 		t := p.neednext()
 
 		switch t.Code {
-		case tokWs, tokPhrase:
+		case tokWs, tokPhrase, tokDate, scan.Code('?'), tokPlusLine:
 			p.addAccum(t)
 
 		case tokNewline:
@@ -376,7 +677,7 @@ func (p *parser) dropNextField() {
 				}
 			}
 
-			if c == tokPhrase {
+			if c == tokPhrase || c == tokDate {
 				p.addAccum(t)
 			} else {
 				p.addChild(NSelection, p.accum)
@@ -440,10 +741,46 @@ func cleansingFire(input string) string {
 	return strings.Join(strings.Fields(strings.Replace(input, "|", "", -1)), " ")
 }
 
+// splitAbove splits the last line off a run of text, if it's not
+// blank.
+func splitAbove(toks []scan.Token) (text, above []scan.Token) {
+	j := len(toks) - 1
+	for j >= 0 && toks[j].Code == tokWs {
+		j--
+	}
+
+	if j < 0 || toks[j].Code == tokNewline {
+		return toks, nil
+	}
+
+	for k := j; k >= 0; k-- {
+		if toks[k].Code == tokNewline {
+			return toks[0:k], toks[k+1:]
+		}
+	}
+
+	return nil, toks
+}
+
 func (p *parser) field(t *scan.Token) {
 	for i := len(p.accum) - 1; i >= 0; i-- {
 		if p.accum[i].Code == tokNewline {
-			p.addChild(NText, p.accum[0:i])
+			text, above := p.accum[0:i], []scan.Token(nil)
+
+			// the line right above a radio button might be the
+			// question a group of them answers; see groupChoices
+			if t.Code == scan.Code('(') {
+				text, above = splitAbove(text)
+			}
+
+			if cleansingFire(scan.TokenText(p.buf, text)) != "" {
+				p.addText(NText, text)
+			}
+
+			if above != nil {
+				p.addText(NText, above).above = true
+			}
+
 			p.accum = p.accum[i:]
 			break
 		}
@@ -453,6 +790,8 @@ func (p *parser) field(t *scan.Token) {
 	p.current.Attrs["label"] = cleansingFire(scan.TokenText(p.buf, p.accum))
 	p.resetAccum()
 
+	f := p.current
+
 	switch t.Code {
 	case scan.Code('['):
 		p.checkOrText()
@@ -461,19 +800,67 @@ func (p *parser) field(t *scan.Token) {
 		p.current.Kind = NRadioField
 		p.radio()
 
+	case tokComputed:
+		p.current.Kind = NComputedField
+		p.computed(t)
+
 	default:
 		p.current.Kind = NDropField
 		p.dropField()
 	}
 
+	p.last = f
+	p.lastLine = p.line
+}
+
+// computed takes a [= expression ] box; see computed.go. The
+// expression is kept the way String writes it, and the box keeps its
+// width.
+func (p *parser) computed(t *scan.Token) {
+	text := scan.TokenText(p.buf, []scan.Token{*t})
+	if !strings.HasSuffix(text, "]") {
+		p.unexpected(t, "parsing a computed field", "a ] to close it")
+		return
+	}
+
+	e, err := parseExpr(text[2 : len(text)-1])
+	if err != nil {
+		p.fail("%s", err)
+		return
+	}
+
+	expr := e.String()
+	p.current.Attrs["expr"] = expr
+	p.current.Attrs["width"] = strconv.Itoa(maxInt(len(text)-2, len(expr)+3))
+	p.current = p.current.Parent
 }
 
 func (p *parser) page() {
+	if p.current.Kind == NRepeat {
+		p.fail("repeat \"%s\" has to be closed, with a line of +s, before the next page", p.current.Attrs["label"])
+		return
+	}
+
 	if p.current.Kind != NDocument && p.current.Kind != NPage {
-		p.err = fmt.Errorf("can't nest pages")
+		p.fail("can't nest pages")
 		return
 	}
 
+	// only the line right above the dashes is the label; anything
+	// before it is text at the end of the previous page
+	end := len(p.accum)
+	for end > 0 && (p.accum[end-1].Code == tokWs || p.accum[end-1].Code == tokNewline) {
+		end--
+	}
+
+	for i := end - 1; i >= 0; i-- {
+		if p.accum[i].Code == tokNewline {
+			p.addText(NText, p.accum[0:i])
+			p.accum = p.accum[i:]
+			break
+		}
+	}
+
 	if p.current.Kind == NPage {
 		p.current = p.current.Parent
 	}
@@ -488,13 +875,12 @@ func (p *parser) text(t *scan.Token) {
 
 	for t != nil && p.err == nil {
 		switch t.Code {
-		case tokWs, tokNewline, tokPhrase:
+		case tokWs, tokNewline, tokPhrase, tokDate, tokAttrs:
 			p.addAccum(t)
 
 		case scan.Code('#'):
 			if prev != nil && prev.Code == tokNewline {
-				p.addChild(NText, p.accum)
-				p.resetAccum()
+				p.flushText()
 				p.hashtagOrHeader()
 				return
 			}
@@ -502,13 +888,69 @@ func (p *parser) text(t *scan.Token) {
 			p.addAccum(t)
 
 		case tokDashLine:
+			// only dashes on a line of their own underline a page;
+			// anything else is a list item, or a dash in a sentence
+			if p.restOfLine() {
+				p.addAccum(t)
+				break
+			}
+
 			p.page()
 			return
 
-		case scan.Code('['), scan.Code('('), scan.Code('*'):
+		case scan.Code('['):
+			// [text](url) is a link, not a field
+			if p.at(p.off+1) == tokPhrase && strings.Contains(scan.TokenText(p.buf, p.tokens[p.off+1:p.off+2]), "](") {
+				p.addAccum(t)
+				break
+			}
+
+			p.field(t)
+			return
+
+		case scan.Code('('), scan.Code('*'), tokComputed:
 			p.field(t)
 			return
 
+		case scan.Code('?'):
+			// a ? starting a line is help for the field above it;
+			// anywhere else it's just a question mark
+			if prev != nil && prev.Code == tokNewline {
+				p.flushText()
+				p.help(t)
+				return
+			}
+
+			p.addAccum(t)
+
+		case tokOButton, tokSquigLine:
+			// a button or a ~ line on a line of its own ends the
+			// text; in the middle of a line it's a mistake
+			if prev == nil || prev.Code != tokNewline {
+				p.unexpected(t, "parsing a run of text",
+					"a page marker, a hash tag, the start of a field, or a drop-down")
+				return
+			}
+
+			p.flushText()
+			if t.Code == tokOButton {
+				p.button()
+			} else {
+				p.opt()
+			}
+			return
+
+		case tokPlusLine:
+			// a line of +s starts or ends a repeat; a + anywhere else
+			// is just a plus sign
+			if (prev == nil || prev.Code == tokNewline) && p.repeatLine() {
+				p.flushText()
+				p.repeat()
+				return
+			}
+
+			p.addAccum(t)
+
 		default:
 			p.unexpected(t, "parsing a run of text",
 				"a page marker, a hash tag, the start of a field, or a drop-down")
@@ -518,12 +960,55 @@ func (p *parser) text(t *scan.Token) {
 		prev = t
 		t = p.next()
 	}
+
+	if p.err == nil {
+		p.flushText()
+	}
+}
+
+// restOfLine is whether there's more than whitespace on the current
+// line after the current token.
+func (p *parser) restOfLine() bool {
+	for i := p.off + 1; i < len(p.tokens); i++ {
+		switch p.tokens[i].Code {
+		case tokWs, tokUnknown:
+			continue
+		case tokNewline:
+			return false
+		}
+
+		return true
+	}
+
+	return false
+}
+
+// flushText ends a run of text that turned out not to be the label
+// of anything.
+func (p *parser) flushText() {
+	if cleansingFire(scan.TokenText(p.buf, p.accum)) != "" {
+		p.addText(NText, p.accum)
+	}
+
+	p.resetAccum()
 }
 
 func (p *parser) hashtagOrHeader() {
 	t := p.neednext()
 	switch t.Code {
 	case tokPhrase:
+		// a tag names whatever comes after it, so any text on the
+		// lines before it is finished
+		for i := len(p.accum) - 1; i >= 0; i-- {
+			if p.accum[i].Code == tokNewline {
+				if cleansingFire(scan.TokenText(p.buf, p.accum[:i])) != "" {
+					p.addText(NText, p.accum[:i])
+				}
+				p.accum = p.accum[i:]
+				break
+			}
+		}
+
 		p.currentHash = scan.TokenText(p.buf, []scan.Token{*t})
 
 	case tokWs:
@@ -536,7 +1021,7 @@ func (p *parser) hashtagOrHeader() {
 			return
 		}
 
-		p.addChild(NHeading, p.accum)
+		p.addText(NHeading, p.accum)
 		p.resetAccum()
 
 	default:
@@ -544,6 +1029,116 @@ func (p *parser) hashtagOrHeader() {
 	}
 }
 
+// attrs applies a {...} block to the field it trails.
+func (p *parser) attrs(t *scan.Token) {
+	if p.last == nil || p.lastLine != p.line {
+		p.unexpected(t, "parsing an attribute block", "it to follow a field on the same line")
+		return
+	}
+
+	text := scan.TokenText(p.buf, []scan.Token{*t})
+	if !strings.HasSuffix(text, "}") {
+		p.unexpected(t, "parsing an attribute block", "a } to close it")
+		return
+	}
+
+	if err := p.last.applyAttrs(text[1 : len(text)-1]); err != nil {
+		p.fail("%s", err)
+	}
+}
+
+// help attaches a line of help to the field on the line above it:
+//
+//	Email [                    @]
+//	? We'll only use this to send you a receipt.
+//
+// A field's help can run over several ? lines, with a line that's
+// just a ? between paragraphs, and has the same markup as text; see
+// markdown.go.
+func (p *parser) help(t *scan.Token) {
+	if p.last == nil || p.last.Kind == NButton || p.lastLine != p.line-1 {
+		p.unexpected(t, "parsing field help", "it to be on the line after a field")
+		return
+	}
+
+	line := p.line
+	for t = p.next(); t != nil && t.Code != tokNewline; t = p.next() {
+		p.addAccum(t)
+	}
+
+	if p.last.Attrs["help"] == "" {
+		p.helpSrc = ""
+	}
+
+	p.helpSrc += "\n" + scan.TokenText(p.buf, p.accum)
+	p.last.Attrs["help"] = richText(p.helpSrc)
+	p.lastLine = line
+	p.resetAccum()
+}
+
+// repeatLine is whether the line starting at the current token, a run
+// of +s, opens a repeat (+ Label +++) or closes one (+++).
+func (p *parser) repeatLine() bool {
+	span := func(i int) int { return scan.TokenSpan(p.tokens[i : i+1]) }
+
+	if !p.restOfLine() {
+		return span(p.off) >= 3
+	}
+
+	label := false
+	for i := p.off + 1; i < len(p.tokens) && p.tokens[i].Code != tokNewline; i++ {
+		switch p.tokens[i].Code {
+		case tokPhrase:
+			label = true
+		case tokPlusLine:
+			return label && span(i) >= 3
+		}
+	}
+
+	return false
+}
+
+// repeat opens a repeat (+ Label +++) or closes one (+++); see
+// repeat.go.
+func (p *parser) repeat() {
+	for p.err == nil {
+		// the closing line can be the last in the file
+		t := p.next()
+
+		if t != nil && t.Code != tokNewline && t.Code != tokPlusLine {
+			p.addAccum(t)
+			continue
+		}
+
+		label := cleansingFire(scan.TokenText(p.buf, p.accum))
+		p.resetAccum()
+
+		if label == "" {
+			if p.current.Kind != NRepeat {
+				p.fail("there's no repeat to close here")
+				return
+			}
+
+			p.current = p.current.Parent
+			p.last = nil
+			return
+		}
+
+		if p.current.Kind == NRepeat {
+			p.fail("can't nest repeats")
+			return
+		}
+
+		p.current = p.addChild(NRepeat, nil)
+		p.current.Attrs["label"] = label
+		// so that a {min, max} block or ? help can follow
+		// so that a {min, max} block, a #tag or ? help can follow
+		p.last = p.current
+		p.lastLine = p.line
+		return
+	}
+}
+
 func (p *parser) opt() {
 	for p.err == nil {
 		t := p.neednext()
@@ -552,6 +1147,7 @@ func (p *parser) opt() {
 			p.addAccum(t)
 		} else {
 			p.optTag = cleansingFire(scan.TokenText(p.buf, p.accum))
+			p.resetAccum()
 			return
 		}
 	}
@@ -562,11 +1158,12 @@ func (p *parser) button() {
 		t := p.neednext()
 		switch {
 		case t.Code == tokCButton && len(p.accum) == 0:
-			p.err = fmt.Errorf("can't have button without label")
+			p.fail("can't have button without label")
 		case t.Code == tokNewline:
-			p.err = fmt.Errorf("buttons fit on one line please")
+			p.fail("buttons fit on one line please")
 		case t.Code == tokCButton:
-			p.addChild(NButton, p.accum)
+			p.last = p.addChild(NButton, p.accum)
+			p.lastLine = p.line
 			p.resetAccum()
 			return
 		default:
@@ -578,10 +1175,10 @@ func (p *parser) button() {
 func (p *parser) document() {
 	t := p.next()
 
-	for t != nil && p.err == nil {
+	for t != nil {
 		switch t.Code {
 		case tokWs, tokNewline:
-		case tokPhrase:
+		case tokPhrase, tokDate:
 			p.text(t)
 
 		case tokOButton:
@@ -590,18 +1187,57 @@ func (p *parser) document() {
 		case tokSquigLine:
 			p.opt()
 
+		case tokPlusLine:
+			if p.repeatLine() {
+				p.repeat()
+				break
+			}
+			p.text(t)
+
 		case scan.Code('#'):
 			p.hashtagOrHeader()
 
+		case tokAttrs:
+			p.attrs(t)
+
+		case scan.Code('?'):
+			p.help(t)
+
+		case tokDashLine:
+			// a list at the top of the document
+			if p.restOfLine() {
+				p.text(t)
+				break
+			}
+			fallthrough
+
 		default:
 			p.unexpected(t, "parsing the document", "whitespace, text, a button, or a hash tag")
 		}
 
+		if p.err != nil {
+			p.recover()
+		}
+
 		t = p.next()
 	}
 }
 
+// Parse reads a form. If there's anything wrong with it, the error
+// is a ParseErrors listing every problem (but not the warnings; see
+// ParseAll), and the tree is whatever could be salvaged.
 func Parse(buf []byte) (node *Node, err error) {
+	node, errs := ParseAll(buf)
+	if errs = errs.Errors(); len(errs) > 0 {
+		return node, errs
+	}
+
+	return node, nil
+}
+
+// ParseAll reads a form, carrying on past errors, and returns the
+// tree along with every error and warning it found.
+func ParseAll(buf []byte) (*Node, ParseErrors) {
 	p := &parser{
 		buf:         buf,
 		tokens:      tokenize(buf),
@@ -613,13 +1249,34 @@ func Parse(buf []byte) (node *Node, err error) {
 		currentHash: "",
 	}
 
+	p.locate()
 	p.document()
 
+	if p.current.Kind == NRepeat {
+		p.errs = append(p.errs, nodeError(p.current, "repeat \"%s\" is never closed; end it with a line of +s", p.current.Attrs["label"]))
+	}
+
 	for p.current.Parent != nil {
 		p.current = p.current.Parent
 	}
 
-	return p.current, p.err
+	p.errs = append(p.errs, groupChoices(p.current)...)
+	p.errs = append(p.errs, assignIDs(p.current)...)
+	p.errs = append(p.errs, checkRepeats(p.current)...)
+	p.errs = append(p.errs, resolveConditions(p.current)...)
+	p.errs = append(p.errs, resolveComputed(p.current)...)
+	p.errs = append(p.errs, resolveActions(p.current)...)
+	p.errs.excerpt(buf)
+
+	sort.SliceStable(p.errs, func(i, j int) bool {
+		a, b := p.errs[i], p.errs[j]
+		if a.Line != b.Line {
+			return a.Line < b.Line
+		}
+		return a.Column < b.Column
+	})
+
+	return p.current, p.errs
 }
 
 func (n *Node) stringRec(w io.Writer, depth int) {
@@ -629,6 +1286,10 @@ func (n *Node) stringRec(w io.Writer, depth int) {
 
 	w.Write([]byte(nodeNames[n.Kind]))
 
+	if n.ID != "" {
+		w.Write([]byte(" #" + n.ID))
+	}
+
 	if n.Text != "" {
 		w.Write([]byte(" " + n.Text + " "))
 	}
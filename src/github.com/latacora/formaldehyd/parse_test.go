@@ -0,0 +1,81 @@
+package formaldehyd
+
+import (
+	"strings"
+	"testing"
+)
+
+// kinds lists the kinds of n's children, and theirs, in order.
+func kinds(n *Node) string {
+	ret := []string{}
+	for _, kid := range n.Children {
+		s := nodeNames[kid.Kind]
+		if len(kid.Children) > 0 && kid.Kind != NDropField && kid.Kind != NChoiceField {
+			s += "(" + kinds(kid) + ")"
+		}
+		ret = append(ret, s)
+	}
+
+	return strings.Join(ret, " ")
+}
+
+func TestTextEnds(t *testing.T) {
+	for src, want := range map[string]string{
+		// the page label is only the line above the dashes
+		"Some words.\n\nPage\n----\n\nName [    ]\n": "Text Page(TextField)",
+
+		// a button or a ~ line on a line of its own ends a run of text
+		"Some words.\n[( Submit )]\n":                                           "Text Button",
+		"#agree\nAgree [ ]\nSome words.\n~ agree ~~~\nName [    ]\n~~~~~~~~~\n": "CheckField Text TextField",
+
+		// and text at the very end of the form is kept
+		"Name [    ]\n\nThanks!\n": "TextField Text",
+	} {
+		n, err := Parse([]byte(src))
+		if err != nil {
+			t.Errorf("%q: %s", src, err)
+			continue
+		}
+
+		if got := kinds(n); got != want {
+			t.Errorf("%q: expected %s, got %s", src, want, got)
+		}
+	}
+
+	// in the middle of a line, though, they're mistakes
+	for _, src := range []string{
+		"Broken [( Submit )]\n",
+		"Some words ~~~\n",
+	} {
+		if _, err := Parse([]byte(src)); err == nil || !strings.Contains(err.Error(), "parsing a run of text") {
+			t.Errorf("%q: expected an error, got %v", src, err)
+		}
+	}
+}
+
+func TestTags(t *testing.T) {
+	// a tag names whatever comes after it, as it always has, even
+	// when it trails a line of fields
+	n, err := Parse([]byte(`Some words.
+#name
+Name [          ]
+
+Pick [ ] 9 [ ] 10 [ ] #why
+
+Why [          ]
+`))
+	ok(t, err)
+
+	if got := kinds(n); got != "Text TextField CheckField CheckField CheckField TextField" {
+		t.Fatalf("expected the words kept as text, got %s", got)
+	}
+
+	tags := []string{}
+	for _, kid := range n.Children {
+		tags = append(tags, kid.Hash)
+	}
+
+	if got := strings.Join(tags, " "); got != " name    why" {
+		t.Errorf("expected the tags on the name and why, got %q", got)
+	}
+}
@@ -0,0 +1,280 @@
+package formaldehyd
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// A repeat is a group of fields answered any number of times, once
+// per row, like a list of dependants:
+//
+//	+ Dependants +++++++++++++++ {min 1, max 6}
+//	? Everyone you claim on your return.
+//
+//	Name [                    ]
+//	Born [ yyyy-mm-dd ]
+//	Student [ ]
+//
+//	+++++++++++++++++++++++++++
+//
+// It's opened by a line with its label between +s, and closed by a
+// line of nothing but +s. The block on the opening line can say how
+// many rows there have to be; min is 0 and there's no max unless it
+// says otherwise.
+//
+// A repeat is a field: its answer is a list of rows, and each row is
+// an object with an answer for each field in the repeat, keyed by the
+// field's ID with the repeat's ID taken off the front:
+//
+//	{"dependants": [{"name": "Ann", "born": "2015-04-01", "student": true}]}
+//
+// Errors in a row are about fields named like "dependants[0].name".
+// A repeat can have text in it and sit inside a ~~~ block, but pages,
+// buttons, file uploads, computed fields and other repeats can't go in
+// one, and conditions and computed fields elsewhere can't depend on
+// what's in one.
+
+// inRepeat is the repeat n is in, or nil.
+func (n *Node) inRepeat() *Node {
+	for k := n.Parent; k != nil; k = k.Parent {
+		if k.Kind == NRepeat {
+			return k
+		}
+	}
+
+	return nil
+}
+
+// rowKey is what the answer to f is keyed by in a row of repeat r.
+func (r *Node) rowKey(f *Node) string {
+	if len(f.ID) > len(r.ID)+1 && f.ID[:len(r.ID)+1] == r.ID+"." {
+		return f.ID[len(r.ID)+1:]
+	}
+
+	return f.ID
+}
+
+// rowName is how errors refer to a field in a row.
+func rowName(r *Node, i int, key string) string {
+	return fmt.Sprintf("%s[%d].%s", r.ID, i, key)
+}
+
+// named finds the node an error's field name refers to. A name from
+// rowName refers to the repeat the row is in.
+func (n *Node) named(name string) (*Node, bool) {
+	if i := strings.Index(name, "["); i != -1 {
+		name = name[:i]
+	}
+
+	k, ok := n.index()[name]
+	return k, ok
+}
+
+// rows decodes the answer to a repeat: a list of objects, however
+// they were built.
+func rows(v interface{}) ([]Answers, bool) {
+	switch t := v.(type) {
+	case nil:
+		return nil, true
+
+	case []Answers:
+		return t, true
+
+	case []map[string]interface{}:
+		ret := []Answers{}
+		for _, row := range t {
+			ret = append(ret, Answers(row))
+		}
+		return ret, true
+
+	case []interface{}:
+		ret := []Answers{}
+		for _, row := range t {
+			switch r := row.(type) {
+			case map[string]interface{}:
+				ret = append(ret, Answers(r))
+			case Answers:
+				ret = append(ret, r)
+			default:
+				return nil, false
+			}
+		}
+		return ret, true
+	}
+
+	return nil, false
+}
+
+// Rows is the answer to a repeat, a row at a time; nil if there isn't
+// one. The answers in a row are keyed as described above, so the
+// Answers methods work on them too.
+func (a Answers) Rows(id string) ([]Answers, error) {
+	ret, ok := rows(a[id])
+	if !ok {
+		return nil, fmt.Errorf("%s: expected a list of rows", id)
+	}
+
+	return ret, nil
+}
+
+// checkRepeats makes sure nothing's in a repeat that can't be, and
+// that no two of its fields are answered under the same key.
+func checkRepeats(root *Node) (errs ParseErrors) {
+	var walk func(r, n *Node)
+	walk = func(r, n *Node) {
+		for _, kid := range n.Children {
+			if kid.Kind == NRepeat && r == nil {
+				walk(kid, kid)
+
+				if len(kid.Fields()) == 0 {
+					errs = append(errs, nodeError(kid, "repeat \"%s\" has no fields", kid.Attrs["label"]))
+				}
+
+				continue
+			}
+
+			if r == nil {
+				walk(nil, kid)
+				continue
+			}
+
+			switch kid.Kind {
+			case NPage, NButton, NRepeat, NFileField, NComputedField:
+				what := map[int]string{
+					NPage:          "a page",
+					NButton:        "a button",
+					NRepeat:        "another repeat",
+					NFileField:     "a file upload",
+					NComputedField: "a computed field",
+				}[kid.Kind]
+
+				errs = append(errs, nodeError(kid, "%s can't go in a repeat", what))
+				continue
+			}
+		}
+	}
+
+	walk(nil, root)
+
+	for _, f := range root.Fields() {
+		if f.Kind != NRepeat {
+			continue
+		}
+
+		seen := map[string]*Node{}
+		for _, k := range f.Fields() {
+			key := f.rowKey(k)
+			if prev, ok := seen[key]; ok {
+				errs = append(errs, nodeError(k, "%s and %s are both answered as \"%s\" in a row of %s; "+
+					"give one of them a different #tag", prev.ID, k.ID, key, f.ID))
+			}
+			seen[key] = k
+		}
+	}
+
+	return errs
+}
+
+// validateRows checks the answer to repeat r: the number of rows, and
+// the answers in each.
+func validateRows(r *Node, v interface{}, vis *visibility) (errs []*FieldError) {
+	fail := func(f *Node, name, msg string) {
+		errs = append(errs, &FieldError{
+			Field:   name,
+			Label:   f.Attrs["label"],
+			Line:    f.Line,
+			Message: msg,
+		})
+	}
+
+	rs, ok := rows(v)
+	if !ok {
+		fail(r, r.ID, "expected a list of rows")
+		return errs
+	}
+
+	plural := func(n int) string {
+		if n == 1 {
+			return "1 row"
+		}
+		return fmt.Sprintf("%d rows", n)
+	}
+
+	if min := atoi(r.Attrs["min"]); len(rs) < min {
+		fail(r, r.ID, "needs at least "+plural(min))
+	}
+
+	if max := r.Attrs["max"]; max != "" && len(rs) > atoi(max) {
+		fail(r, r.ID, "can't have more than "+plural(atoi(max)))
+	}
+
+	fields := r.Fields()
+
+	for i, row := range rs {
+		seen := map[string]bool{}
+
+		for _, f := range fields {
+			key := r.rowKey(f)
+			seen[key] = true
+
+			if !vis.visible(f) {
+				continue
+			}
+
+			v, ok := row[key]
+			if !ok || v == nil || v == "" {
+				if f.Attrs["required"] == "t" {
+					fail(f, rowName(r, i, key), "required")
+				}
+				continue
+			}
+
+			if msg := f.check(v); msg != "" {
+				fail(f, rowName(r, i, key), msg)
+			}
+		}
+
+		// in order, as in validate
+		extra := []string{}
+		for key := range row {
+			if !seen[key] {
+				extra = append(extra, key)
+			}
+		}
+		sort.Strings(extra)
+
+		for _, key := range extra {
+			errs = append(errs, &FieldError{
+				Field:   rowName(r, i, key),
+				Message: "no such field",
+			})
+		}
+	}
+
+	return errs
+}
+
+// pruneRows drops the answers in each row to fields that are hidden.
+func pruneRows(r *Node, v interface{}, vis *visibility) interface{} {
+	rs, ok := rows(v)
+	if !ok || rs == nil {
+		return v
+	}
+
+	ret := []Answers{}
+	for _, row := range rs {
+		pruned := Answers{}
+		for _, f := range r.Fields() {
+			if k := r.rowKey(f); vis.visible(f) {
+				if v, ok := row[k]; ok {
+					pruned[k] = v
+				}
+			}
+		}
+
+		ret = append(ret, pruned)
+	}
+
+	return ret
+}
@@ -0,0 +1,217 @@
+package formaldehyd
+
+import (
+	"bytes"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestRepeat(t *testing.T) {
+	n, err := Parse(fixture("repeat.form"))
+	ok(t, err)
+
+	r := n.index()["tax-return.dependants"]
+	if r == nil || r.Kind != NRepeat {
+		t.Fatalf("expected a repeat, got %v", r)
+	}
+
+	if r.Attrs["label"] != "Dependants" || r.Attrs["min"] != "1" || r.Attrs["max"] != "4" {
+		t.Errorf("unexpected attributes %v", r.Attrs)
+	}
+
+	keys := []string{}
+	for _, f := range r.Fields() {
+		keys = append(keys, r.rowKey(f))
+	}
+
+	if got := strings.Join(keys, " "); got != "name born relation student spouse-s-too" {
+		t.Errorf("unexpected row keys %s", got)
+	}
+
+	// the field after the repeat isn't in it
+	if f := n.index()["tax-return.notes"]; f == nil || f.inRepeat() != nil {
+		t.Errorf("expected notes outside the repeat, got %v", f)
+	}
+
+	back, err := FromJSON([]byte(n.JSON()))
+	ok(t, err)
+
+	if !Equivalent(n, back) {
+		t.Errorf("expected the repeat to survive JSON, got %v", back)
+	}
+
+	roundTrip(t, fixture("repeat.form"))
+
+	// a run of +s in a paragraph is just text
+	n, err = Parse([]byte("Add it up: 1 +++ 2\n\nName [    ]\n"))
+	ok(t, err)
+
+	if n.Children[0].Kind != NText {
+		t.Errorf("expected text, got %v", n.Children[0])
+	}
+}
+
+func TestBadRepeat(t *testing.T) {
+	for src, want := range map[string]string{
+		"+ Kids +++\nName [    ]\n":                                              "never closed",
+		"Name [    ]\n+++\n":                                                     "no repeat to close",
+		"+ Kids +++\n+ Toys +++\nName [    ]\n+++\n+++\n":                        "can't nest repeats",
+		"+ Kids +++\nName [    ]\n\nPage\n----\n+++\n":                           "before the next page",
+		"+ Kids +++\nCV [     ^]\n+++\n":                                         "a file upload can't go in a repeat",
+		"+ Kids +++\nTotal [= 1 ]\n+++\n":                                        "a computed field can't go in a repeat",
+		"+ Kids +++\nJust text.\n+++\n":                                          "has no fields",
+		"+ Kids +++ {required}\nName [    ]\n+++\n":                              "min",
+		"+ Kids +++ {max 0}\nName [    ]\n+++\n":                                 "max",
+		"#kids\n+ Kids +++\n#a\nName [    ]\n#kids.a\nName [    ]\n+++\n":        "both answered as \"a\"",
+		"+ Kids +++\n#name\nName [    ]\n+++\n~ name ~~~~\nAge [  +/-]\n~~~~~~~": "conditions can only depend on fields outside a repeat",
+		"+ Kids +++\n#age\nAge [  +/-]\n+++\nTotal [= age * 2 ]\n":               "can't be used in an expression",
+	} {
+		_, err := Parse([]byte(src))
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%q: expected an error about %q, got %v", src, want, err)
+		}
+	}
+}
+
+func TestValidateRows(t *testing.T) {
+	n, err := Parse(fixture("repeat.form"))
+	ok(t, err)
+
+	errs := n.Validate(Answers{
+		"name": "Ann",
+		"tax-return.dependants": []interface{}{
+			map[string]interface{}{"name": "Bo", "born": "2015-04-01", "relation": "child"},
+			map[string]interface{}{"born": "yesterday", "pet": "cat"},
+		},
+	})
+
+	got := map[string]string{}
+	for _, e := range errs {
+		got[e.Field] = e.Message
+	}
+
+	for field, want := range map[string]string{
+		"tax-return.dependants[1].name": "required",
+		"tax-return.dependants[1].born": "",
+		"tax-return.dependants[1].pet":  "no such field",
+	} {
+		if msg, ok := got[field]; !ok || !strings.Contains(msg, want) {
+			t.Errorf("expected an error for %s about %q, got %v", field, want, got)
+		}
+	}
+
+	if len(errs) != 3 {
+		t.Errorf("expected three errors, got %v", errs)
+	}
+
+	// unknown answers in a row come back in order too
+	for i := 0; i < 10; i++ {
+		errs := n.Validate(Answers{
+			"name":                  "Ann",
+			"tax-return.dependants": []interface{}{map[string]interface{}{"name": "Bo", "zebra": 1, "apple": 2, "mango": 3}},
+		})
+
+		got := []string{}
+		for _, e := range errs {
+			got = append(got, e.Field)
+		}
+
+		if s := strings.Join(got, " "); s != "tax-return.dependants[0].apple tax-return.dependants[0].mango tax-return.dependants[0].zebra" {
+			t.Fatalf("expected unknown fields in order, got %s", s)
+		}
+	}
+
+	for want, v := range map[string]interface{}{
+		"needs at least 1 row":        nil,
+		"can't have more than 4 rows": []interface{}{Answers{"name": "a"}, Answers{"name": "b"}, Answers{"name": "c"}, Answers{"name": "d"}, Answers{"name": "e"}},
+		"expected a list of rows":     "Bo",
+	} {
+		errs := n.Validate(Answers{"name": "Ann", "tax-return.dependants": v})
+		if len(errs) != 1 || errs[0].Field != "tax-return.dependants" || errs[0].Message != want {
+			t.Errorf("expected %q, got %v", want, errs)
+		}
+	}
+
+	// the spouse's box is only there for the married
+	answers := Answers{
+		"name":                  "Ann",
+		"tax-return.dependants": []interface{}{map[string]interface{}{"name": "Bo", "spouse-s-too": true}},
+	}
+
+	pruned := n.Prune(answers)
+	if row := pruned["tax-return.dependants"].([]Answers)[0]; row["spouse-s-too"] != nil || row["name"] != "Bo" {
+		t.Errorf("expected the hidden answer pruned from the row, got %v", row)
+	}
+
+	sub, err := n.GoSource(&GoOptions{Package: "forms"})
+	ok(t, err)
+
+	for _, want := range []string{
+		"type SubmissionDependantsRow struct {",
+		"Dependants []SubmissionDependantsRow `json:\"tax-return.dependants\"` // Dependants",
+		"SpouseSToo bool                            `json:\"spouse-s-too\"` // Spouse's too",
+		`r, err := SubmissionDependantsRowFromAnswers(row)`,
+	} {
+		if !strings.Contains(string(sub), want) {
+			t.Errorf("expected %q in\n%s", want, sub)
+		}
+	}
+
+	s := n.Schema().Properties["tax-return.dependants"]
+	if s.Type != "array" || s.Items == nil || *s.MinItems != 1 || *s.MaxItems != 4 {
+		t.Fatalf("expected an array of 1 to 4 rows, got %+v", s)
+	}
+
+	if strings.Join(s.Items.Required, " ") != "name" {
+		t.Errorf("expected only the name required in a row, got %v", s.Items.Required)
+	}
+}
+
+func TestHTMLRows(t *testing.T) {
+	n, err := Parse(fixture("repeat.form"))
+	ok(t, err)
+
+	answers := n.FormAnswers(url.Values{
+		"name":                          {"Ann"},
+		"tax-return.dependants[0].name": {"Bo"},
+		"tax-return.dependants[2].born": {"2015-04-01"},
+		"tax-return.dependants[1].name": {""},
+		"tax-return.dependants[7].born": {"2001-01-01"},
+	})
+
+	rs, err := answers.Rows("tax-return.dependants")
+	ok(t, err)
+
+	// the blank row is dropped, and the rest keep their order
+	if len(rs) != 3 || rs[0]["name"] != "Bo" || rs[1]["born"] != "2015-04-01" || rs[2]["born"] != "2001-01-01" {
+		t.Fatalf("unexpected rows %v", rs)
+	}
+
+	errs := n.Validate(answers)
+
+	w := &bytes.Buffer{}
+	ok(t, n.HTML(w, &HTMLOptions{Answers: answers, Errors: errs}))
+
+	out := w.String()
+	for _, want := range []string{
+		`<fieldset class="field repeat" id="f-tax-return.dependants" data-min="1" data-max="4"`,
+		`<div class="row" data-row="3">`,
+		`name="tax-return.dependants[0].name" value="Bo"`,
+		`id="e-tax-return.dependants[1].name">required</span>`,
+		`name="tax-return.dependants[3].spouse-s-too"`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("missing %s", want)
+		}
+	}
+
+	// at the max, there's no blank row to add another in
+	if strings.Contains(out, `data-row="4"`) {
+		t.Errorf("expected no more than 4 rows")
+	}
+
+	if t.Failed() {
+		t.Logf("%s", out)
+	}
+}
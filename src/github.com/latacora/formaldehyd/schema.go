@@ -0,0 +1,305 @@
+package formaldehyd
+
+import (
+	"encoding/json"
+	"strconv"
+)
+
+// JSchema is a JSON Schema (draft 7) document, or the part of one that
+// describes a single answer. Schema fills in only what a form needs.
+type JSchema struct {
+	Schema               string              `json:"$schema,omitempty"`
+	Title                string              `json:"title,omitempty"`
+	Description          string              `json:"description,omitempty"`
+	Type                 string              `json:"type,omitempty"`
+	Properties           map[string]*JSchema `json:"properties,omitempty"`
+	Required             []string            `json:"required,omitempty"`
+	AdditionalProperties *bool               `json:"additionalProperties,omitempty"`
+	Items                *JSchema            `json:"items,omitempty"`
+	MinItems             *int                `json:"minItems,omitempty"`
+	MaxItems             *int                `json:"maxItems,omitempty"`
+	Enum                 []string            `json:"enum,omitempty"`
+	Const                interface{}         `json:"const,omitempty"`
+	Default              interface{}         `json:"default,omitempty"`
+	ReadOnly             bool                `json:"readOnly,omitempty"`
+	MinLength            *int                `json:"minLength,omitempty"`
+	MaxLength            *int                `json:"maxLength,omitempty"`
+	Pattern              string              `json:"pattern,omitempty"`
+	Format               string              `json:"format,omitempty"`
+	ContentEncoding      string              `json:"contentEncoding,omitempty"`
+	Minimum              *int                `json:"minimum,omitempty"`
+	Maximum              *int                `json:"maximum,omitempty"`
+	MultipleOf           *int                `json:"multipleOf,omitempty"`
+	Not                  *JSchema            `json:"not,omitempty"`
+	AllOf                []*JSchema          `json:"allOf,omitempty"`
+	If                   *JSchema            `json:"if,omitempty"`
+	Then                 *JSchema            `json:"then,omitempty"`
+}
+
+const jsonSchemaDraft = "http://json-schema.org/draft-07/schema#"
+
+// Schema describes a valid submission to the form as a JSON Schema:
+// an object with a property per field, keyed by ID. Text fields, dates,
+// email addresses, phone numbers and picks from a list of options are
+// strings, numbers are integers, checkboxes, switches and lone radio
+// buttons are booleans, file uploads are objects, and repeats are
+// arrays of objects, one per row. Required fields are required; if
+// they're inside a ~condition~ block, they're only required when the
+// condition holds. A repeat is required if it needs at least one row.
+//
+// Validate is a little more forgiving than the schema: it takes
+// numbers written as strings, and an empty string as no answer at all.
+func (n *Node) Schema() *JSchema {
+	no := false
+
+	s := &JSchema{
+		Schema:               jsonSchemaDraft,
+		Type:                 "object",
+		Properties:           map[string]*JSchema{},
+		AdditionalProperties: &no,
+	}
+
+	ids := n.index()
+	conditional := map[string]*JSchema{}
+	order := []string{}
+
+	for _, f := range n.Fields() {
+		s.Properties[f.ID] = fieldSchema(f, ids)
+
+		if f.Attrs["required"] != "t" && (f.Kind != NRepeat || atoi(f.Attrs["min"]) < 1) {
+			continue
+		}
+
+		conds := []*JSchema{}
+		for k := f; k != nil; k = k.Parent {
+			if k.Cond != nil {
+				conds = append([]*JSchema{condSchema(k.Cond, ids[k.Cond.Field])}, conds...)
+			}
+		}
+
+		if len(conds) == 0 {
+			s.Required = append(s.Required, f.ID)
+			continue
+		}
+
+		// fields under the same conditions share an if/then
+		when := &JSchema{AllOf: conds}
+		if len(conds) == 1 {
+			when = conds[0]
+		}
+
+		buf, _ := json.Marshal(when)
+		key := string(buf)
+		if _, ok := conditional[key]; !ok {
+			conditional[key] = &JSchema{If: when, Then: &JSchema{}}
+			order = append(order, key)
+		}
+
+		conditional[key].Then.Required = append(conditional[key].Then.Required, f.ID)
+	}
+
+	for _, key := range order {
+		s.AllOf = append(s.AllOf, conditional[key])
+	}
+
+	return s
+}
+
+// phonePattern matches the phone numbers check takes.
+const phonePattern = `^\+?[ ().-]*(?:[0-9][ ().-]*){7,15}$`
+
+func intp(s string) *int {
+	if s == "" {
+		return nil
+	}
+
+	ret, err := strconv.Atoi(s)
+	if err != nil {
+		return nil
+	}
+
+	return &ret
+}
+
+// fieldSchema describes an answer to one field; ids is the index of
+// the whole form, for working out what a computed field comes to.
+func fieldSchema(f *Node, ids map[string]*Node) *JSchema {
+	s := &JSchema{Title: f.Attrs["label"], Description: plain(f.Attrs["help"])}
+	required := f.Attrs["required"] == "t"
+
+	switch f.Kind {
+	case NCheckField, NRadioField, NSwitchField:
+		s.Type = "boolean"
+
+		// a required checkbox has to be checked
+		if required {
+			s.Const = true
+		}
+
+		if f.Attrs["checked"] != "" || f.Attrs["selected"] != "" || f.Attrs["on"] != "" {
+			s.Default = true
+		}
+
+	case NDropField, NChoiceField:
+		s.Type = "string"
+		s.Enum = options(f)
+
+		for _, opt := range f.Children {
+			if opt.Attrs["selected"] != "" {
+				s.Default = opt.Text
+			}
+		}
+
+	case NNumberField:
+		s.Type = "integer"
+		s.Minimum = intp(f.Attrs["min"])
+		s.Maximum = intp(f.Attrs["max"])
+
+		// steps count from the minimum, which multipleOf can only
+		// say if the minimum is itself a multiple of the step
+		if step := intp(f.Attrs["step"]); step != nil && *step != 0 {
+			if s.Minimum == nil || *s.Minimum%*step == 0 {
+				s.MultipleOf = step
+			}
+		}
+
+		if d := intp(f.Attrs["default"]); d != nil {
+			s.Default = *d
+		}
+
+	case NComputedField:
+		// worked out by Compute, which overwrites whatever was sent
+		s.ReadOnly = true
+		s.Type = map[string]string{
+			typeNumber:  "number",
+			typeBoolean: "boolean",
+			typeText:    "string",
+		}[valueType(f, ids, map[*Node]bool{})]
+
+	case NRepeat:
+		no := false
+		row := &JSchema{
+			Type:                 "object",
+			Properties:           map[string]*JSchema{},
+			AdditionalProperties: &no,
+		}
+
+		for _, k := range f.Fields() {
+			key := f.rowKey(k)
+			row.Properties[key] = fieldSchema(k, ids)
+
+			// a field that's only sometimes shown is only sometimes
+			// required, which a row can't say
+			shown := true
+			for p := k; p != f; p = p.Parent {
+				shown = shown && p.Cond == nil
+			}
+
+			if k.Attrs["required"] == "t" && shown {
+				row.Required = append(row.Required, key)
+			}
+		}
+
+		s.Type = "array"
+		s.Items = row
+		s.MaxItems = intp(f.Attrs["max"])
+		if min := intp(f.Attrs["min"]); min != nil && *min > 0 {
+			s.MinItems = min
+		}
+
+	case NDateField:
+		s.Type = "string"
+		s.Format = "date"
+
+	case NEmailField:
+		s.Type = "string"
+		s.Format = "email"
+
+	case NPhoneField:
+		// what check takes: 7 to 15 digits, maybe with a + in front,
+		// and spaces, dashes, dots and parentheses anywhere
+		s.Type = "string"
+		s.Pattern = phonePattern
+
+	case NFileField:
+		one := 1
+		s.Type = "object"
+		s.Properties = map[string]*JSchema{
+			"name": {Type: "string", MinLength: &one},
+			"type": {Type: "string"},
+			"data": {Type: "string", ContentEncoding: "base64"},
+		}
+		s.Required = []string{"name"}
+
+	case NTextField:
+		s.Type = "string"
+		s.MinLength = intp(f.Attrs["minlen"])
+		s.MaxLength = intp(f.Attrs["maxlen"])
+
+		if limit := atoi(f.Attrs["width"]) * f.rows(); limit > 0 {
+			if s.MaxLength == nil || limit < *s.MaxLength {
+				s.MaxLength = &limit
+			}
+		}
+
+		// an empty answer is no answer
+		if required && (s.MinLength == nil || *s.MinLength < 1) {
+			one := 1
+			s.MinLength = &one
+		}
+
+		if pat := f.Attrs["pattern"]; pat != "" {
+			s.Pattern = "^(?:" + pat + ")$"
+		}
+
+		if d := f.Attrs["default"]; d != "" {
+			s.Default = d
+		}
+	}
+
+	return s
+}
+
+// condSchema is a schema that matches submissions where c holds; f is
+// the field c is about.
+func condSchema(c *Condition, f *Node) *JSchema {
+	set := &JSchema{}
+
+	switch {
+	case c.Op == CondEq || c.Op == CondNe:
+		set.Const = c.Value
+		if f != nil && f.Kind == NNumberField {
+			if v := intp(c.Value); v != nil {
+				set.Const = *v
+			}
+		}
+
+	case f != nil && f.Kind == NNumberField:
+		set.Not = &JSchema{Const: 0}
+
+	case f != nil && f.Kind == NFileField:
+		set.Type = "object"
+
+	case f != nil && f.Kind == NRepeat:
+		one := 1
+		set.MinItems = &one
+
+	case f != nil && f.Kind != NCheckField && f.Kind != NRadioField && f.Kind != NSwitchField:
+		one := 1
+		set.MinLength = &one
+
+	default:
+		set.Const = true
+	}
+
+	s := &JSchema{
+		Properties: map[string]*JSchema{c.Field: set},
+		Required:   []string{c.Field},
+	}
+
+	if c.Op == CondUnset || c.Op == CondNe {
+		return &JSchema{Not: s}
+	}
+
+	return s
+}
@@ -0,0 +1,107 @@
+package formaldehyd
+
+import (
+	"encoding/json"
+	"regexp"
+	"testing"
+)
+
+func TestSchema(t *testing.T) {
+	n, err := Parse([]byte(`
+Name    [          ] {required, maxlen 20}
+Zip     [      ] {pattern /[0-9]{5}/}
+Age     [   +/-] {min 18, max 99}
+Agree   [ ] {required}
+
+Color *-------
+      * red
+      * green
+      --------
+
+~ color is green ~~~~~~~~~~~~~~~~~~~~~~~~~~
+
+Shade [          ] {required}
+
+~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+`))
+	ok(t, err)
+
+	buf, err := json.Marshal(n.Schema())
+	ok(t, err)
+
+	var got, expect interface{}
+	ok(t, json.Unmarshal(buf, &got))
+	ok(t, json.Unmarshal([]byte(`{
+  "$schema": "http://json-schema.org/draft-07/schema#",
+  "type": "object",
+  "additionalProperties": false,
+  "properties": {
+    "name":  {"title": "Name", "type": "string", "minLength": 1, "maxLength": 10},
+    "zip":   {"title": "Zip", "type": "string", "maxLength": 6, "pattern": "^(?:[0-9]{5})$"},
+    "age":   {"title": "Age", "type": "integer", "minimum": 18, "maximum": 99},
+    "agree": {"title": "Agree", "type": "boolean", "const": true},
+    "color": {"title": "Color", "type": "string", "enum": ["red", "green"]},
+    "shade": {"title": "Shade", "type": "string", "minLength": 1, "maxLength": 10}
+  },
+  "required": ["name", "agree"],
+  "allOf": [
+    {
+      "if": {"properties": {"color": {"const": "green"}}, "required": ["color"]},
+      "then": {"required": ["shade"]}
+    }
+  ]
+}`), &expect))
+
+	if !jsonEqual(got, expect) {
+		pretty, _ := json.MarshalIndent(got, "", "  ")
+		t.Fatalf("unexpected schema:\n%s", pretty)
+	}
+}
+
+func jsonEqual(a, b interface{}) bool {
+	x, _ := json.Marshal(a)
+	y, _ := json.Marshal(b)
+	return string(x) == string(y)
+}
+
+func TestSchemaKinds(t *testing.T) {
+	n, err := Parse(fixture("kinds.form"))
+	ok(t, err)
+
+	buf, err := json.Marshal(n.Schema().Properties)
+	ok(t, err)
+
+	var got, expect interface{}
+	ok(t, json.Unmarshal(buf, &got))
+	ok(t, json.Unmarshal([]byte(`{
+  "born":   {"title": "Born", "type": "string", "format": "date"},
+  "email":  {"title": "Email", "type": "string", "format": "email"},
+  "phone":  {"title": "Phone", "type": "string", "pattern": "^\\+?[ ().-]*(?:[0-9][ ().-]*){7,15}$"},
+  "resume": {
+    "title": "Resume",
+    "type": "object",
+    "properties": {
+      "name": {"type": "string", "minLength": 1},
+      "type": {"type": "string"},
+      "data": {"type": "string", "contentEncoding": "base64"}
+    },
+    "required": ["name"]
+  },
+  "cover-letter": {"title": "Cover letter", "type": "string", "maxLength": 240}
+}`), &expect))
+
+	if !jsonEqual(got, expect) {
+		pretty, _ := json.MarshalIndent(got, "", "  ")
+		t.Fatalf("unexpected schema:\n%s", pretty)
+	}
+
+	// the pattern takes just the phone numbers Validate does
+	re := regexp.MustCompile(phonePattern)
+	phone := &Node{Kind: NPhoneField}
+
+	for _, s := range []string{"+1 (555) 010-0000", "555.0100", "12345", "1234567890123456", "555-0100 x2", "1+5550100", ""} {
+		if re.MatchString(s) != (phone.check(s) == "") {
+			t.Errorf("%q: the schema and Validate disagree", s)
+		}
+	}
+}
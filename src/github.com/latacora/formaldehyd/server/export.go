@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+
+	"github.com/go-zoo/bone"
+	"github.com/latacora/formaldehyd"
+	"github.com/latacora/formaldehyd/store"
+)
+
+// submissions gathers every submission to every version of a form,
+// oldest first, with their answers migrated to the current version.
+// Answers that don't survive the migration are left out.
+func (a *app) submissions(f *form) ([]*store.Submission, error) {
+	recs, err := a.Store.Versions(f.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	ret := []*store.Submission{}
+
+	// a form changed back to an earlier version has that version's
+	// hash again, and its submissions are only exported once
+	seen := map[string]bool{}
+
+	for _, rec := range recs {
+		if seen[rec.Hash] {
+			continue
+		}
+		seen[rec.Hash] = true
+
+		subs, err := a.Store.Export(f.Name, rec.Hash)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(subs) == 0 {
+			continue
+		}
+
+		if rec.Hash != f.Record.Hash {
+			old, err := parseRecord(rec)
+			if err != nil {
+				log.Printf("can't parse version %d of %s; leaving its submissions out: %s", rec.Version, f.Name, err)
+				continue
+			}
+
+			for _, sub := range subs {
+				sub.Answers, _ = formaldehyd.Migrate(old, f.Root, sub.Answers)
+				sub.FormHash = f.Record.Hash
+			}
+		}
+
+		ret = append(ret, subs...)
+	}
+
+	sort.SliceStable(ret, func(i, j int) bool { return ret[i].Created.Before(ret[j].Created) })
+	return ret, nil
+}
+
+// handleExport downloads every submission to a form, as CSV or JSON
+// Lines.
+func handleExport(w http.ResponseWriter, r *http.Request) {
+	a, f := lookup(w, r)
+	if f == nil {
+		return
+	}
+
+	subs, err := a.submissions(f)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("can't load submissions: %s", err), http.StatusInternalServerError)
+		return
+	}
+
+	attach := func(ext, mime string) {
+		w.Header().Set("Content-Type", mime)
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s.%s\"", f.Name, ext))
+	}
+
+	switch bone.GetValue(r, "format") {
+	case "csv":
+		attach("csv", "text/csv; charset=utf-8")
+		err = store.WriteCSV(w, f.Root, subs)
+
+	case "jsonl":
+		attach("jsonl", "application/x-ndjson")
+		err = store.WriteJSONL(w, subs)
+
+	default:
+		http.Error(w, "export as csv or jsonl", http.StatusNotFound)
+		return
+	}
+
+	if err != nil {
+		log.Printf("can't export %s: %s", f.Name, err)
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExportReverted(t *testing.T) {
+	a := "Name [          ]\n"
+	b := "Name [          ]\nAge [  +/-]\n"
+
+	app, dir := testApp(t, map[string]string{"apply.form": a})
+	path := filepath.Join(dir, "apply.form")
+
+	edit := func(src string, when time.Time) {
+		t.Helper()
+
+		if err := ioutil.WriteFile(path, []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, when, when); err != nil {
+			t.Fatal(err)
+		}
+		if err := app.Forms.scan(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	submit := func(body string) {
+		t.Helper()
+
+		if w := do(app, "POST", "/form/apply", "application/json", []byte(body)); w.Code != http.StatusOK {
+			t.Fatalf("expected the submission to go through, got %d: %s", w.Code, w.Body)
+		}
+	}
+
+	// version 3 is version 1 again, with the same hash
+	now := time.Now()
+	submit(`{"name": "ann"}`)
+	edit(b, now.Add(time.Minute))
+	submit(`{"name": "bob", "age": 30}`)
+	edit(a, now.Add(2*time.Minute))
+	submit(`{"name": "cat"}`)
+
+	w := do(app, "GET", "/form/apply/export/jsonl", "", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected an export, got %d: %s", w.Code, w.Body)
+	}
+
+	lines := strings.Split(strings.TrimSpace(w.Body.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("expected each submission once, got\n%s", w.Body)
+	}
+
+	for i, name := range []string{"ann", "bob", "cat"} {
+		if !strings.Contains(lines[i], `"`+name+`"`) {
+			t.Errorf("expected %s in line %d, got %s", name, i+1, lines[i])
+		}
+	}
+}
+
+func TestExportSameSource(t *testing.T) {
+	src := "Name [          ]\n"
+	app, _ := testApp(t, map[string]string{"apply.form": src, "other.form": src})
+
+	if w := do(app, "POST", "/form/apply", "application/json", []byte(`{"name": "ann"}`)); w.Code != http.StatusOK {
+		t.Fatalf("expected the submission to go through, got %d: %s", w.Code, w.Body)
+	}
+
+	// the other form has the same hash, but none of the submissions
+	w := do(app, "GET", "/form/other/export/jsonl", "", nil)
+	if w.Code != http.StatusOK || strings.TrimSpace(w.Body.String()) != "" {
+		t.Fatalf("expected nothing to export, got %d: %s", w.Code, w.Body)
+	}
+}
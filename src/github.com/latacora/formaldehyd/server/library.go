@@ -0,0 +1,212 @@
+package main
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/latacora/formaldehyd"
+	"github.com/latacora/formaldehyd/store"
+)
+
+// formExt marks the files in the form directory we serve; jsonExt
+// marks forms saved as JSON, by a form builder say.
+const (
+	formExt = ".form"
+	jsonExt = ".json"
+)
+
+// formName is the name a file in the form directory is served under,
+// if it's a form at all.
+func formName(file string) (string, bool) {
+	for _, ext := range []string{formExt, jsonExt} {
+		if strings.HasSuffix(file, ext) {
+			return strings.TrimSuffix(file, ext), true
+		}
+	}
+
+	return "", false
+}
+
+// A form is one form file, along with the last version of it that
+// parsed. When an edit breaks the file we keep serving Root and hang
+// on to the error so authors can see what went wrong.
+type form struct {
+	Name   string
+	Path   string
+	Root   *formaldehyd.Node
+	Record *store.Form
+	Err    error
+
+	modTime time.Time
+	size    int64
+}
+
+// A library is every form in a directory, kept up to date as the
+// files change.
+type library struct {
+	dir      string
+	store    store.Store
+	lock     sync.RWMutex
+	forms    map[string]*form
+	catalogs map[string]map[string]*catalogFile // by form, then locale
+}
+
+func newLibrary(dir string, st store.Store) *library {
+	return &library{
+		dir:      dir,
+		store:    st,
+		forms:    map[string]*form{},
+		catalogs: map[string]map[string]*catalogFile{},
+	}
+}
+
+// load (re)parses a form file into f, leaving the last good version
+// in place if it doesn't parse.
+func (l *library) load(f *form) {
+	buf, err := ioutil.ReadFile(f.Path)
+	if err != nil {
+		f.Err = err
+		return
+	}
+
+	parse := formaldehyd.Parse
+	if strings.HasSuffix(f.Path, jsonExt) {
+		parse = formaldehyd.FromJSON
+	}
+
+	root, err := parse(buf)
+	if err != nil {
+		log.Printf("can't parse %s: %s", f.Path, err)
+		f.Err = err
+		return
+	}
+
+	rec, err := l.store.SaveForm(f.Name, root.Fingerprint(), buf)
+	if err != nil {
+		log.Printf("can't store %s: %s", f.Path, err)
+		f.Err = err
+		return
+	}
+
+	if f.Root != nil {
+		log.Printf("reloaded %s", f.Path)
+	}
+
+	f.Root = root
+	f.Record = rec
+	f.Err = nil
+}
+
+// parseRecord parses a stored version of a form. The store doesn't
+// keep file names, but JSON forms are easy to spot.
+func parseRecord(rec *store.Form) (*formaldehyd.Node, error) {
+	src := strings.TrimSpace(rec.Source)
+	if strings.HasPrefix(src, "{") {
+		return formaldehyd.FromJSON([]byte(src))
+	}
+
+	return formaldehyd.Parse([]byte(rec.Source))
+}
+
+// scan picks up new, changed and deleted form files, and their
+// translations (see scanLocales).
+func (l *library) scan() error {
+	infos, err := ioutil.ReadDir(l.dir)
+	if err != nil {
+		return err
+	}
+
+	present := map[string]bool{}
+
+	for _, info := range infos {
+		name, isForm := formName(info.Name())
+		if info.IsDir() || !isForm {
+			continue
+		}
+
+		if present[name] {
+			log.Printf("ignoring %s; there's already a form called %s", info.Name(), name)
+			continue
+		}
+
+		present[name] = true
+
+		l.lock.RLock()
+		f, ok := l.forms[name]
+		l.lock.RUnlock()
+
+		if ok && f.modTime.Equal(info.ModTime()) && f.size == info.Size() {
+			continue
+		}
+
+		// parse into a copy so readers never see a half-loaded form
+		n := &form{
+			Name: name,
+			Path: filepath.Join(l.dir, info.Name()),
+		}
+
+		if ok {
+			*n = *f
+		}
+
+		n.modTime = info.ModTime()
+		n.size = info.Size()
+		l.load(n)
+
+		l.lock.Lock()
+		l.forms[name] = n
+		l.lock.Unlock()
+	}
+
+	l.lock.Lock()
+	for name := range l.forms {
+		if !present[name] {
+			log.Printf("%s went away", name)
+			delete(l.forms, name)
+		}
+	}
+	l.lock.Unlock()
+
+	return l.scanLocales()
+}
+
+// watch rescans the directory every interval, forever.
+func (l *library) watch(interval time.Duration) {
+	for range time.Tick(interval) {
+		if err := l.scan(); err != nil {
+			log.Printf("can't scan %s: %s", l.dir, err)
+		}
+	}
+}
+
+// get returns the named form, or nil; the form returned is never
+// modified, so it's safe to use without holding the lock.
+func (l *library) get(name string) *form {
+	l.lock.RLock()
+	defer l.lock.RUnlock()
+
+	return l.forms[name]
+}
+
+// all returns every form, sorted by name.
+func (l *library) all() (ret []*form) {
+	l.lock.RLock()
+	for _, f := range l.forms {
+		ret = append(ret, f)
+	}
+	l.lock.RUnlock()
+
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Name < ret[j].Name })
+	return ret
+}
+
+func isDir(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.IsDir()
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/latacora/formaldehyd"
+)
+
+// Translations of the forms live under the form directory, a directory
+// per locale and a catalog per form:
+//
+//	forms/apply.form
+//	forms/locales/fr/apply.json
+//	forms/locales/pt-BR/apply.json
+//
+// Start a catalog with "formaldehyd messages apply.form". Forms are
+// shown in the locale asked for with ?lang=, or the best match for
+// Accept-Language, or as they're written if there's no catalog for it.
+const localesDir = "locales"
+
+// A catalogFile is one catalog, and the file it came from.
+type catalogFile struct {
+	catalog formaldehyd.Catalog
+	modTime time.Time
+	size    int64
+}
+
+// scanLocales picks up new, changed and deleted catalogs. A catalog
+// that can't be read is left out until it's fixed. A locale directory
+// that can't be listed keeps the catalogs it had, and so does
+// everything if the locales directory itself can't be.
+func (l *library) scanLocales() error {
+	dir := filepath.Join(l.dir, localesDir)
+
+	found := map[string]map[string]*catalogFile{}
+	add := func(name, locale string, c *catalogFile) {
+		if found[name] == nil {
+			found[name] = map[string]*catalogFile{}
+		}
+		found[name][locale] = c
+	}
+
+	var locales []os.FileInfo
+	if isDir(dir) {
+		var err error
+		if locales, err = ioutil.ReadDir(dir); err != nil {
+			return err
+		}
+	}
+
+	l.lock.RLock()
+	old := l.catalogs
+	l.lock.RUnlock()
+
+	var failed error
+
+	for _, locale := range locales {
+		if !locale.IsDir() {
+			continue
+		}
+
+		infos, err := ioutil.ReadDir(filepath.Join(dir, locale.Name()))
+		if err != nil {
+			for name, cs := range old {
+				if c := cs[locale.Name()]; c != nil {
+					add(name, locale.Name(), c)
+				}
+			}
+
+			if failed == nil {
+				failed = err
+			}
+			continue
+		}
+
+		for _, info := range infos {
+			if info.IsDir() || !strings.HasSuffix(info.Name(), jsonExt) {
+				continue
+			}
+
+			name := strings.TrimSuffix(info.Name(), jsonExt)
+
+			if c := old[name][locale.Name()]; c != nil && c.modTime.Equal(info.ModTime()) && c.size == info.Size() {
+				add(name, locale.Name(), c)
+				continue
+			}
+
+			path := filepath.Join(dir, locale.Name(), info.Name())
+
+			buf, err := ioutil.ReadFile(path)
+			if err != nil {
+				log.Printf("can't read %s: %s", path, err)
+				continue
+			}
+
+			c := &catalogFile{modTime: info.ModTime(), size: info.Size()}
+			if err := json.Unmarshal(buf, &c.catalog); err != nil {
+				log.Printf("can't load %s: %s", path, err)
+				continue
+			}
+
+			log.Printf("loaded %s", path)
+			add(name, locale.Name(), c)
+		}
+	}
+
+	l.lock.Lock()
+	l.catalogs = found
+	l.lock.Unlock()
+
+	return failed
+}
+
+// locales lists the locales a form has been translated into.
+func (l *library) locales(name string) []string {
+	l.lock.RLock()
+	defer l.lock.RUnlock()
+
+	ret := []string{}
+	for locale := range l.catalogs[name] {
+		ret = append(ret, locale)
+	}
+
+	sort.Strings(ret)
+	return ret
+}
+
+// catalog returns a form's catalog for a locale, or nil.
+func (l *library) catalog(name, locale string) formaldehyd.Catalog {
+	l.lock.RLock()
+	defer l.lock.RUnlock()
+
+	if c := l.catalogs[name][locale]; c != nil {
+		return c.catalog
+	}
+
+	return nil
+}
+
+// localize picks the locale to show a form in for a request, and
+// returns the form translated into it along with the locale; "" if
+// it's shown as written.
+func (a *app) localize(w http.ResponseWriter, r *http.Request, f *form) (*formaldehyd.Node, string) {
+	w.Header().Add("Vary", "Accept-Language")
+
+	available := a.Forms.locales(f.Name)
+
+	locale := ""
+	if lang := r.URL.Query().Get("lang"); lang != "" {
+		locale = formaldehyd.MatchLocale(lang, available)
+	} else {
+		locale = formaldehyd.MatchLocale(r.Header.Get("Accept-Language"), available)
+	}
+
+	c := a.Forms.catalog(f.Name, locale)
+	if c == nil {
+		return f.Root, ""
+	}
+
+	w.Header().Set("Content-Language", locale)
+	return f.Root.Localize(c), locale
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestScanLocalesKeepsUnreadable(t *testing.T) {
+	if os.Geteuid() == 0 {
+		t.Skip("root can read anything")
+	}
+
+	a, dir := testApp(t, map[string]string{"apply.form": "Name [          ]\n"})
+
+	for _, locale := range []string{"fr", "de"} {
+		ldir := filepath.Join(dir, localesDir, locale)
+		if err := os.MkdirAll(ldir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(ldir, "apply.json"), []byte(`{"name": "`+locale+`"}`), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := a.Forms.scan(); err != nil {
+		t.Fatal(err)
+	}
+
+	fr := filepath.Join(dir, localesDir, "fr")
+	if err := os.Chmod(fr, 0); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chmod(fr, 0755)
+
+	if err := a.Forms.scan(); err == nil {
+		t.Errorf("expected an error listing %s", fr)
+	}
+
+	// the French catalog stays until the directory can be read again
+	for _, locale := range []string{"fr", "de"} {
+		if c := a.Forms.catalog("apply", locale); c["name"] != locale {
+			t.Errorf("expected the %s catalog kept, got %v", locale, c)
+		}
+	}
+}
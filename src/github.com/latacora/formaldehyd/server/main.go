@@ -1,14 +1,19 @@
 package main
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
-	"io/ioutil"
+	"html/template"
 	"log"
 	"net/http"
 	"os"
+	"strings"
+	"time"
 
 	"github.com/go-zoo/bone"
 	"github.com/latacora/formaldehyd"
+	"github.com/latacora/formaldehyd/store"
 	"github.com/latacora/shamework"
 )
 
@@ -22,10 +27,293 @@ var (
 	contextKeyApp = contextKey("app")
 )
 
-func handleFoo(w http.ResponseWriter, r *http.Request) {
+// lookup finds the form named in the URL, writing a 404 if there
+// isn't one, or a 503 if it has never parsed.
+func lookup(w http.ResponseWriter, r *http.Request) (*app, *form) {
 	a := r.Context().Value(contextKeyApp).(*app)
 
-	fmt.Fprintf(w, "%s\n", a.Root.JSON())
+	f := a.Forms.get(bone.GetValue(r, "name"))
+	if f == nil {
+		http.NotFound(w, r)
+		return a, nil
+	}
+
+	if f.Root == nil {
+		http.Error(w, fmt.Sprintf("form doesn't parse: %s", f.Err), http.StatusServiceUnavailable)
+		return a, nil
+	}
+
+	return a, f
+}
+
+func handleForm(w http.ResponseWriter, r *http.Request) {
+	a, f := lookup(w, r)
+	if f == nil {
+		return
+	}
+
+	root, _ := a.localize(w, r, f)
+
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprintf(w, "%s\n", root.JSON())
+}
+
+// handleSchema serves a JSON Schema for submissions to a form.
+func handleSchema(w http.ResponseWriter, r *http.Request) {
+	a, f := lookup(w, r)
+	if f == nil {
+		return
+	}
+
+	root, _ := a.localize(w, r, f)
+
+	s := root.Schema()
+	s.Title = f.Name
+
+	w.Header().Set("Content-Type", "application/schema+json")
+	buf, _ := json.MarshalIndent(s, "", "  ")
+	fmt.Fprintf(w, "%s\n", buf)
+}
+
+type formSummary struct {
+	Name    string                  `json:"name"`
+	Hash    string                  `json:"hash"`
+	Version int                     `json:"version"`
+	Locales []string                `json:"locales"`
+	Error   string                  `json:"error"`
+	Errors  formaldehyd.ParseErrors `json:"errors,omitempty"`
+}
+
+func handleForms(w http.ResponseWriter, r *http.Request) {
+	a := r.Context().Value(contextKeyApp).(*app)
+
+	ret := []*formSummary{}
+	for _, f := range a.Forms.all() {
+		s := &formSummary{
+			Name:    f.Name,
+			Locales: a.Forms.locales(f.Name),
+		}
+
+		if f.Record != nil {
+			s.Hash = f.Record.Hash
+			s.Version = f.Record.Version
+		}
+
+		if f.Err != nil {
+			s.Error = f.Err.Error()
+			if errs, ok := f.Err.(formaldehyd.ParseErrors); ok {
+				s.Errors = errs
+			}
+		}
+
+		ret = append(ret, s)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ret)
+}
+
+type submitResponse struct {
+	Ok     bool                      `json:"ok"`
+	ID     string                    `json:"id,omitempty"`
+	Errors []*formaldehyd.FieldError `json:"errors"`
+}
+
+func handleSubmit(w http.ResponseWriter, r *http.Request) {
+	a, f := lookup(w, r)
+	if f == nil {
+		return
+	}
+
+	answers := formaldehyd.Answers{}
+	if err := json.NewDecoder(r.Body).Decode(&answers); err != nil {
+		http.Error(w, fmt.Sprintf("can't decode submission: %s", err), badBody(err))
+		return
+	}
+
+	// whatever was sent for a computed field is replaced with what it
+	// works out to
+	answers = f.Root.Compute(answers)
+
+	res := &submitResponse{
+		Errors: f.Root.Validate(answers),
+	}
+	res.Ok = len(res.Errors) == 0
+
+	if res.Ok {
+		sub := &store.Submission{
+			FormName: f.Name,
+			FormHash: f.Record.Hash,
+			Answers:  f.Root.Prune(answers),
+		}
+
+		if err := a.Store.Save(sub); err != nil {
+			http.Error(w, fmt.Sprintf("can't save submission: %s", err), http.StatusInternalServerError)
+			return
+		}
+
+		res.ID = sub.ID
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if !res.Ok {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+	}
+
+	json.NewEncoder(w).Encode(res)
+}
+
+// maxUpload is how much of a multipart submission is kept in memory;
+// the rest of it goes to temporary files.
+const maxUpload = 32 << 20
+
+// maxBody is the most any request can send, uploads and all; file
+// answers come inline in JSON, so this is what bounds them.
+const maxBody = 64 << 20
+
+// badBody is the status for a request body that can't be decoded:
+// 413 if it was cut off at maxBody, 400 otherwise.
+func badBody(err error) int {
+	var tooBig *http.MaxBytesError
+	if errors.As(err, &tooBig) {
+		return http.StatusRequestEntityTooLarge
+	}
+
+	return http.StatusBadRequest
+}
+
+// sessionCookie holds the wizard session of someone filling out the
+// HTML rendering of a form; it's scoped to the form's path.
+const sessionCookie = "session"
+
+func (a *app) htmlSession(r *http.Request, f *form) *store.Session {
+	id := ""
+	if c, err := r.Cookie(sessionCookie); err == nil {
+		id = c.Value
+	}
+
+	return a.session(f, id)
+}
+
+// renderHTML shows the page of the form a session is on.
+func (a *app) renderHTML(w http.ResponseWriter, r *http.Request, f *form, s *store.Session, errs []*formaldehyd.FieldError) {
+	root, locale := a.localize(w, r, f)
+
+	// the action keeps ?lang=, if there is one
+	opts := &formaldehyd.HTMLOptions{
+		Title:     f.Name,
+		Action:    r.URL.RequestURI(),
+		Templates: a.Templates,
+		Answers:   s.Progress.Answers,
+		Errors:    errs,
+		Lang:      locale,
+	}
+
+	if pages := f.Root.Pages(); len(pages) > 1 {
+		opts.Page = f.Root.CurrentPage(&s.Progress).ID
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if len(errs) > 0 {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+	}
+
+	if err := root.HTML(w, opts); err != nil {
+		log.Printf("can't render %s: %s", f.Name, err)
+	}
+}
+
+func handleHTML(w http.ResponseWriter, r *http.Request) {
+	a, f := lookup(w, r)
+	if f == nil {
+		return
+	}
+
+	a.renderHTML(w, r, f, a.htmlSession(r, f), nil)
+}
+
+// handleHTMLSubmit takes a plain form post from the HTML rendering: a
+// press of one of the buttons on the page someone's on. If the page
+// checks out they're sent on to wherever the button goes; otherwise
+// they get the page back with the problems marked.
+func handleHTMLSubmit(w http.ResponseWriter, r *http.Request) {
+	a, f := lookup(w, r)
+	if f == nil {
+		return
+	}
+
+	// ParseMultipartForm drops ParseForm's error for a form that isn't
+	// multipart, so a plain one is parsed on its own
+	var err error
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/") {
+		err = r.ParseMultipartForm(maxUpload)
+	} else {
+		err = r.ParseForm()
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("can't decode submission: %s", err), badBody(err))
+		return
+	}
+
+	answers := f.Root.FormAnswers(r.PostForm)
+	if r.MultipartForm != nil {
+		if err := f.Root.FormFiles(answers, r.MultipartForm.File); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	s := a.htmlSession(r, f)
+
+	st, submission, err := a.press(f, s, r.PostForm.Get("_button"), answers)
+	if err != nil {
+		status := http.StatusBadRequest
+		if st != nil {
+			status = http.StatusInternalServerError
+		}
+
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	cookie := &http.Cookie{
+		Name:     sessionCookie,
+		Value:    s.ID,
+		Path:     r.URL.Path,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	}
+
+	if st.Done || st.Cancelled {
+		cookie.Value = ""
+		cookie.MaxAge = -1
+	}
+
+	http.SetCookie(w, cookie)
+
+	switch {
+	case st.Done:
+		root, locale := a.localize(w, r, f)
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		err = root.HTML(w, &formaldehyd.HTMLOptions{
+			Title:      f.Name,
+			Templates:  a.Templates,
+			Submission: submission,
+			Lang:       locale,
+		})
+		if err != nil {
+			log.Printf("can't render %s: %s", f.Name, err)
+		}
+
+	case len(st.Errors) > 0:
+		a.renderHTML(w, r, f, s, st.Errors)
+
+	default:
+		// a new page (or a fresh start); redirect so reloading
+		// doesn't post again
+		http.Redirect(w, r, r.URL.RequestURI(), http.StatusSeeOther)
+	}
 }
 
 func handleRoot(w http.ResponseWriter, rq *http.Request) {
@@ -34,43 +322,79 @@ func handleRoot(w http.ResponseWriter, rq *http.Request) {
 }
 
 type app struct {
-	Root *formaldehyd.Node
-	log  *shamework.RequestLogger
+	Forms     *library
+	Store     store.Store
+	Templates *template.Template
+	log       *shamework.RequestLogger
 }
 
 func (a *app) handler(rawHandler http.Handler) http.Handler {
 	var h http.Handler
 
-	h = shamework.Inject(rawHandler, contextKeyApp, a)
+	// no request gets to send more than maxBody; see badBody
+	h = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.Body = http.MaxBytesReader(w, r.Body, maxBody)
+		rawHandler.ServeHTTP(w, r)
+	})
+	h = shamework.Inject(h, contextKeyApp, a)
 	h = a.log.Middleware(h)
 
 	return h
 }
 
-func main() {
-	if len(os.Args) < 2 {
-		log.Fatalf("server <file>")
-	}
+// routes is every endpoint the server has.
+func (a *app) routes() http.Handler {
+	mux := bone.New()
 
-	buf, err := ioutil.ReadFile(os.Args[1])
-	if err != nil {
-		log.Fatalf("can't read %s: %s", os.Args[1], err)
+	mux.Get("/", a.handler(http.HandlerFunc(handleRoot)))
+	mux.Get("/forms", a.handler(http.HandlerFunc(handleForms)))
+	mux.Get("/form/:name", a.handler(http.HandlerFunc(handleForm)))
+	mux.Post("/form/:name", a.handler(http.HandlerFunc(handleSubmit)))
+	mux.Get("/form/:name/schema", a.handler(http.HandlerFunc(handleSchema)))
+	mux.Get("/form/:name/html", a.handler(http.HandlerFunc(handleHTML)))
+	mux.Post("/form/:name/html", a.handler(http.HandlerFunc(handleHTMLSubmit)))
+	mux.Post("/form/:name/session", a.handler(http.HandlerFunc(handleSessionStart)))
+	mux.Get("/form/:name/session/:session", a.handler(http.HandlerFunc(handleSessionGet)))
+	mux.Post("/form/:name/session/:session", a.handler(http.HandlerFunc(handleSessionStep)))
+	mux.Get("/form/:name/versions", a.handler(http.HandlerFunc(handleVersions)))
+	mux.Get("/form/:name/submission/:id", a.handler(http.HandlerFunc(handleSubmission)))
+	mux.Get("/form/:name/export/:format", a.handler(http.HandlerFunc(handleExport)))
+	mux.Get("/static/", http.StripPrefix("/static/", http.FileServer(http.Dir("static"))))
+
+	return mux
+}
+
+func main() {
+	if len(os.Args) < 2 || !isDir(os.Args[1]) {
+		log.Fatalf("server <directory of %s or %s files>", formExt, jsonExt)
 	}
 
 	a := &app{
 		log: shamework.NewRequestLogger(true, true, true, os.Stderr),
 	}
 
-	a.Root, err = formaldehyd.Parse(buf)
-	if err != nil {
-		log.Fatalf("can't parse %s: %s", os.Args[1], err)
+	if os.Getenv("POSTGRES_HOST") != "" {
+		a.Store = store.MustPostgresFromEnvironment()
+	} else {
+		log.Printf("no POSTGRES_HOST; keeping responses in memory")
+		a.Store = store.NewMemory()
 	}
 
-	mux := bone.New()
+	if glob := os.Getenv("TEMPLATES"); glob != "" {
+		t, err := formaldehyd.HTMLTemplates().ParseGlob(glob)
+		if err != nil {
+			log.Fatalf("can't load templates %s: %s", glob, err)
+		}
+		a.Templates = t
+	}
 
-	mux.Get("/", a.handler(http.HandlerFunc(handleRoot)))
-	mux.Get("/form/foo", a.handler(http.HandlerFunc(handleFoo)))
-	mux.Get("/static/", http.StripPrefix("/static/", http.FileServer(http.Dir("static"))))
+	a.Forms = newLibrary(os.Args[1], a.Store)
+	if err := a.Forms.scan(); err != nil {
+		log.Fatalf("can't read %s: %s", os.Args[1], err)
+	}
+
+	go a.Forms.watch(time.Second)
+	go a.expireSessions(time.Hour)
 
 	port := os.Getenv("PORT")
 	if port == "" {
@@ -82,7 +406,7 @@ func main() {
 	log.Fatal(
 		http.ListenAndServe(
 			fmt.Sprintf(":%s", port),
-			mux,
+			a.routes(),
 		))
 
 	panic("notreached")
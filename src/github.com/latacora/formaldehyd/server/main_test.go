@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/latacora/formaldehyd/store"
+)
+
+// testApp serves the forms in files (by file name) from a temporary
+// directory, keeping submissions in memory.
+func testApp(t *testing.T, files map[string]string) (*app, string) {
+	t.Helper()
+
+	dir, err := ioutil.TempDir("", "formaldehyd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	for name, src := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	a := &app{Store: store.NewMemory()}
+	a.Forms = newLibrary(dir, a.Store)
+	if err := a.Forms.scan(); err != nil {
+		t.Fatal(err)
+	}
+
+	return a, dir
+}
+
+// do makes a request of the app, returning the response.
+func do(a *app, method, path, contentType string, body []byte) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, path, bytes.NewReader(body))
+	if contentType != "" {
+		r.Header.Set("Content-Type", contentType)
+	}
+
+	w := httptest.NewRecorder()
+	a.routes().ServeHTTP(w, r)
+	return w
+}
+
+func TestTooBig(t *testing.T) {
+	a, _ := testApp(t, map[string]string{"apply.form": "Name [          ]\n"})
+
+	huge := `{"name": "` + strings.Repeat("a", maxBody) + `"}`
+
+	mp := &bytes.Buffer{}
+	mw := multipart.NewWriter(mp)
+	fw, _ := mw.CreateFormFile("cv", "cv.pdf")
+	fw.Write(bytes.Repeat([]byte("a"), maxBody))
+	mw.Close()
+
+	for _, c := range []struct {
+		path, contentType, body string
+	}{
+		{"/form/apply", "application/json", huge},
+		{"/form/apply/html", "application/x-www-form-urlencoded", "name=" + strings.Repeat("a", maxBody)},
+		{"/form/apply/html", mw.FormDataContentType(), mp.String()},
+	} {
+		if w := do(a, "POST", c.path, c.contentType, []byte(c.body)); w.Code != http.StatusRequestEntityTooLarge {
+			t.Errorf("%s: expected 413, got %d: %s", c.path, w.Code, w.Body)
+		}
+	}
+
+	if w := do(a, "POST", "/form/apply", "application/json", []byte(`{"name": "Ann"}`)); w.Code != http.StatusOK {
+		t.Errorf("expected a small submission to go through, got %d: %s", w.Code, w.Body)
+	}
+}
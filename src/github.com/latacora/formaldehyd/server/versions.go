@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/go-zoo/bone"
+	"github.com/latacora/formaldehyd"
+	"github.com/latacora/formaldehyd/store"
+)
+
+// Every edit to a form file that parses is stored as a new version of
+// the form. Submissions stay keyed to the version they answered, and
+// are migrated to the current version when they're read back;
+// sessions are migrated as soon as they're picked up again.
+
+// migrate brings the answers in a session started on an older version
+// of a form up to date. Answers that can't be carried over are dropped
+// (and logged); if the old version can't be parsed anymore the answers
+// are left for validation to sort out.
+func (a *app) migrate(f *form, rec *store.Form, s *store.Session) {
+	old, err := parseRecord(rec)
+	if err != nil {
+		log.Printf("can't parse version %d of %s to migrate session %s: %s", rec.Version, f.Name, s.ID, err)
+		return
+	}
+
+	answers, lost := formaldehyd.Migrate(old, f.Root, s.Progress.Answers)
+	for _, e := range lost {
+		log.Printf("session %s: dropping answer to %s: %s", s.ID, e.Field, e.Message)
+	}
+
+	s.Progress.Answers = f.Root.Compute(answers)
+	s.FormHash = f.Record.Hash
+}
+
+type versionSummary struct {
+	Version int                   `json:"version"`
+	Hash    string                `json:"hash"`
+	Created time.Time             `json:"created"`
+	Error   string                `json:"error,omitempty"`
+	Changes []*formaldehyd.Change `json:"changes"`
+}
+
+// handleVersions lists every version of a form, with what changed
+// since the one before it.
+func handleVersions(w http.ResponseWriter, r *http.Request) {
+	a, f := lookup(w, r)
+	if f == nil {
+		return
+	}
+
+	recs, err := a.Store.Versions(f.Name)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("can't load versions: %s", err), http.StatusInternalServerError)
+		return
+	}
+
+	ret := []*versionSummary{}
+	var prev *formaldehyd.Node
+
+	for _, rec := range recs {
+		v := &versionSummary{
+			Version: rec.Version,
+			Hash:    rec.Hash,
+			Created: rec.Created,
+			Changes: []*formaldehyd.Change{},
+		}
+
+		root, err := parseRecord(rec)
+		if err != nil {
+			v.Error = err.Error()
+		} else if prev != nil {
+			v.Changes = append(v.Changes, formaldehyd.Diff(prev, root)...)
+		}
+
+		if root != nil {
+			prev = root
+		}
+
+		ret = append(ret, v)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ret)
+}
+
+type submissionResponse struct {
+	*store.Submission
+	Version int                       `json:"version"`
+	Lost    []*formaldehyd.FieldError `json:"lost"`
+}
+
+// handleSubmission returns a submission with its answers migrated to
+// the current version of the form; the answers that couldn't be are
+// listed under "lost".
+func handleSubmission(w http.ResponseWriter, r *http.Request) {
+	a, f := lookup(w, r)
+	if f == nil {
+		return
+	}
+
+	sub, err := a.Store.Load(bone.GetValue(r, "id"))
+	if err == store.ErrNotFound {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("can't load submission: %s", err), http.StatusInternalServerError)
+		return
+	}
+
+	if sub.FormName != f.Name {
+		http.NotFound(w, r)
+		return
+	}
+
+	rec, err := a.Store.Form(sub.FormName, sub.FormHash)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	res := &submissionResponse{
+		Submission: sub,
+		Version:    rec.Version,
+		Lost:       []*formaldehyd.FieldError{},
+	}
+
+	if rec.Hash != f.Record.Hash {
+		old, err := parseRecord(rec)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("can't parse version %d: %s", rec.Version, err), http.StatusInternalServerError)
+			return
+		}
+
+		sub.Answers, res.Lost = formaldehyd.Migrate(old, f.Root, sub.Answers)
+		sub.FormHash = f.Record.Hash
+		res.Version = f.Record.Version
+
+		if res.Lost == nil {
+			res.Lost = []*formaldehyd.FieldError{}
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(res)
+}
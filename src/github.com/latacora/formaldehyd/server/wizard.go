@@ -0,0 +1,206 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/go-zoo/bone"
+	"github.com/latacora/formaldehyd"
+	"github.com/latacora/formaldehyd/store"
+)
+
+// Forms can be filled out a page at a time. The server keeps a session
+// for each person doing that, with the answers they've given so far,
+// and won't let them past a page until its answers check out. The
+// JSON API hands out session IDs; the HTML rendering keeps the ID in
+// a cookie.
+
+// sessionTTL is how long a session is kept after it was last used;
+// someone who comes back later starts over.
+const sessionTTL = 7 * 24 * time.Hour
+
+// expireSessions throws away sessions that have outlived sessionTTL,
+// every interval.
+func (a *app) expireSessions(interval time.Duration) {
+	for range time.Tick(interval) {
+		n, err := a.Store.ExpireSessions(time.Now().Add(-sessionTTL))
+		if err != nil {
+			log.Printf("can't expire sessions: %s", err)
+			continue
+		}
+
+		if n > 0 {
+			log.Printf("expired %d sessions", n)
+		}
+	}
+}
+
+// session loads the session with the given ID if it's for form f and
+// hasn't expired, and starts a new one otherwise. A session started on
+// an older version of the form has its answers migrated to the current
+// one. It doesn't save a new session; press does that.
+func (a *app) session(f *form, id string) *store.Session {
+	if id != "" {
+		s, err := a.Store.Session(id)
+		if err == nil && s.FormName == f.Name && time.Since(s.Updated) < sessionTTL {
+			if rec, err := a.Store.Form(s.FormName, s.FormHash); err == nil {
+				if rec.Hash != f.Record.Hash {
+					a.migrate(f, rec, s)
+				}
+				return s
+			}
+		}
+	}
+
+	return &store.Session{
+		FormName: f.Name,
+		FormHash: f.Record.Hash,
+		Progress: *f.Root.Start(),
+	}
+}
+
+// press carries out a button press in a session, and saves where that
+// leaves things: a submission if the form is done, nothing if it was
+// abandoned, and the session otherwise. A bad press is an error with
+// no Step; a Step with an error means saving failed.
+func (a *app) press(f *form, s *store.Session, button string, answers formaldehyd.Answers) (st *formaldehyd.Step, submission string, err error) {
+	st, err = f.Root.Press(&s.Progress, button, answers)
+	if err != nil {
+		return nil, "", err
+	}
+
+	// sessions follow the form as it's edited
+	s.FormHash = f.Record.Hash
+
+	switch {
+	case st.Done:
+		sub := &store.Submission{
+			FormName: f.Name,
+			FormHash: f.Record.Hash,
+			Answers:  f.Root.Prune(s.Progress.Answers),
+		}
+
+		if err = a.Store.Save(sub); err != nil {
+			return st, "", err
+		}
+
+		submission = sub.ID
+		err = a.deleteSession(s)
+
+	case st.Cancelled:
+		err = a.deleteSession(s)
+
+	default:
+		err = a.Store.SaveSession(s)
+	}
+
+	return st, submission, err
+}
+
+func (a *app) deleteSession(s *store.Session) error {
+	if s.ID == "" {
+		return nil
+	}
+
+	return a.Store.DeleteSession(s.ID)
+}
+
+type sessionRequest struct {
+	Button  string              `json:"button"`
+	Answers formaldehyd.Answers `json:"answers"`
+}
+
+type sessionResponse struct {
+	Session    string              `json:"session,omitempty"`
+	Page       string              `json:"page"`
+	Answers    formaldehyd.Answers `json:"answers"`
+	Step       *formaldehyd.Step   `json:"step,omitempty"`
+	Submission string              `json:"submission,omitempty"`
+}
+
+func writeSession(w http.ResponseWriter, s *store.Session, st *formaldehyd.Step, submission string) {
+	res := &sessionResponse{
+		Session:    s.ID,
+		Page:       s.Progress.Page,
+		Answers:    s.Progress.Answers,
+		Step:       st,
+		Submission: submission,
+	}
+
+	if st != nil && (st.Done || st.Cancelled) {
+		res.Session = ""
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if st != nil && len(st.Errors) > 0 {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+	}
+
+	json.NewEncoder(w).Encode(res)
+}
+
+func handleSessionStart(w http.ResponseWriter, r *http.Request) {
+	a, f := lookup(w, r)
+	if f == nil {
+		return
+	}
+
+	s := a.session(f, "")
+	if err := a.Store.SaveSession(s); err != nil {
+		http.Error(w, fmt.Sprintf("can't save session: %s", err), http.StatusInternalServerError)
+		return
+	}
+
+	writeSession(w, s, nil, "")
+}
+
+func handleSessionGet(w http.ResponseWriter, r *http.Request) {
+	a, f := lookup(w, r)
+	if f == nil {
+		return
+	}
+
+	s := a.session(f, bone.GetValue(r, "session"))
+	if s.ID == "" {
+		http.NotFound(w, r)
+		return
+	}
+
+	s.Progress.Page = f.Root.CurrentPage(&s.Progress).ID
+	writeSession(w, s, nil, "")
+}
+
+func handleSessionStep(w http.ResponseWriter, r *http.Request) {
+	a, f := lookup(w, r)
+	if f == nil {
+		return
+	}
+
+	s := a.session(f, bone.GetValue(r, "session"))
+	if s.ID == "" {
+		http.NotFound(w, r)
+		return
+	}
+
+	req := &sessionRequest{}
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		http.Error(w, fmt.Sprintf("can't decode step: %s", err), badBody(err))
+		return
+	}
+
+	st, submission, err := a.press(f, s, req.Button, req.Answers)
+	if err != nil {
+		status := http.StatusBadRequest
+		if st != nil {
+			status = http.StatusInternalServerError
+		}
+
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	writeSession(w, s, st, submission)
+}
@@ -0,0 +1,179 @@
+package store
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/latacora/formaldehyd"
+)
+
+// A column is one column of a CSV export: one field.
+type column struct {
+	header string
+	field  string
+}
+
+// columns lays out a CSV export of a form: one column per field, headed
+// by its label, in document order. A dropdown or a choice is a single
+// column holding the option picked, since only one can be. A group of
+// checkboxes (two or more, one right after another, with the text
+// before them asking the question) is the form's multiple choice, so
+// each box is headed by that question and its own label, which keeps
+// the group together in a spreadsheet. A repeat is a single column
+// too, holding its rows as a JSON array: how many rows there are is up
+// to each submission, so they can't be laid out as columns of their
+// own. Fields without a label, or with a header some other field has
+// too, are headed by their ID as well.
+func columns(root *formaldehyd.Node) (ret []*column) {
+	headings := map[*formaldehyd.Node]string{}
+	checkGroups(root, headings)
+
+	for _, f := range root.Fields() {
+		header := f.Attrs["label"]
+		if h, ok := headings[f]; ok {
+			header = h + ": " + header
+		}
+
+		ret = append(ret, &column{header: header, field: f.ID})
+	}
+
+	seen := map[string]int{}
+	for _, c := range ret {
+		seen[c.header]++
+	}
+
+	for _, c := range ret {
+		switch {
+		case c.header == "":
+			c.header = c.field
+		case seen[c.header] > 1:
+			c.header = fmt.Sprintf("%s (%s)", c.header, c.field)
+		}
+	}
+
+	return ret
+}
+
+// checkGroups finds the groups of checkboxes under n (see columns),
+// and notes the question each box in one answers in headings.
+func checkGroups(n *formaldehyd.Node, headings map[*formaldehyd.Node]string) {
+	kids := n.Children
+
+	for i := 0; i < len(kids); i++ {
+		kid := kids[i]
+		if !kid.IsField() {
+			checkGroups(kid, headings)
+			continue
+		}
+
+		j := i + 1
+		for kid.Kind == formaldehyd.NCheckField && j < len(kids) {
+			next, prev := kids[j], kids[j-1]
+			if next.Kind != formaldehyd.NCheckField || next.Opt != kid.Opt || next.Line-prev.Line > 1 {
+				break
+			}
+			j++
+		}
+
+		if j-i < 2 || i == 0 || kids[i-1].Kind != formaldehyd.NText || kids[i-1].Opt != kid.Opt {
+			continue
+		}
+
+		for _, box := range kids[i:j] {
+			headings[box] = kids[i-1].Text
+		}
+		i = j - 1
+	}
+}
+
+// cell is what an answer looks like in a spreadsheet: true and false
+// (so whether a box was checked) are 1 and 0. An upload is just its
+// file name; the file itself only comes out as JSON Lines. Anything
+// else, like a repeat's rows, is written as JSON.
+func (c *column) cell(answers formaldehyd.Answers) string {
+	v, ok := answers[c.field]
+	if !ok || v == nil {
+		return ""
+	}
+
+	switch t := v.(type) {
+	case string:
+		return inert(t)
+	case bool:
+		if t {
+			return "1"
+		}
+		return "0"
+	case float64:
+		return strconv.FormatFloat(t, 'f', -1, 64)
+	case map[string]interface{}:
+		if name, ok := t["name"].(string); ok {
+			return inert(name)
+		}
+	}
+
+	buf, _ := json.Marshal(v)
+	return string(buf)
+}
+
+// inert keeps a spreadsheet from running an answer as a formula when
+// the export is opened: anything starting with a character that could
+// start one gets a ' in front, which spreadsheets show as text.
+func inert(s string) string {
+	if s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+
+	return s
+}
+
+// WriteCSV writes submissions to a form as CSV, one row per submission,
+// starting with its ID and when it was made, then a column per field
+// of root (see columns). Answers to fields root doesn't have are left
+// out; migrate submissions to older versions of the form first.
+func WriteCSV(w io.Writer, root *formaldehyd.Node, subs []*Submission) error {
+	cols := columns(root)
+	cw := csv.NewWriter(w)
+
+	row := []string{"id", "created"}
+	for _, c := range cols {
+		row = append(row, c.header)
+	}
+
+	if err := cw.Write(row); err != nil {
+		return err
+	}
+
+	for _, sub := range subs {
+		row = []string{sub.ID, sub.Created.UTC().Format(time.RFC3339)}
+		for _, c := range cols {
+			row = append(row, c.cell(sub.Answers))
+		}
+
+		if err := cw.Write(row); err != nil {
+			return err
+		}
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
+
+// WriteJSONL writes submissions as JSON Lines: each submission, with
+// its answers, as a JSON object on a line of its own.
+func WriteJSONL(w io.Writer, subs []*Submission) error {
+	enc := json.NewEncoder(w)
+
+	for _, sub := range subs {
+		if err := enc.Encode(sub); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
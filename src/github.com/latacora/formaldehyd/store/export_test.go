@@ -0,0 +1,160 @@
+package store
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/latacora/formaldehyd"
+)
+
+const exportForm = `
+Name    [          ]
+Age     [   +/-]
+Smoker  [ ]
+
+Color *-------
+      * red
+      * green
+      --------
+
+Which pets?
+Cat     [ ]
+Dog     [ ]
+
+Pets
+---
+
+Name    [          ]
+`
+
+func exportSubmissions() []*Submission {
+	when := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	return []*Submission{
+		{
+			ID:      "one",
+			Created: when,
+			Answers: formaldehyd.Answers{
+				"name":      "Smith, Alice",
+				"age":       float64(30),
+				"smoker":    false,
+				"color":     "green",
+				"cat":       false,
+				"dog":       true,
+				"pets.name": "=HYPERLINK(\"http://example.com\")",
+			},
+		},
+		{
+			ID:      "two",
+			Created: when.Add(time.Hour),
+			Answers: formaldehyd.Answers{"smoker": true},
+		},
+	}
+}
+
+func TestWriteCSV(t *testing.T) {
+	root, err := formaldehyd.Parse([]byte(exportForm))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buf := &bytes.Buffer{}
+	if err := WriteCSV(buf, root, exportSubmissions()); err != nil {
+		t.Fatal(err)
+	}
+
+	expect := strings.Join([]string{
+		"id,created,Name (name),Age,Smoker,Color,Which pets?: Cat,Which pets?: Dog,Name (pets.name)",
+		`one,2020-01-02T03:04:05Z,"Smith, Alice",30,0,green,0,1,"'=HYPERLINK(""http://example.com"")"`,
+		"two,2020-01-02T04:04:05Z,,,1,,,,",
+		"",
+	}, "\n")
+
+	if buf.String() != expect {
+		t.Fatalf("expected\n%s\ngot\n%s", expect, buf.String())
+	}
+}
+
+func TestWriteCSVRepeat(t *testing.T) {
+	root, err := formaldehyd.Parse([]byte(`
+Name    [          ]
+
++ Kids +++++++++++
+Name    [          ]
+Age     [   +/-]
+++++++++++++++++++
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	subs := []*Submission{{
+		ID:      "one",
+		Created: time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC),
+		Answers: formaldehyd.Answers{
+			"name": "Alice",
+			"kids": []interface{}{
+				map[string]interface{}{"name": "Bo", "age": float64(4)},
+				map[string]interface{}{"name": "Cy"},
+			},
+		},
+	}}
+
+	buf := &bytes.Buffer{}
+	if err := WriteCSV(buf, root, subs); err != nil {
+		t.Fatal(err)
+	}
+
+	// the rows are one cell of JSON
+	expect := strings.Join([]string{
+		"id,created,Name,Kids",
+		`one,2020-01-02T03:04:05Z,Alice,"[{""age"":4,""name"":""Bo""},{""name"":""Cy""}]"`,
+		"",
+	}, "\n")
+
+	if buf.String() != expect {
+		t.Fatalf("expected\n%s\ngot\n%s", expect, buf.String())
+	}
+}
+
+func TestInert(t *testing.T) {
+	for in, want := range map[string]string{
+		"=1+1":     "'=1+1",
+		"+1":       "'+1",
+		"-1":       "'-1",
+		"@SUM(A1)": "'@SUM(A1)",
+		"\tx":      "'\tx",
+		"\rx":      "'\rx",
+		"Alice":    "Alice",
+		"a = b":    "a = b",
+		"":         "",
+	} {
+		if got := inert(in); got != want {
+			t.Errorf("%q: expected %q, got %q", in, want, got)
+		}
+	}
+}
+
+func TestWriteJSONL(t *testing.T) {
+	buf := &bytes.Buffer{}
+	if err := WriteJSONL(buf, exportSubmissions()); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected two lines, got %q", buf.String())
+	}
+
+	sub := &Submission{}
+	if err := json.Unmarshal([]byte(lines[0]), sub); err != nil {
+		t.Fatal(err)
+	}
+
+	if sub.ID != "one" || sub.Answers["name"] != "Smith, Alice" || sub.Answers["age"] != float64(30) {
+		t.Fatalf("unexpected first line: %+v", sub)
+	}
+}
@@ -0,0 +1,248 @@
+package store
+
+import (
+	"sync"
+	"time"
+
+	"github.com/latacora/formaldehyd"
+	"github.com/latacora/formaldehyd/my"
+)
+
+// Memory is a Store that keeps everything in process memory; it's what
+// the tests use, and what the server falls back to without Postgres.
+type Memory struct {
+	lock        sync.Mutex
+	versions    []*Form // every version of every form, in the order saved
+	submissions map[string]*Submission
+	order       []string
+	sessions    map[string]*Session
+}
+
+func NewMemory() *Memory {
+	return &Memory{
+		submissions: map[string]*Submission{},
+		sessions:    map[string]*Session{},
+	}
+}
+
+// form is the latest version of the named form with the given hash,
+// or nil.
+func (m *Memory) form(name, hash string) *Form {
+	for i := len(m.versions) - 1; i >= 0; i-- {
+		if f := m.versions[i]; f.Name == name && f.Hash == hash {
+			return f
+		}
+	}
+
+	return nil
+}
+
+func (m *Memory) SaveForm(name, hash string, source []byte) (*Form, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	var latest *Form
+	for _, f := range m.versions {
+		if f.Name == name {
+			latest = f
+		}
+	}
+
+	if latest != nil && latest.Hash == hash {
+		c := *latest
+		return &c, nil
+	}
+
+	f := &Form{
+		Hash:    hash,
+		Name:    name,
+		Version: 1,
+		Source:  string(source),
+		Created: time.Now(),
+	}
+
+	if latest != nil {
+		f.Version = latest.Version + 1
+	}
+
+	// the same form again keeps the source it was first saved with,
+	// under whatever name
+	for _, prev := range m.versions {
+		if prev.Hash == hash {
+			f.Source = prev.Source
+			break
+		}
+	}
+
+	m.versions = append(m.versions, f)
+	c := *f
+	return &c, nil
+}
+
+func (m *Memory) Form(name, hash string) (*Form, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	f := m.form(name, hash)
+	if f == nil {
+		return nil, ErrNotFound
+	}
+
+	c := *f
+	return &c, nil
+}
+
+func (m *Memory) Versions(name string) ([]*Form, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	ret := []*Form{}
+	for _, f := range m.versions {
+		if f.Name == name {
+			c := *f
+			ret = append(ret, &c)
+		}
+	}
+
+	return ret, nil
+}
+
+func copyAnswers(a formaldehyd.Answers) formaldehyd.Answers {
+	ret := formaldehyd.Answers{}
+	for k, v := range a {
+		ret[k] = v
+	}
+	return ret
+}
+
+func (m *Memory) Save(sub *Submission) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if m.form(sub.FormName, sub.FormHash) == nil {
+		return ErrNotFound
+	}
+
+	if sub.ID == "" {
+		sub.ID = my.UUID()
+	}
+
+	if _, ok := m.submissions[sub.ID]; ok {
+		return ErrExists
+	}
+
+	if sub.Created.IsZero() {
+		sub.Created = time.Now()
+	}
+
+	m.order = append(m.order, sub.ID)
+
+	c := *sub
+	c.Answers = copyAnswers(sub.Answers)
+	m.submissions[sub.ID] = &c
+	return nil
+}
+
+func (m *Memory) Load(id string) (*Submission, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	sub, ok := m.submissions[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	c := *sub
+	c.Answers = copyAnswers(sub.Answers)
+	return &c, nil
+}
+
+func (m *Memory) collect(name, hash string, answers bool) []*Submission {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	ret := []*Submission{}
+	for _, id := range m.order {
+		sub := m.submissions[id]
+		if sub.FormName != name || sub.FormHash != hash {
+			continue
+		}
+
+		c := *sub
+		c.Answers = nil
+		if answers {
+			c.Answers = copyAnswers(sub.Answers)
+		}
+
+		ret = append(ret, &c)
+	}
+
+	return ret
+}
+
+func (m *Memory) List(name, hash string) ([]*Submission, error) {
+	return m.collect(name, hash, false), nil
+}
+
+func (m *Memory) Export(name, hash string) ([]*Submission, error) {
+	return m.collect(name, hash, true), nil
+}
+
+func copySession(s *Session) *Session {
+	c := *s
+	c.Progress.History = append([]string(nil), s.Progress.History...)
+	c.Progress.Answers = copyAnswers(s.Progress.Answers)
+	return &c
+}
+
+func (m *Memory) SaveSession(s *Session) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if m.form(s.FormName, s.FormHash) == nil {
+		return ErrNotFound
+	}
+
+	if s.ID == "" {
+		s.ID = my.UUID()
+	}
+
+	s.Updated = time.Now()
+	m.sessions[s.ID] = copySession(s)
+	return nil
+}
+
+func (m *Memory) Session(id string) (*Session, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	s, ok := m.sessions[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	return copySession(s), nil
+}
+
+func (m *Memory) DeleteSession(id string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	delete(m.sessions, id)
+	return nil
+}
+
+func (m *Memory) ExpireSessions(before time.Time) (int, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	n := 0
+	for id, s := range m.sessions {
+		if s.Updated.Before(before) {
+			delete(m.sessions, id)
+			n++
+		}
+	}
+
+	return n, nil
+}
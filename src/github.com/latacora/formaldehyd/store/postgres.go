@@ -0,0 +1,416 @@
+package store
+
+import (
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+
+	"github.com/latacora/formaldehyd"
+	"github.com/latacora/formaldehyd/my"
+)
+
+// Schema creates the tables Postgres needs; it's safe to run more
+// than once.
+//
+// forms keeps each source once, by hash, along with the name it was
+// first saved under; form_versions says which names have had it.
+const Schema = `
+CREATE TABLE IF NOT EXISTS forms (
+	hash       TEXT PRIMARY KEY,
+	name       TEXT NOT NULL,
+	source     TEXT NOT NULL,
+	created_at TIMESTAMP WITH TIME ZONE NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS form_versions (
+	name       TEXT NOT NULL,
+	version    INTEGER NOT NULL,
+	hash       TEXT NOT NULL REFERENCES forms (hash),
+	created_at TIMESTAMP WITH TIME ZONE NOT NULL,
+	PRIMARY KEY (name, version)
+);
+
+CREATE INDEX IF NOT EXISTS form_versions_hash ON form_versions (name, hash, version);
+
+-- forms saved before there were versions get them in the order they
+-- were saved in
+INSERT INTO form_versions (name, version, hash, created_at)
+SELECT name, ROW_NUMBER() OVER (PARTITION BY name ORDER BY created_at, hash), hash, created_at
+FROM forms f
+WHERE NOT EXISTS (SELECT 1 FROM form_versions v WHERE v.name = f.name);
+
+CREATE TABLE IF NOT EXISTS submissions (
+	id         TEXT PRIMARY KEY,
+	form_name  TEXT NOT NULL,
+	form_hash  TEXT NOT NULL REFERENCES forms (hash),
+	created_at TIMESTAMP WITH TIME ZONE NOT NULL
+);
+
+-- and their submissions get the name the form was saved under
+ALTER TABLE submissions ADD COLUMN IF NOT EXISTS form_name TEXT;
+
+UPDATE submissions s SET form_name = f.name
+FROM forms f
+WHERE s.form_name IS NULL AND f.hash = s.form_hash;
+
+ALTER TABLE submissions ALTER COLUMN form_name SET NOT NULL;
+
+CREATE INDEX IF NOT EXISTS submissions_form ON submissions (form_name, form_hash, created_at);
+
+CREATE TABLE IF NOT EXISTS answers (
+	submission_id TEXT NOT NULL REFERENCES submissions (id) ON DELETE CASCADE,
+	field_id      TEXT NOT NULL,
+	value         JSONB NOT NULL,
+	PRIMARY KEY (submission_id, field_id)
+);
+
+CREATE TABLE IF NOT EXISTS sessions (
+	id         TEXT PRIMARY KEY,
+	form_name  TEXT NOT NULL,
+	form_hash  TEXT NOT NULL REFERENCES forms (hash),
+	progress   JSONB NOT NULL,
+	updated_at TIMESTAMP WITH TIME ZONE NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS sessions_updated_at ON sessions (updated_at);
+`
+
+// Postgres is a Store backed by the tables in Schema. Each answer is
+// its own row, JSON-encoded, keyed by field ID.
+type Postgres struct {
+	db *sqlx.DB
+}
+
+// NewPostgres wraps an existing connection, creating the schema if
+// it's not there yet.
+func NewPostgres(db *sqlx.DB) (*Postgres, error) {
+	if _, err := db.Exec(Schema); err != nil {
+		return nil, err
+	}
+
+	return &Postgres{db: db}, nil
+}
+
+// MustPostgresFromEnvironment connects using the POSTGRES_* variables
+// (see my.MustDbString), and dies if it can't.
+func MustPostgresFromEnvironment() *Postgres {
+	p, err := NewPostgres(my.MustDbFromEnvironment())
+	my.Failsafe(err)
+	return p
+}
+
+// formSelect picks out a Form from a version and its source.
+const formSelect = `
+SELECT f.hash, v.name, v.version, f.source, v.created_at
+FROM form_versions v JOIN forms f ON f.hash = v.hash`
+
+func (p *Postgres) SaveForm(name, hash string, source []byte) (f *Form, err error) {
+	tx, err := p.db.Beginx()
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+
+	// one save per name at a time, so that two can't both take the
+	// next version; the primary key on form_versions backs this up
+	if _, err = tx.Exec(`SELECT pg_advisory_xact_lock(hashtext($1))`, name); err != nil {
+		return nil, err
+	}
+
+	latest := &Form{}
+	err = tx.Get(latest, formSelect+` WHERE v.name = $1 ORDER BY v.version DESC LIMIT 1`, name)
+	switch {
+	case err == sql.ErrNoRows:
+		latest, err = nil, nil
+	case err != nil:
+		return nil, err
+	case latest.Hash == hash:
+		return latest, tx.Commit()
+	}
+
+	version := 1
+	if latest != nil {
+		version = latest.Version + 1
+	}
+
+	now := my.DbTime(time.Now())
+
+	// the same form again keeps the source it was first saved with
+	_, err = tx.Exec(`
+INSERT INTO forms (hash, name, source, created_at) VALUES ($1, $2, $3, $4)
+ON CONFLICT (hash) DO NOTHING`, hash, name, string(source), now)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.Exec(`INSERT INTO form_versions (name, version, hash, created_at) VALUES ($1, $2, $3, $4)`,
+		name, version, hash, now)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return p.Form(name, hash)
+}
+
+func (p *Postgres) Form(name, hash string) (*Form, error) {
+	f := &Form{}
+
+	err := p.db.Get(f, formSelect+` WHERE v.name = $1 AND v.hash = $2 ORDER BY v.version DESC LIMIT 1`, name, hash)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return f, nil
+}
+
+func (p *Postgres) Versions(name string) ([]*Form, error) {
+	forms := []*Form{}
+
+	err := p.db.Select(&forms, formSelect+` WHERE v.name = $1 ORDER BY v.version`, name)
+	if err != nil {
+		return nil, err
+	}
+
+	return forms, nil
+}
+
+// formExists returns ErrNotFound unless hash is a version of the named
+// form.
+func formExists(q sqlx.Queryer, name, hash string) error {
+	var found int
+
+	err := sqlx.Get(q, &found, `SELECT COUNT(*) FROM form_versions WHERE name = $1 AND hash = $2`, name, hash)
+	if err != nil {
+		return err
+	}
+	if found == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+func (p *Postgres) Save(sub *Submission) (err error) {
+	if sub.ID == "" {
+		sub.ID = my.UUID()
+	}
+
+	if sub.Created.IsZero() {
+		sub.Created = time.Now()
+	}
+
+	tx, err := p.db.Beginx()
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+
+	if err = formExists(tx, sub.FormName, sub.FormHash); err != nil {
+		return err
+	}
+
+	res, err := tx.Exec(`
+INSERT INTO submissions (id, form_name, form_hash, created_at) VALUES ($1, $2, $3, $4)
+ON CONFLICT (id) DO NOTHING`, sub.ID, sub.FormName, sub.FormHash, my.DbTime(sub.Created))
+	if err != nil {
+		return err
+	}
+
+	// an existing submission is left alone, as in Memory
+	var n int64
+	if n, err = res.RowsAffected(); err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrExists
+	}
+
+	for field, value := range sub.Answers {
+		var buf []byte
+
+		buf, err = json.Marshal(value)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(`INSERT INTO answers (submission_id, field_id, value) VALUES ($1, $2, $3)`,
+			sub.ID, field, string(buf))
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+type answerRow struct {
+	SubmissionID string `db:"submission_id"`
+	FieldID      string `db:"field_id"`
+	Value        string `db:"value"`
+}
+
+// fill loads the answers for a batch of submissions.
+func (p *Postgres) fill(subs []*Submission, query string, args ...interface{}) error {
+	byID := map[string]*Submission{}
+	for _, sub := range subs {
+		sub.Answers = formaldehyd.Answers{}
+		byID[sub.ID] = sub
+	}
+
+	rows := []answerRow{}
+	if err := p.db.Select(&rows, query, args...); err != nil {
+		return err
+	}
+
+	for _, row := range rows {
+		sub, ok := byID[row.SubmissionID]
+		if !ok {
+			continue
+		}
+
+		var v interface{}
+		if err := json.Unmarshal([]byte(row.Value), &v); err != nil {
+			return err
+		}
+
+		sub.Answers[row.FieldID] = v
+	}
+
+	return nil
+}
+
+func (p *Postgres) Load(id string) (*Submission, error) {
+	sub := &Submission{}
+
+	err := p.db.Get(sub, `SELECT id, form_name, form_hash, created_at FROM submissions WHERE id = $1`, id)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	err = p.fill([]*Submission{sub},
+		`SELECT submission_id, field_id, value::text AS value FROM answers WHERE submission_id = $1`, id)
+	if err != nil {
+		return nil, err
+	}
+
+	return sub, nil
+}
+
+func (p *Postgres) List(name, hash string) ([]*Submission, error) {
+	subs := []*Submission{}
+
+	err := p.db.Select(&subs, `
+SELECT id, form_name, form_hash, created_at FROM submissions
+WHERE form_name = $1 AND form_hash = $2
+ORDER BY created_at, id`, name, hash)
+	if err != nil {
+		return nil, err
+	}
+
+	return subs, nil
+}
+
+func (p *Postgres) Export(name, hash string) ([]*Submission, error) {
+	subs, err := p.List(name, hash)
+	if err != nil {
+		return nil, err
+	}
+
+	err = p.fill(subs, `
+SELECT a.submission_id, a.field_id, a.value::text AS value
+FROM answers a JOIN submissions s ON s.id = a.submission_id
+WHERE s.form_name = $1 AND s.form_hash = $2`, name, hash)
+	if err != nil {
+		return nil, err
+	}
+
+	return subs, nil
+}
+
+func (p *Postgres) SaveSession(s *Session) error {
+	if s.ID == "" {
+		s.ID = my.UUID()
+	}
+
+	s.Updated = time.Now()
+
+	buf, err := json.Marshal(&s.Progress)
+	if err != nil {
+		return err
+	}
+
+	if err = formExists(p.db, s.FormName, s.FormHash); err != nil {
+		return err
+	}
+
+	_, err = p.db.Exec(`
+INSERT INTO sessions (id, form_name, form_hash, progress, updated_at)
+VALUES ($1, $2, $3, $4, $5)
+ON CONFLICT (id) DO UPDATE
+SET form_name = EXCLUDED.form_name, form_hash = EXCLUDED.form_hash,
+	progress = EXCLUDED.progress, updated_at = EXCLUDED.updated_at`,
+		s.ID, s.FormName, s.FormHash, string(buf), my.DbTime(s.Updated))
+	return err
+}
+
+type sessionRow struct {
+	Session
+	Progress string `db:"progress"`
+}
+
+func (p *Postgres) Session(id string) (*Session, error) {
+	row := &sessionRow{}
+
+	err := p.db.Get(row, `
+SELECT id, form_name, form_hash, progress::text AS progress, updated_at FROM sessions WHERE id = $1`, id)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	s := &row.Session
+	if err := json.Unmarshal([]byte(row.Progress), &s.Progress); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+func (p *Postgres) DeleteSession(id string) error {
+	_, err := p.db.Exec(`DELETE FROM sessions WHERE id = $1`, id)
+	return err
+}
+
+func (p *Postgres) ExpireSessions(before time.Time) (int, error) {
+	res, err := p.db.Exec(`DELETE FROM sessions WHERE updated_at < $1`, my.DbTime(before))
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := res.RowsAffected()
+	return int(n), err
+}
@@ -0,0 +1,111 @@
+// Package store keeps the responses collected for formaldehyd forms.
+//
+// Forms are stored by name and a content hash of the form their source
+// parses to (see formaldehyd.Node.Fingerprint), so a response always
+// points at the form it answered, and an edit that only moves
+// whitespace around isn't a new version. Two forms with the same
+// source under different names are kept apart. There's a Postgres
+// implementation for real use and an in-memory one for tests.
+
+package store
+
+import (
+	"errors"
+	"time"
+
+	"github.com/latacora/formaldehyd"
+)
+
+// ErrNotFound is returned when a form, submission or session doesn't
+// exist.
+var ErrNotFound = errors.New("not found")
+
+// ErrExists is returned when a submission is saved under an ID that's
+// already taken; submissions are never overwritten.
+var ErrExists = errors.New("already exists")
+
+// A Form is a version of a form, with its source text, stored under
+// its name and hash. Each time a name's form changes it gets the next
+// Version, starting from 1; changing it back to an earlier form is a
+// new version too, with the earlier one's hash.
+type Form struct {
+	Hash    string    `db:"hash" json:"hash"`
+	Name    string    `db:"name" json:"name"`
+	Version int       `db:"version" json:"version"`
+	Source  string    `db:"source" json:"source"`
+	Created time.Time `db:"created_at" json:"created"`
+}
+
+// A Submission is one set of answers to a form, the version of the
+// named form with FormHash.
+type Submission struct {
+	ID       string              `db:"id" json:"id"`
+	FormName string              `db:"form_name" json:"name"`
+	FormHash string              `db:"form_hash" json:"form"`
+	Created  time.Time           `db:"created_at" json:"created"`
+	Answers  formaldehyd.Answers `db:"-" json:"answers"`
+}
+
+// A Session is someone's progress through a form they're filling out
+// a page at a time, kept between pages.
+type Session struct {
+	ID       string               `db:"id" json:"id"`
+	FormName string               `db:"form_name" json:"name"`
+	FormHash string               `db:"form_hash" json:"form"`
+	Updated  time.Time            `db:"updated_at" json:"updated"`
+	Progress formaldehyd.Progress `db:"-" json:"progress"`
+}
+
+// A Store saves forms and the submissions made against them.
+type Store interface {
+	// SaveForm stores the source of a form under its hash, as the
+	// next version of the named form, returning the latest version
+	// instead if it has the same hash.
+	SaveForm(name, hash string, source []byte) (*Form, error)
+
+	// Form looks up a version of the named form by hash; if more
+	// than one version has it, the latest.
+	Form(name, hash string) (*Form, error)
+
+	// Versions returns every version of the named form, oldest
+	// first.
+	Versions(name string) ([]*Form, error)
+
+	// Save stores a new submission, filling in its ID and creation
+	// time if they're unset. It returns ErrNotFound if its form
+	// isn't stored under that name, and ErrExists if its ID is
+	// taken.
+	Save(sub *Submission) error
+
+	// Load returns a single submission, with its answers.
+	Load(id string) (*Submission, error)
+
+	// List returns the submissions for a version of the named form,
+	// oldest first, without their answers.
+	List(name, hash string) ([]*Submission, error)
+
+	// Export returns every submission for a version of the named
+	// form, oldest first, with their answers.
+	Export(name, hash string) ([]*Submission, error)
+
+	// SaveSession creates or updates a session, filling in its ID if
+	// it's unset. Like Save, it returns ErrNotFound if its form isn't
+	// stored under that name.
+	SaveSession(s *Session) error
+
+	// Session looks up a session by ID.
+	Session(id string) (*Session, error)
+
+	// DeleteSession throws a session away, once its form is
+	// submitted or abandoned.
+	DeleteSession(id string) error
+
+	// ExpireSessions throws away every session that hasn't been
+	// saved since before, returning how many there were.
+	ExpireSessions(before time.Time) (int, error)
+}
+
+var (
+	_ Store = (*Memory)(nil)
+	_ Store = (*Postgres)(nil)
+)
@@ -0,0 +1,389 @@
+package store
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+
+	"github.com/latacora/formaldehyd"
+	"github.com/latacora/formaldehyd/my"
+)
+
+// connect connects to the Postgres configured (see my.MustDbString),
+// or returns nil if there isn't one.
+func connect(t *testing.T) *sqlx.DB {
+	if os.Getenv("POSTGRES_HOST") == "" {
+		return nil
+	}
+
+	db, err := sqlx.Connect("postgres", my.MustDbString())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return db
+}
+
+// stores are the Stores to run the shared tests against: Memory, and
+// Postgres too if there's one configured.
+func stores(t *testing.T) map[string]Store {
+	ret := map[string]Store{"memory": NewMemory()}
+
+	db := connect(t)
+	if db == nil {
+		return ret
+	}
+
+	p, err := NewPostgres(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ret["postgres"] = p
+	return ret
+}
+
+func TestStores(t *testing.T) {
+	for name, s := range stores(t) {
+		t.Run(name, func(t *testing.T) {
+			testSubmissions(t, s)
+			testSessions(t, s)
+			testVersions(t, s)
+		})
+	}
+}
+
+func testSubmissions(t *testing.T, s Store) {
+	// a database may have been used before, so the form is new each
+	// time
+	name, hash, src := my.UUID(), my.UUID(), []byte("Name [          ]\n")
+
+	f, err := s.SaveForm(name, hash, src)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	again, err := s.SaveForm(name, hash, src)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if again.Hash != f.Hash || f.Hash != hash || again.Version != 1 {
+		t.Fatalf("expected the same form back, got %+v and %+v", f, again)
+	}
+
+	if err := s.Save(&Submission{FormName: name, FormHash: "nope"}); err != ErrNotFound {
+		t.Fatalf("expected ErrNotFound for an unknown form, got %v", err)
+	}
+
+	if err := s.Save(&Submission{FormName: "nope", FormHash: f.Hash}); err != ErrNotFound {
+		t.Fatalf("expected ErrNotFound for a form under another name, got %v", err)
+	}
+
+	for _, who := range []string{"alice", "bob"} {
+		sub := &Submission{
+			FormName: name,
+			FormHash: f.Hash,
+			Answers:  formaldehyd.Answers{"name": who},
+		}
+
+		if err := s.Save(sub); err != nil {
+			t.Fatal(err)
+		}
+
+		got, err := s.Load(sub.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got.Answers["name"] != who || got.FormName != name {
+			t.Fatalf("expected %s's answers to %s, got %+v", who, name, got)
+		}
+	}
+
+	// the same form under another name keeps its submissions apart
+	other := my.UUID()
+	if _, err := s.SaveForm(other, hash, src); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.Save(&Submission{FormName: other, FormHash: hash}); err != nil {
+		t.Fatal(err)
+	}
+
+	if list, err := s.List(other, hash); err != nil || len(list) != 1 {
+		t.Fatalf("expected one submission to the other form, got %+v, %v", list, err)
+	}
+
+	list, err := s.List(name, f.Hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(list) != 2 || list[0].Answers != nil {
+		t.Fatalf("expected two submissions without answers, got %+v", list)
+	}
+
+	all, err := s.Export(name, f.Hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(all) != 2 || all[0].Answers["name"] != "alice" || all[1].Answers["name"] != "bob" {
+		t.Fatalf("expected both submissions in order, got %+v", all)
+	}
+
+	if _, err := s.Load("nope"); err != ErrNotFound {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+
+	// a submission can't be saved over another
+	dup := &Submission{ID: all[0].ID, FormName: name, FormHash: f.Hash, Answers: formaldehyd.Answers{"name": "mallory"}}
+	if err := s.Save(dup); err != ErrExists {
+		t.Fatalf("expected ErrExists, got %v", err)
+	}
+
+	got, err := s.Load(all[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got.Answers["name"] != "alice" {
+		t.Fatalf("expected the first submission untouched, got %v", got.Answers)
+	}
+}
+
+func testSessions(t *testing.T, s Store) {
+	f, err := s.SaveForm(my.UUID(), my.UUID(), []byte("Name [          ]\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.SaveSession(&Session{FormName: "nope", FormHash: f.Hash}); err != ErrNotFound {
+		t.Fatalf("expected ErrNotFound for a form under another name, got %v", err)
+	}
+
+	sess := &Session{
+		FormName: f.Name,
+		FormHash: f.Hash,
+		Progress: formaldehyd.Progress{
+			Page:    "one",
+			Answers: formaldehyd.Answers{"name": "alice"},
+		},
+	}
+
+	if err := s.SaveSession(sess); err != nil {
+		t.Fatal(err)
+	}
+
+	// changing our copy doesn't change the stored one
+	sess.Progress.Answers["name"] = "bob"
+
+	got, err := s.Session(sess.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got.Progress.Page != "one" || got.Progress.Answers["name"] != "alice" {
+		t.Fatalf("unexpected session: %+v", got)
+	}
+
+	if err := s.DeleteSession(sess.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.Session(sess.ID); err != ErrNotFound {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+
+	// only sessions left alone too long expire
+	old, fresh := &Session{FormName: f.Name, FormHash: f.Hash}, &Session{FormName: f.Name, FormHash: f.Hash}
+	if err := s.SaveSession(old); err != nil {
+		t.Fatal(err)
+	}
+
+	cutoff := time.Now()
+	time.Sleep(10 * time.Millisecond)
+
+	if err := s.SaveSession(fresh); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.ExpireSessions(cutoff.Add(5 * time.Millisecond)); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.Session(old.ID); err != ErrNotFound {
+		t.Fatalf("expected the old session to expire, got %v", err)
+	}
+
+	if _, err := s.Session(fresh.ID); err != nil {
+		t.Fatalf("expected the fresh session to stay, got %v", err)
+	}
+}
+
+func testVersions(t *testing.T, s Store) {
+	name := my.UUID()
+
+	save := func(src string) *Form {
+		t.Helper()
+
+		n, err := formaldehyd.Parse([]byte(src))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		f, err := s.SaveForm(name, n.Fingerprint(), []byte(src))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return f
+	}
+
+	a := "Name [          ]\n"
+	b := "Name [          ]\nAge [  +/-]\n"
+
+	// moving whitespace around isn't a new version, but changing the
+	// form back to how it was is
+	for i, src := range []string{a, "\n\n" + a + "\n", b, a} {
+		if f, expect := save(src), []int{1, 1, 2, 3}[i]; f.Version != expect {
+			t.Fatalf("expected version %d, got %d", expect, f.Version)
+		}
+	}
+
+	// the same form under another name is a form of its own
+	nb, err := formaldehyd.Parse([]byte(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	other, err := s.SaveForm(my.UUID(), nb.Fingerprint(), []byte(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if other.Version != 1 {
+		t.Fatalf("expected another name to start over, got version %d", other.Version)
+	}
+
+	versions, err := s.Versions(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(versions) != 3 || versions[0].Hash != versions[2].Hash || versions[2].Version != 3 {
+		t.Fatalf("expected three versions in order, got %+v", versions)
+	}
+
+	if versions[0].Source != a {
+		t.Errorf("expected the first source to be kept, got %q", versions[0].Source)
+	}
+
+	// the hash the form went back to is the latest version's
+	f, err := s.Form(name, versions[0].Hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if f.Version != 3 || f.Name != name {
+		t.Fatalf("expected the latest version, got %+v", f)
+	}
+
+	if f, err := s.Form(other.Name, versions[0].Hash); err != ErrNotFound {
+		t.Fatalf("expected ErrNotFound under another name, got %+v, %v", f, err)
+	}
+}
+
+// schema003 is the schema the first release created, before forms had
+// versions.
+const schema003 = `
+CREATE TABLE forms (
+	hash       TEXT PRIMARY KEY,
+	name       TEXT NOT NULL,
+	source     TEXT NOT NULL,
+	created_at TIMESTAMP WITH TIME ZONE NOT NULL
+);
+
+CREATE TABLE submissions (
+	id         TEXT PRIMARY KEY,
+	form_hash  TEXT NOT NULL REFERENCES forms (hash),
+	created_at TIMESTAMP WITH TIME ZONE NOT NULL
+);
+
+CREATE INDEX submissions_form_hash ON submissions (form_hash, created_at);
+
+CREATE TABLE answers (
+	submission_id TEXT NOT NULL REFERENCES submissions (id) ON DELETE CASCADE,
+	field_id      TEXT NOT NULL,
+	value         JSONB NOT NULL,
+	PRIMARY KEY (submission_id, field_id)
+);
+`
+
+func TestPostgresUpgrade(t *testing.T) {
+	db := connect(t)
+	if db == nil {
+		t.Skip("no Postgres configured")
+	}
+	defer db.Close()
+
+	// a schema of its own, on a single connection so the search path
+	// sticks
+	db.SetMaxOpenConns(1)
+	schema := `"upgrade_` + strings.ToLower(my.UUID()) + `"`
+
+	exec := func(q string, args ...interface{}) {
+		t.Helper()
+		if _, err := db.Exec(q, args...); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	exec(`CREATE SCHEMA ` + schema)
+	defer db.Exec(`DROP SCHEMA ` + schema + ` CASCADE`)
+
+	exec(`SET search_path TO ` + schema)
+	exec(schema003)
+
+	then := time.Now().Add(-time.Hour)
+	exec(`INSERT INTO forms VALUES ('one', 'apply', $1, $2)`, "Name [    ]\n", my.DbTime(then))
+	exec(`INSERT INTO forms VALUES ('two', 'apply', $1, $2)`, "Name [    ]\nAge [  +/-]\n", my.DbTime(then.Add(time.Minute)))
+	exec(`INSERT INTO submissions VALUES ('sub', 'one', $1)`, my.DbTime(then))
+	exec(`INSERT INTO answers VALUES ('sub', 'name', '"alice"')`)
+
+	p, err := NewPostgres(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// and again, as every start does
+	if p, err = NewPostgres(db); err != nil {
+		t.Fatal(err)
+	}
+
+	versions, err := p.Versions("apply")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(versions) != 2 || versions[0].Hash != "one" || versions[1].Hash != "two" || versions[1].Version != 2 {
+		t.Fatalf("expected the forms as versions in the order they were saved, got %+v", versions)
+	}
+
+	subs, err := p.Export("apply", "one")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(subs) != 1 || subs[0].FormName != "apply" || subs[0].Answers["name"] != "alice" {
+		t.Fatalf("expected the submission under the form's name, got %+v", subs)
+	}
+
+	if f, err := p.SaveForm("apply", "three", []byte("Email [    @]")); err != nil || f.Version != 3 {
+		t.Fatalf("expected the next version to be 3, got %+v, %v", f, err)
+	}
+}
@@ -0,0 +1,471 @@
+package formaldehyd
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/mail"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Answers are the values submitted for a form, keyed by field ID.
+// They're what you get from decoding a JSON submission: checkboxes,
+// switches and lone radio buttons are bools, numbers are float64s,
+// file uploads are objects (see File), and everything else (including
+// the option picked for a choice, and dates, which are written
+// 2006-01-02) is a string.
+type Answers map[string]interface{}
+
+// A File is the answer to a file upload field. In a JSON submission
+// it's an object with the file's name, its MIME type, and its contents
+// in base64.
+type File struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+	Data []byte `json:"data"`
+}
+
+// dateLayout is how dates are written, in forms and in answers.
+const dateLayout = "2006-01-02"
+
+// A FieldError describes one answer that didn't survive validation.
+type FieldError struct {
+	Field   string `json:"field"`
+	Label   string `json:"label"`
+	Line    int    `json:"line"`
+	Message string `json:"message"`
+}
+
+func (e *FieldError) Error() string {
+	return fmt.Sprintf("%s (line %d): %s", e.Field, e.Line, e.Message)
+}
+
+// IsField is true for nodes that collect an answer, or, for a
+// computed field, have one worked out for them. A repeat is a field
+// too; the fields in it are answered in its rows (see repeat.go), so
+// Fields doesn't look inside it.
+func (n *Node) IsField() bool {
+	switch n.Kind {
+	case NTextField, NRadioField, NCheckField, NDropField, NNumberField, NSwitchField, NChoiceField,
+		NDateField, NEmailField, NPhoneField, NFileField, NComputedField, NRepeat:
+		return true
+	}
+
+	return false
+}
+
+// Fields returns every field under n, in document order.
+func (n *Node) Fields() (ret []*Node) {
+	for _, kid := range n.Children {
+		if kid.IsField() {
+			ret = append(ret, kid)
+			continue
+		}
+
+		ret = append(ret, kid.Fields()...)
+	}
+
+	return ret
+}
+
+// Validate checks a submission against the fields declared in the
+// form, returning one FieldError per bad answer (and nil if the
+// submission is fine). Fields with no answer are skipped unless
+// they're required, and fields hidden by a condition are skipped
+// entirely; Prune drops their answers.
+func (n *Node) Validate(answers Answers) []*FieldError {
+	return n.validate(n.Fields(), answers, true)
+}
+
+// ValidatePage is Validate for just the fields on one page of the
+// form; answers to fields elsewhere are left alone.
+func (n *Node) ValidatePage(page *Node, answers Answers) []*FieldError {
+	return n.validate(page.Fields(), answers, false)
+}
+
+func (n *Node) validate(fields []*Node, answers Answers, all bool) (errs []*FieldError) {
+	seen := map[string]bool{}
+	vis := n.visibility(answers)
+
+	fail := func(f *Node, msg string) {
+		errs = append(errs, &FieldError{
+			Field:   f.ID,
+			Label:   f.Attrs["label"],
+			Line:    f.Line,
+			Message: msg,
+		})
+	}
+
+	for _, f := range fields {
+		key := f.ID
+		seen[key] = true
+
+		if !vis.visible(f) {
+			continue
+		}
+
+		if f.Kind == NRepeat {
+			errs = append(errs, validateRows(f, answers[key], vis)...)
+			continue
+		}
+
+		v, ok := answers[key]
+		if !ok || v == nil || v == "" {
+			if f.Attrs["required"] == "t" {
+				fail(f, "required")
+			}
+			continue
+		}
+
+		if msg := f.check(v); msg != "" {
+			fail(f, msg)
+		}
+	}
+
+	// in order, so the same submission always gets the same errors
+	extra := []string{}
+	for key := range answers {
+		if all && !seen[key] {
+			extra = append(extra, key)
+		}
+	}
+	sort.Strings(extra)
+
+	for _, key := range extra {
+		errs = append(errs, &FieldError{
+			Field:   key,
+			Message: "no such field",
+		})
+	}
+
+	return errs
+}
+
+// check returns a description of what's wrong with v as an answer
+// for field n, or "" if nothing is.
+func (n *Node) check(v interface{}) string {
+	rti := func(s string) int { ret, _ := strconv.Atoi(s); return ret }
+
+	switch n.Kind {
+	case NCheckField, NRadioField, NSwitchField:
+		b, ok := v.(bool)
+		if !ok {
+			return "expected true or false"
+		}
+
+		if !b && n.Attrs["required"] == "t" {
+			return "required"
+		}
+
+	case NDropField, NChoiceField:
+		s, ok := v.(string)
+		if !ok {
+			return "expected one of the listed options"
+		}
+
+		for _, opt := range n.Children {
+			if opt.Text == s {
+				return ""
+			}
+		}
+
+		return fmt.Sprintf("\"%s\" isn't one of the listed options", s)
+
+	case NNumberField:
+		num, ok := number(v)
+		if !ok {
+			return "expected a number"
+		}
+
+		if num != math.Trunc(num) {
+			return "expected a whole number"
+		}
+
+		if s := n.Attrs["min"]; s != "" && num < float64(rti(s)) {
+			return fmt.Sprintf("less than %s", s)
+		}
+
+		if s := n.Attrs["max"]; s != "" && num > float64(rti(s)) {
+			return fmt.Sprintf("more than %s", s)
+		}
+
+		if s := n.Attrs["step"]; s != "" {
+			base := float64(rti(n.Attrs["min"]))
+			if math.Mod(num-base, float64(rti(s))) != 0 {
+				return fmt.Sprintf("not a multiple of %s", s)
+			}
+		}
+
+	case NTextField:
+		s, ok := v.(string)
+		if !ok {
+			return "expected text"
+		}
+
+		count := utf8.RuneCountInString(s)
+
+		limit := rti(n.Attrs["width"]) * n.rows()
+		if limit > 0 && count > limit {
+			return fmt.Sprintf("longer than %d characters", limit)
+		}
+
+		if l := n.Attrs["minlen"]; l != "" && count < rti(l) {
+			return fmt.Sprintf("shorter than %s characters", l)
+		}
+
+		if l := n.Attrs["maxlen"]; l != "" && count > rti(l) {
+			return fmt.Sprintf("longer than %s characters", l)
+		}
+
+		if pat := n.Attrs["pattern"]; pat != "" && s != "" {
+			re, err := regexp.Compile("^(?:" + pat + ")$")
+			if err != nil || !re.MatchString(s) {
+				return fmt.Sprintf("doesn't match /%s/", pat)
+			}
+		}
+
+	case NDateField:
+		s, ok := v.(string)
+		if !ok {
+			return "expected a date"
+		}
+
+		if _, err := time.Parse(dateLayout, s); err != nil {
+			return "expected a date like 2006-01-02"
+		}
+
+		if min := n.Attrs["min"]; min != "" && s < min {
+			return fmt.Sprintf("before %s", min)
+		}
+
+		if max := n.Attrs["max"]; max != "" && s > max {
+			return fmt.Sprintf("after %s", max)
+		}
+
+	case NEmailField:
+		s, ok := v.(string)
+		if !ok {
+			return "expected an email address"
+		}
+
+		// just the address: no name, no <brackets>
+		addr, err := mail.ParseAddress(s)
+		if err != nil || addr.Address != s || !strings.Contains(s[strings.LastIndex(s, "@"):], ".") {
+			return fmt.Sprintf("\"%s\" isn't an email address", s)
+		}
+
+	case NPhoneField:
+		s, ok := v.(string)
+		if !ok {
+			return "expected a phone number"
+		}
+
+		digits := 0
+		for i, r := range s {
+			switch {
+			case r >= '0' && r <= '9':
+				digits++
+			case r == '+' && i == 0:
+			case strings.ContainsRune(" -.()", r):
+			default:
+				return fmt.Sprintf("\"%s\" isn't a phone number", s)
+			}
+		}
+
+		if digits < 7 || digits > 15 {
+			return fmt.Sprintf("\"%s\" isn't a phone number", s)
+		}
+
+	case NFileField:
+		f, ok := file(v)
+		if !ok {
+			return "expected a file"
+		}
+
+		if f.Name == "" {
+			return "expected a file name"
+		}
+
+		if max := n.Attrs["maxsize"]; max != "" && len(f.Data) > rti(max) {
+			return fmt.Sprintf("larger than %s bytes", max)
+		}
+
+		if accept := n.Attrs["accept"]; accept != "" && !accepts(accept, f) {
+			return fmt.Sprintf("%s isn't one of %s", f.Name, accept)
+		}
+	}
+
+	return ""
+}
+
+// rows is how many lines of text a text field takes: its height,
+// unless it says otherwise.
+func (n *Node) rows() int {
+	if r, err := strconv.Atoi(n.Attrs["rows"]); err == nil && r > 0 {
+		return r
+	}
+
+	ret, _ := strconv.Atoi(n.Attrs["height"])
+	return ret
+}
+
+// file decodes the answer to a file upload field.
+func file(v interface{}) (*File, bool) {
+	switch f := v.(type) {
+	case *File:
+		return f, true
+	case map[string]interface{}:
+		buf, err := json.Marshal(f)
+		if err != nil {
+			return nil, false
+		}
+
+		ret := &File{}
+		if err := json.Unmarshal(buf, ret); err != nil {
+			return nil, false
+		}
+
+		return ret, true
+	}
+
+	return nil, false
+}
+
+// accepts is whether f is one of the kinds of file in an accept
+// attribute: a list of extensions (".pdf") and MIME types ("image/png",
+// or "image/*" for any image).
+func accepts(accept string, f *File) bool {
+	for _, a := range strings.Fields(strings.ToLower(accept)) {
+		switch {
+		case strings.HasPrefix(a, "."):
+			if strings.HasSuffix(strings.ToLower(f.Name), a) {
+				return true
+			}
+		case strings.HasSuffix(a, "/*"):
+			if strings.HasPrefix(strings.ToLower(f.Type), a[:len(a)-1]) {
+				return true
+			}
+		case strings.ToLower(f.Type) == a:
+			return true
+		}
+	}
+
+	return false
+}
+
+// number coerces the ways a decoded JSON number can show up.
+func number(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case int:
+		return float64(n), true
+	case json.Number:
+		f, err := n.Float64()
+		return f, err == nil
+	case string:
+		f, err := strconv.ParseFloat(n, 64)
+		return f, err == nil
+	}
+
+	return 0, false
+}
+
+// String is the answer to a text, email or phone field, or the option
+// picked for a dropdown or choice; "" if there isn't one.
+func (a Answers) String(id string) (string, error) {
+	switch v := a[id].(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	}
+
+	return "", fmt.Errorf("%s: expected text", id)
+}
+
+// Int is the answer to a number field; 0 if there isn't one.
+func (a Answers) Int(id string) (int, error) {
+	v := a[id]
+	if v == nil || v == "" {
+		return 0, nil
+	}
+
+	num, ok := number(v)
+	if !ok || num != math.Trunc(num) {
+		return 0, fmt.Errorf("%s: expected a whole number", id)
+	}
+
+	return int(num), nil
+}
+
+// Float is the value of a computed field that works out to a number,
+// or the answer to a number field; 0 if there isn't one.
+func (a Answers) Float(id string) (float64, error) {
+	v := a[id]
+	if v == nil || v == "" {
+		return 0, nil
+	}
+
+	num, ok := number(v)
+	if !ok {
+		return 0, fmt.Errorf("%s: expected a number", id)
+	}
+
+	return num, nil
+}
+
+// Bool is the answer to a checkbox, switch or lone radio button;
+// false if there isn't one.
+func (a Answers) Bool(id string) (bool, error) {
+	switch v := a[id].(type) {
+	case nil:
+		return false, nil
+	case bool:
+		return v, nil
+	}
+
+	return false, fmt.Errorf("%s: expected true or false", id)
+}
+
+// Date is the answer to a date field; the zero time if there isn't
+// one.
+func (a Answers) Date(id string) (time.Time, error) {
+	switch v := a[id].(type) {
+	case nil:
+		return time.Time{}, nil
+	case string:
+		if v == "" {
+			return time.Time{}, nil
+		}
+
+		t, err := time.Parse(dateLayout, v)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("%s: expected a date like 2006-01-02", id)
+		}
+
+		return t, nil
+	}
+
+	return time.Time{}, fmt.Errorf("%s: expected a date", id)
+}
+
+// File is the answer to a file upload field; nil if there isn't one.
+func (a Answers) File(id string) (*File, error) {
+	v := a[id]
+	if v == nil {
+		return nil, nil
+	}
+
+	f, ok := file(v)
+	if !ok {
+		return nil, fmt.Errorf("%s: expected a file", id)
+	}
+
+	return f, nil
+}
@@ -0,0 +1,78 @@
+package formaldehyd
+
+import (
+	"strings"
+	"testing"
+)
+
+const validateForm = `
+Page 1
+------
+
+#name
+Name [          ]
+
+#subscribe
+Subscribe [ ]
+
+#age
+Age [  +/-]
+
+#color
+Color *-----
+      * red
+      * green
+      ------
+`
+
+func TestValidate(t *testing.T) {
+	n, err := Parse([]byte(validateForm))
+	ok(t, err)
+
+	errs := n.Validate(Answers{
+		"name":      "Jane",
+		"subscribe": true,
+		"age":       float64(30),
+		"color":     "green",
+	})
+	if len(errs) != 0 {
+		t.Fatalf("unexpected validation errors: %v", errs)
+	}
+
+	errs = n.Validate(Answers{
+		"name":      "this name is much too long for the field",
+		"subscribe": "yes",
+		"age":       2.5,
+		"color":     "blue",
+		"bogus":     "x",
+	})
+
+	bad := map[string]bool{}
+	for _, e := range errs {
+		bad[e.Field] = true
+	}
+
+	for _, want := range []string{"name", "subscribe", "age", "color", "bogus"} {
+		if !bad[want] {
+			t.Errorf("expected an error for %s, got %v", want, errs)
+		}
+	}
+}
+
+func TestValidateUnknownOrder(t *testing.T) {
+	n, err := Parse([]byte(validateForm))
+	ok(t, err)
+
+	for i := 0; i < 10; i++ {
+		errs := n.Validate(Answers{"zebra": 1, "apple": 2, "mango": 3, "kiwi": 4})
+
+		got := []string{}
+		for _, e := range errs {
+			got = append(got, e.Field)
+		}
+
+		if s := strings.Join(got, " "); s != "apple kiwi mango zebra" {
+			t.Fatalf("expected unknown fields in order, got %s", s)
+		}
+	}
+}
@@ -0,0 +1,434 @@
+package formaldehyd
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Forms get edited while people are filling them out, which leaves
+// answers keyed to fields that have moved, been renamed or gone away.
+// Fingerprint tells versions of a form apart, Diff says what changed
+// between two of them, and Migrate carries answers given to the old
+// version over to the new one.
+
+// Fingerprint is a content hash of the form a tree describes. It's
+// taken over the Format of the tree, so edits that only move
+// whitespace around don't change it.
+func (n *Node) Fingerprint() string {
+	sum := sha256.Sum256(Format(n))
+	return hex.EncodeToString(sum[:])
+}
+
+// The kinds of Change.
+const (
+	ChangeAdded      = "added"
+	ChangeRemoved    = "removed"
+	ChangeRelabelled = "relabelled"
+	ChangeRetyped    = "retyped"
+	ChangeOptions    = "options"
+)
+
+// A Change is one difference between the fields of two versions of a
+// form. Field is the field's ID in the new version (in the old one,
+// if it was removed), and Was is its old ID if that's different.
+// Added and Removed list the options that came and went from a
+// dropdown or choice.
+type Change struct {
+	Kind     string   `json:"kind"`
+	Field    string   `json:"field"`
+	Was      string   `json:"was,omitempty"`
+	Label    string   `json:"label,omitempty"`
+	OldLabel string   `json:"old_label,omitempty"`
+	Type     string   `json:"type,omitempty"`
+	OldType  string   `json:"old_type,omitempty"`
+	Added    []string `json:"added,omitempty"`
+	Removed  []string `json:"removed,omitempty"`
+}
+
+func (c *Change) String() string {
+	switch c.Kind {
+	case ChangeAdded, ChangeRemoved:
+		return fmt.Sprintf("%s %s", c.Kind, c.Field)
+
+	case ChangeRelabelled:
+		return fmt.Sprintf("relabelled %s (\"%s\") as %s (\"%s\")", c.Was, c.OldLabel, c.Field, c.Label)
+
+	case ChangeRetyped:
+		return fmt.Sprintf("changed %s from a %s to a %s", c.Field, c.OldType, c.Type)
+	}
+
+	parts := []string{}
+	for _, o := range c.Added {
+		parts = append(parts, "+"+o)
+	}
+	for _, o := range c.Removed {
+		parts = append(parts, "-"+o)
+	}
+
+	return fmt.Sprintf("options of %s: %s", c.Field, strings.Join(parts, " "))
+}
+
+// match pairs the fields of an old version of a form with the fields
+// they became in a new one. Fields with the same ID are the same
+// field. Of the rest, a field is taken to have been renamed if there's
+// a new field of the same kind in the same place: after the same
+// field that kept its ID. The fields of a repeat that's still a repeat
+// are matched the same way, by their keys in its rows. Old fields left
+// out of the map were removed.
+func match(old, new *Node) map[*Node]*Node {
+	pairs := map[*Node]*Node{}
+	id := func(f *Node) string { return f.ID }
+
+	matchFields(old.Fields(), new.Fields(), id, id, pairs)
+	return pairs
+}
+
+// matchFields pairs up oldFields and newFields in pairs, comparing
+// them by oldKey and newKey, and then the fields of each repeat.
+func matchFields(oldFields, newFields []*Node, oldKey, newKey func(*Node) string, pairs map[*Node]*Node) {
+	byKey := map[string]*Node{}
+	for _, f := range newFields {
+		byKey[newKey(f)] = f
+	}
+
+	paired := map[*Node]bool{}
+	for _, f := range oldFields {
+		if nf, ok := byKey[oldKey(f)]; ok {
+			pairs[f] = nf
+			paired[nf] = true
+		}
+	}
+
+	type slot struct {
+		after string
+		kind  int
+	}
+
+	pending := map[slot][]*Node{}
+	after := ""
+	for _, f := range newFields {
+		if paired[f] {
+			after = newKey(f)
+			continue
+		}
+
+		k := slot{after, f.Kind}
+		pending[k] = append(pending[k], f)
+	}
+
+	after = ""
+	for _, f := range oldFields {
+		if nf, ok := pairs[f]; ok {
+			after = newKey(nf)
+			continue
+		}
+
+		k := slot{after, f.Kind}
+		if cands := pending[k]; len(cands) > 0 {
+			pairs[f] = cands[0]
+			pending[k] = cands[1:]
+		}
+	}
+
+	for _, f := range oldFields {
+		if nf, ok := pairs[f]; ok && f.Kind == NRepeat && nf.Kind == NRepeat {
+			matchFields(f.Fields(), nf.Fields(), f.rowKey, nf.rowKey, pairs)
+		}
+	}
+}
+
+// allFields is every field under n, and every field of each repeat
+// after it.
+func allFields(n *Node) (ret []*Node) {
+	for _, f := range n.Fields() {
+		ret = append(ret, f)
+		if f.Kind == NRepeat {
+			ret = append(ret, f.Fields()...)
+		}
+	}
+
+	return ret
+}
+
+func options(n *Node) (ret []string) {
+	for _, kid := range n.Children {
+		if kid.Kind == NSelection {
+			ret = append(ret, kid.Text)
+		}
+	}
+
+	return ret
+}
+
+// Diff lists the fields added to, removed from and changed in a form
+// between an old version and a new one, in the order they appear in
+// the new version, followed by the ones that were removed. Text,
+// buttons and pages aren't compared; only the things that collect
+// answers are, the fields of repeats included.
+func Diff(old, new *Node) (changes []*Change) {
+	pairs := match(old, new)
+
+	from := map[*Node]*Node{}
+	for of, nf := range pairs {
+		from[nf] = of
+	}
+
+	for _, nf := range allFields(new) {
+		of, ok := from[nf]
+		if !ok {
+			changes = append(changes, &Change{
+				Kind:  ChangeAdded,
+				Field: nf.ID,
+				Label: nf.Attrs["label"],
+				Type:  nodeNames[nf.Kind],
+			})
+			continue
+		}
+
+		// a field in a repeat that was renamed has a new ID, but
+		// it's only renamed itself if its key in the rows changed
+		renamed := of.ID != nf.ID
+		if or, nr := of.inRepeat(), nf.inRepeat(); or != nil && nr != nil {
+			renamed = or.rowKey(of) != nr.rowKey(nf)
+		}
+
+		if renamed || of.Attrs["label"] != nf.Attrs["label"] {
+			changes = append(changes, &Change{
+				Kind:     ChangeRelabelled,
+				Field:    nf.ID,
+				Was:      of.ID,
+				Label:    nf.Attrs["label"],
+				OldLabel: of.Attrs["label"],
+			})
+		}
+
+		if of.Kind != nf.Kind {
+			changes = append(changes, &Change{
+				Kind:    ChangeRetyped,
+				Field:   nf.ID,
+				Type:    nodeNames[nf.Kind],
+				OldType: nodeNames[of.Kind],
+			})
+			continue
+		}
+
+		oldOpts, newOpts := map[string]bool{}, map[string]bool{}
+		for _, o := range options(of) {
+			oldOpts[o] = true
+		}
+		for _, o := range options(nf) {
+			newOpts[o] = true
+		}
+
+		c := &Change{Kind: ChangeOptions, Field: nf.ID}
+		for _, o := range options(nf) {
+			if !oldOpts[o] {
+				c.Added = append(c.Added, o)
+			}
+		}
+		for _, o := range options(of) {
+			if !newOpts[o] {
+				c.Removed = append(c.Removed, o)
+			}
+		}
+
+		if len(c.Added) > 0 || len(c.Removed) > 0 {
+			changes = append(changes, c)
+		}
+	}
+
+	for _, of := range allFields(old) {
+		if _, ok := pairs[of]; !ok {
+			changes = append(changes, &Change{
+				Kind:  ChangeRemoved,
+				Field: of.ID,
+				Label: of.Attrs["label"],
+				Type:  nodeNames[of.Kind],
+			})
+		}
+	}
+
+	return changes
+}
+
+// Migrate carries answers given to an old version of a form over to a
+// new one, following fields that were renamed, and converting answers
+// to fields that changed kind where that makes sense (a number can
+// become text, text that reads as a number can become a number). The
+// rows of a repeat are carried over a field at a time, the same way.
+// The answers that can't be carried over, because their field went
+// away, their option did, or they don't make sense for what the field
+// became, are left out and come back as FieldErrors against the old
+// version of the form; one in a row is named like kids[0].name.
+// Computed values aren't answers, so they're dropped without one,
+// whether the field was computed in the old version or is in the new;
+// Compute works them out again.
+//
+// Whether the answers that do carry over are still valid is a
+// question for Validate.
+func Migrate(old, new *Node, answers Answers) (Answers, []*FieldError) {
+	pairs := match(old, new)
+	oldIDs, newIDs := old.index(), new.index()
+
+	ret := Answers{}
+	var lost []*FieldError
+
+	flag := func(f *Node, id, msg string) {
+		e := &FieldError{Field: id, Message: msg}
+		if f != nil {
+			e.Label = f.Attrs["label"]
+			e.Line = f.Line
+		}
+		lost = append(lost, e)
+	}
+
+	// carry takes answer v to old field of, named id, over to the
+	// field it became, returning that and the answer; nil if it
+	// doesn't carry over
+	var carry func(of *Node, id string, v interface{}) (*Node, interface{})
+	carry = func(of *Node, id string, v interface{}) (*Node, interface{}) {
+		// the value of a computed field isn't an answer, and neither
+		// is whatever was sent for a field that's computed now; both
+		// are dropped, without a FieldError, since nothing was lost
+		// that Compute won't work out again
+		nf, ok := pairs[of]
+		if of.Kind == NComputedField || (ok && nf.Kind == NComputedField) {
+			return nil, nil
+		}
+
+		if !ok {
+			flag(of, id, "no longer in the form")
+			return nil, nil
+		}
+
+		nv, msg := convert(v, nf)
+		if msg != "" {
+			flag(of, id, msg)
+			return nil, nil
+		}
+
+		if rs, _ := rows(nv); of.Kind == NRepeat && nf.Kind == NRepeat && rs != nil {
+			keys := map[string]*Node{}
+			for _, f := range of.Fields() {
+				keys[of.rowKey(f)] = f
+			}
+
+			moved := []Answers{}
+			for i, row := range rs {
+				nrow := Answers{}
+
+				for key, v := range row {
+					oc, ok := keys[key]
+					if !ok {
+						flag(nil, rowName(of, i, key), "no such field")
+						continue
+					}
+
+					if nc, cv := carry(oc, rowName(of, i, key), v); nc != nil {
+						nrow[nf.rowKey(nc)] = cv
+					}
+				}
+
+				moved = append(moved, nrow)
+			}
+
+			nv = moved
+		}
+
+		return nf, nv
+	}
+
+	for id, v := range answers {
+		of, ok := oldIDs[id]
+		if !ok || !of.IsField() {
+			// an answer already keyed to the new version stays put
+			if nf, ok := newIDs[id]; ok && nf.IsField() {
+				of = nf
+				for _, f := range append([]*Node{nf}, nf.Fields()...) {
+					pairs[f] = f
+				}
+			} else {
+				flag(nil, id, "no such field")
+				continue
+			}
+		}
+
+		if nf, nv := carry(of, id, v); nf != nil {
+			ret[nf.ID] = nv
+		}
+	}
+
+	sort.Slice(lost, func(i, j int) bool { return lost[i].Field < lost[j].Field })
+	return ret, lost
+}
+
+// convert turns v into an answer for field n, or explains why it
+// can't.
+func convert(v interface{}, n *Node) (interface{}, string) {
+	if v == nil || v == "" {
+		return v, ""
+	}
+
+	switch n.Kind {
+	case NCheckField, NRadioField, NSwitchField:
+		if b, ok := v.(bool); ok {
+			return b, ""
+		}
+
+	case NNumberField:
+		if num, ok := number(v); ok {
+			return num, ""
+		}
+
+	case NTextField:
+		switch t := v.(type) {
+		case string:
+			return t, ""
+		case float64:
+			return strconv.FormatFloat(t, 'f', -1, 64), ""
+		}
+
+	case NEmailField, NPhoneField:
+		if s, ok := v.(string); ok {
+			return s, ""
+		}
+
+	case NDateField:
+		if s, ok := v.(string); ok {
+			if _, err := time.Parse(dateLayout, s); err == nil {
+				return s, ""
+			}
+		}
+
+	case NFileField:
+		if _, ok := file(v); ok {
+			return v, ""
+		}
+
+	case NRepeat:
+		if _, ok := rows(v); ok {
+			return v, ""
+		}
+
+	case NDropField, NChoiceField:
+		s, ok := v.(string)
+		if !ok {
+			break
+		}
+
+		for _, o := range options(n) {
+			if o == s {
+				return s, ""
+			}
+		}
+
+		return nil, fmt.Sprintf("\"%s\" is no longer an option", s)
+	}
+
+	return nil, fmt.Sprintf("can't be an answer for a %s", nodeNames[n.Kind])
+}
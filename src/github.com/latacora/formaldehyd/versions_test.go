@@ -0,0 +1,232 @@
+package formaldehyd
+
+import (
+	"reflect"
+	"testing"
+)
+
+const versionOne = `
+Name    [          ]
+Age     [   +/-]
+Email   [          ]
+Smoker  [ ]
+
+Color *-------
+      * red
+      * green
+      * blue
+      --------
+`
+
+const versionTwo = `
+Full name [          ]
+Age       [          ]
+Smoker    [ ]
+Phone     [          ]
+
+Color *--------
+      * red
+      * blue
+      * purple
+      ---------
+`
+
+func TestFingerprint(t *testing.T) {
+	a, err := Parse([]byte(versionOne))
+	ok(t, err)
+
+	b, err := Parse([]byte("\n\n" + string(Format(a))))
+	ok(t, err)
+
+	c, err := Parse([]byte(versionTwo))
+	ok(t, err)
+
+	if a.Fingerprint() != b.Fingerprint() {
+		t.Fatalf("reformatting changed the fingerprint")
+	}
+
+	if a.Fingerprint() == c.Fingerprint() {
+		t.Fatalf("different forms have the same fingerprint")
+	}
+}
+
+func TestDiff(t *testing.T) {
+	old, err := Parse([]byte(versionOne))
+	ok(t, err)
+
+	new, err := Parse([]byte(versionTwo))
+	ok(t, err)
+
+	got := []string{}
+	for _, c := range Diff(old, new) {
+		got = append(got, c.String())
+	}
+
+	expect := []string{
+		`relabelled name ("Name") as full-name ("Full name")`,
+		"changed age from a NumberField to a TextField",
+		"added phone",
+		"options of color: +purple -green",
+		"removed email",
+	}
+
+	if !reflect.DeepEqual(got, expect) {
+		t.Fatalf("expected\n%q\ngot\n%q", expect, got)
+	}
+
+	if changes := Diff(old, old); len(changes) != 0 {
+		t.Fatalf("expected no changes, got %v", changes)
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	old, err := Parse([]byte(versionOne))
+	ok(t, err)
+
+	new, err := Parse([]byte(versionTwo))
+	ok(t, err)
+
+	answers, lost := Migrate(old, new, Answers{
+		"name":   "alice",
+		"age":    float64(30),
+		"email":  "alice@example.com",
+		"smoker": false,
+		"color":  "green",
+		"bogus":  "x",
+	})
+
+	expect := Answers{
+		"full-name": "alice",
+		"age":       "30",
+		"smoker":    false,
+	}
+
+	if !reflect.DeepEqual(answers, expect) {
+		t.Fatalf("expected %v, got %v", expect, answers)
+	}
+
+	msgs := map[string]string{}
+	for _, e := range lost {
+		msgs[e.Field] = e.Message
+	}
+
+	if !reflect.DeepEqual(msgs, map[string]string{
+		"bogus": "no such field",
+		"color": `"green" is no longer an option`,
+		"email": "no longer in the form",
+	}) {
+		t.Fatalf("unexpected lost answers: %v", msgs)
+	}
+
+	// and back again: text that reads as a number goes back into the
+	// number field, and text that doesn't is lost
+	answers, lost = Migrate(new, old, Answers{"age": "31", "phone": "555-1212"})
+	if !reflect.DeepEqual(answers, Answers{"age": float64(31)}) || len(lost) != 1 || lost[0].Field != "phone" {
+		t.Fatalf("unexpected migration back: %v, %v", answers, lost)
+	}
+
+	answers, lost = Migrate(new, old, Answers{"age": "old"})
+	if len(answers) != 0 || len(lost) != 1 || lost[0].Message != "can't be an answer for a NumberField" {
+		t.Fatalf("expected a lost answer, got %v, %v", answers, lost)
+	}
+}
+
+func TestMigrateComputed(t *testing.T) {
+	old, err := Parse([]byte("#price\nPrice [   +/-]\n#total\nTotal [= price * 2 ]\n#tip\nTip [   +/-]\n"))
+	ok(t, err)
+
+	new, err := Parse([]byte("#price\nPrice [   +/-]\n#tip\nTip [= price / 10 ]\n"))
+	ok(t, err)
+
+	// the total went away and the tip is computed now, but neither
+	// was ever really an answer
+	answers, lost := Migrate(old, new, Answers{"price": float64(10), "total": float64(20), "tip": float64(3)})
+	if !reflect.DeepEqual(answers, Answers{"price": float64(10)}) || len(lost) != 0 {
+		t.Fatalf("expected the computed values dropped quietly, got %v, %v", answers, lost)
+	}
+}
+
+const repeatOne = `
++ Kids +++++++++++++
+Name [          ]
+Age  [   +/-]
+Pet  [ ]
+++++++++++++++++++++
+`
+
+const repeatTwo = `
++ Children +++++++++
+Full name [          ]
+Age       [          ]
+Email     [         @]
+++++++++++++++++++++
+`
+
+func TestDiffRepeat(t *testing.T) {
+	old, err := Parse([]byte(repeatOne))
+	ok(t, err)
+
+	new, err := Parse([]byte(repeatTwo))
+	ok(t, err)
+
+	got := []string{}
+	for _, c := range Diff(old, new) {
+		got = append(got, c.String())
+	}
+
+	expect := []string{
+		`relabelled kids ("Kids") as children ("Children")`,
+		`relabelled kids.name ("Name") as children.full-name ("Full name")`,
+		"changed children.age from a NumberField to a TextField",
+		"added children.email",
+		"removed kids.pet",
+	}
+
+	if !reflect.DeepEqual(got, expect) {
+		t.Fatalf("expected\n%q\ngot\n%q", expect, got)
+	}
+}
+
+func TestMigrateRepeat(t *testing.T) {
+	old, err := Parse([]byte(repeatOne))
+	ok(t, err)
+
+	new, err := Parse([]byte(repeatTwo))
+	ok(t, err)
+
+	answers, lost := Migrate(old, new, Answers{
+		"kids": []interface{}{
+			map[string]interface{}{"name": "Ann", "age": float64(7), "pet": true},
+			map[string]interface{}{"name": "Bob", "bogus": "x"},
+		},
+	})
+
+	expect := Answers{
+		"children": []Answers{
+			{"full-name": "Ann", "age": "7"},
+			{"full-name": "Bob"},
+		},
+	}
+
+	if !reflect.DeepEqual(answers, expect) {
+		t.Fatalf("expected %v, got %v", expect, answers)
+	}
+
+	msgs := map[string]string{}
+	for _, e := range lost {
+		msgs[e.Field] = e.Message
+	}
+
+	if !reflect.DeepEqual(msgs, map[string]string{
+		"kids[0].pet":   "no longer in the form",
+		"kids[1].bogus": "no such field",
+	}) {
+		t.Fatalf("unexpected lost answers: %v", msgs)
+	}
+
+	// rows already keyed to the new version stay as they are
+	answers, lost = Migrate(old, new, Answers{"children": []interface{}{map[string]interface{}{"email": "a@b.c"}}})
+	if !reflect.DeepEqual(answers, Answers{"children": []Answers{{"email": "a@b.c"}}}) || len(lost) != 0 {
+		t.Fatalf("expected the rows kept, got %v, %v", answers, lost)
+	}
+}
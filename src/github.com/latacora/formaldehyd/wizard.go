@@ -0,0 +1,269 @@
+package formaldehyd
+
+import (
+	"fmt"
+	"strings"
+)
+
+// A form with pages is filled out a page at a time, and its buttons
+// say how to get from one page to another:
+//
+//	[( <- )]            {prev}
+//	[( Skip ahead )]    {goto payment}
+//	[( -> )]            {next}
+//	[( Submit )]        {submit}
+//	[( Never mind )]    {cancel}
+//
+// A button without an action gets one from its label: "<-", "Back"
+// and "Previous" go back, "Submit" submits, "Cancel" cancels, and
+// anything else moves on to the next page. Moving on from the last
+// page submits the form.
+const (
+	ActNext   = "next"
+	ActPrev   = "prev"
+	ActSubmit = "submit"
+	ActCancel = "cancel"
+	ActGoto   = "goto"
+)
+
+// inferAction is what a button does if it doesn't say.
+func inferAction(label string) string {
+	switch strings.ToLower(label) {
+	case "<-", "back", "previous":
+		return ActPrev
+	case "submit":
+		return ActSubmit
+	case "cancel":
+		return ActCancel
+	}
+
+	return ActNext
+}
+
+// resolveActions gives every button an action, and checks that the
+// ones that go to a page have somewhere to go.
+func resolveActions(root *Node) (errs ParseErrors) {
+	ids := root.index()
+
+	var walk func(*Node)
+	walk = func(n *Node) {
+		for _, kid := range n.Children {
+			if kid.Kind == NButton {
+				if kid.Attrs["action"] == "" {
+					kid.Attrs["action"] = inferAction(kid.Text)
+				}
+
+				if kid.Attrs["action"] == ActGoto {
+					if p, ok := ids[kid.Attrs["target"]]; !ok || p.Kind != NPage {
+						errs = append(errs, nodeError(kid, "there's no page \"%s\" to go to", kid.Attrs["target"]))
+					}
+				}
+			}
+
+			walk(kid)
+		}
+	}
+
+	walk(root)
+	return errs
+}
+
+// Pages returns the pages of a form. A form without any is one page,
+// the form itself.
+func (n *Node) Pages() (ret []*Node) {
+	for _, kid := range n.Children {
+		if kid.Kind == NPage {
+			ret = append(ret, kid)
+		}
+	}
+
+	if len(ret) == 0 {
+		ret = []*Node{n}
+	}
+
+	return ret
+}
+
+// Progress is how far someone has got through a form: the page
+// they're on, the pages they came through to get there, and every
+// answer they've given so far.
+type Progress struct {
+	Page    string   `json:"page"`
+	History []string `json:"history"`
+	Answers Answers  `json:"answers"`
+}
+
+// A Step is what happened when a button was pressed. If the page had
+// problems, Errors says what they were and nobody moved.
+type Step struct {
+	Action    string        `json:"action"`
+	Errors    []*FieldError `json:"errors"`
+	Done      bool          `json:"done"`
+	Cancelled bool          `json:"cancelled"`
+}
+
+// Start returns the Progress of someone who's just opened the form.
+func (n *Node) Start() *Progress {
+	p := &Progress{Answers: Answers{}}
+	p.Page = n.CurrentPage(p).ID
+	return p
+}
+
+// CurrentPage is the page someone is on. If that page isn't there
+// anymore (because the form changed, or an answer hid it) they go
+// back to the first page that shows.
+func (n *Node) CurrentPage(p *Progress) *Node {
+	vis := n.visibility(p.Answers)
+	pages := n.Pages()
+
+	for _, pg := range pages {
+		if pg.ID == p.Page && vis.visible(pg) {
+			return pg
+		}
+	}
+
+	for _, pg := range pages {
+		if vis.visible(pg) {
+			return pg
+		}
+	}
+
+	return pages[0]
+}
+
+// nextPage is the first page after page that shows, or nil.
+func (n *Node) nextPage(page *Node, vis *visibility) *Node {
+	pages := n.Pages()
+
+	for i, pg := range pages {
+		if pg != page {
+			continue
+		}
+
+		for _, next := range pages[i+1:] {
+			if vis.visible(next) {
+				return next
+			}
+		}
+	}
+
+	return nil
+}
+
+// pageOf is the page a node is on.
+func (n *Node) pageOf(k *Node) *Node {
+	for ; k != nil; k = k.Parent {
+		if k.Kind == NPage {
+			return k
+		}
+	}
+
+	return n
+}
+
+// Press takes the answers given on the current page and carries out
+// the action of the button pressed there (the ID of a button, or ""
+// for the default of moving on). A button that's hidden, or on
+// another page, or that goes to a page that's hidden, is an error.
+// The page has to validate before anyone moves forward; going back
+// or cancelling doesn't check anything. Submitting validates the
+// whole form, and sends them back to the first page with a problem
+// if it doesn't.
+func (n *Node) Press(p *Progress, button string, answers Answers) (*Step, error) {
+	page := n.CurrentPage(p)
+	p.Page = page.ID
+
+	if p.Answers == nil {
+		p.Answers = Answers{}
+	}
+
+	for _, f := range page.Fields() {
+		v, ok := answers[f.ID]
+
+		switch {
+		case ok && v != nil:
+			p.Answers[f.ID] = v
+		case !ok && f.Kind == NFileField:
+			// a browser can't put a file back in the box, so an
+			// upload that isn't sent again is kept; send null to
+			// take it back
+		default:
+			delete(p.Answers, f.ID)
+		}
+	}
+
+	p.Answers = n.Compute(p.Answers)
+
+	st := &Step{Action: ActNext}
+	target := ""
+
+	vis := n.visibility(p.Answers)
+
+	if button != "" {
+		// a button can be anywhere on the page, or outside all the
+		// pages, where it goes with every one of them
+		b := n.index()[button]
+		if b == nil || b.Kind != NButton || !vis.visible(b) {
+			return nil, fmt.Errorf("there's no button \"%s\" on this page", button)
+		}
+
+		if on := n.pageOf(b); on != page && on != n {
+			return nil, fmt.Errorf("there's no button \"%s\" on this page", button)
+		}
+
+		st.Action, target = b.Attrs["action"], b.Attrs["target"]
+	}
+
+	// a page the answers so far hide can't be gone to
+	if st.Action == ActGoto {
+		if pg := n.index()[target]; pg == nil || pg.Kind != NPage || !vis.visible(pg) {
+			return nil, fmt.Errorf("page \"%s\" isn't part of the form with these answers", target)
+		}
+	}
+
+	next := n.nextPage(page, vis)
+	if st.Action == ActNext && next == nil {
+		st.Action = ActSubmit
+	}
+
+	switch st.Action {
+	case ActCancel:
+		st.Cancelled = true
+		return st, nil
+
+	case ActPrev:
+		if l := len(p.History); l > 0 {
+			p.Page = p.History[l-1]
+			p.History = p.History[:l-1]
+		}
+		return st, nil
+	}
+
+	if st.Errors = n.ValidatePage(page, p.Answers); len(st.Errors) > 0 {
+		return st, nil
+	}
+
+	switch st.Action {
+	case ActNext:
+		p.History = append(p.History, page.ID)
+		p.Page = next.ID
+
+	case ActGoto:
+		p.History = append(p.History, page.ID)
+		p.Page = target
+
+	case ActSubmit:
+		if st.Errors = n.Validate(p.Answers); len(st.Errors) > 0 {
+			if f, ok := n.named(st.Errors[0].Field); ok {
+				if pg := n.pageOf(f); pg.ID != page.ID {
+					p.History = append(p.History, page.ID)
+					p.Page = pg.ID
+				}
+			}
+		} else {
+			st.Done = true
+		}
+	}
+
+	return st, nil
+}
@@ -0,0 +1,229 @@
+package formaldehyd
+
+import (
+	"strings"
+	"testing"
+)
+
+const wizardForm = `
+You
+---
+
+Name [          ] {required}
+#ship
+Ship it [ ]
+
+[( Straight to the end )] {goto end}
+[( -> )]
+
+~ship~~~~~~~~~~~
+
+Shipping
+--------
+
+Address [                    ] {required}
+
+[( <- )]
+[( Next )]
+
+~~~~~~~~~~~~~~~~
+
+#end
+End
+---
+
+Comments [                    ]
+
+[( Back )]
+[( Submit )]
+[( Forget it )] {cancel}
+`
+
+func TestButtonActions(t *testing.T) {
+	n, err := Parse([]byte(wizardForm))
+	ok(t, err)
+
+	actions := map[string]string{}
+	for _, id := range []string{"you.straight-to-the-end", "you.straight-to-the-end-button-1",
+		"shipping.address-button-1", "shipping.next", "end.back", "end.submit", "end.forget-it"} {
+		b := n.index()[id]
+		if b == nil {
+			t.Fatalf("no button %s:\n%s", id, n)
+		}
+		actions[id] = b.Attrs["action"]
+	}
+
+	for id, want := range map[string]string{
+		"you.straight-to-the-end":          ActGoto,
+		"you.straight-to-the-end-button-1": ActNext,
+		"shipping.address-button-1":        ActPrev,
+		"shipping.next":                    ActNext,
+		"end.back":                         ActPrev,
+		"end.submit":                       ActSubmit,
+		"end.forget-it":                    ActCancel,
+	} {
+		if actions[id] != want {
+			t.Errorf("expected %s to %s, got %s", id, want, actions[id])
+		}
+	}
+
+	roundTrip(t, []byte(wizardForm))
+
+	for src, want := range map[string]string{
+		"Page\n----\n\n[( Go )] {goto nowhere}\n": `there's no page "nowhere"`,
+		"Page\n----\n\n[( Go )] {next, prev}\n":   "already does next",
+		"Page\n----\n\n[( Go )] {goto}\n":         "goto needs the id of a page",
+		"Page\n----\n\nName [    ] {next}\n":      `"next" doesn't apply`,
+	} {
+		_, err := Parse([]byte(src))
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%q: expected %q, got %v", src, want, err)
+		}
+	}
+}
+
+func TestWizard(t *testing.T) {
+	n, err := Parse([]byte(wizardForm))
+	ok(t, err)
+
+	p := n.Start()
+	if p.Page != "you" {
+		t.Fatalf("expected to start on the first page, got %s", p.Page)
+	}
+
+	step := func(button string, answers Answers, page string) *Step {
+		t.Helper()
+
+		st, err := n.Press(p, button, answers)
+		ok(t, err)
+
+		if p.Page != page {
+			t.Fatalf("expected to be on %s after %s, got %s (%v)", page, button, p.Page, st.Errors)
+		}
+
+		return st
+	}
+
+	// can't move on without a name
+	if st := step("", Answers{}, "you"); len(st.Errors) != 1 {
+		t.Fatalf("expected an error, got %v", st.Errors)
+	}
+
+	// without shipping, the shipping page is skipped
+	step("", Answers{"you.name": "alice", "ship": false}, "end")
+	step("end.back", Answers{"end.comments": "hi"}, "you")
+
+	step("you.straight-to-the-end-button-1", Answers{"you.name": "alice", "ship": true}, "shipping")
+	step("shipping.address-button-1", Answers{}, "you")
+	step("you.straight-to-the-end", Answers{"you.name": "alice", "ship": true}, "end")
+
+	// submitting checks everything, and goes back to the problem
+	st := step("end.submit", Answers{}, "shipping")
+	if st.Done || len(st.Errors) != 1 || st.Errors[0].Field != "shipping.address" {
+		t.Fatalf("expected the address to be missing, got %+v", st)
+	}
+
+	step("shipping.next", Answers{"shipping.address": "1 Main St"}, "end")
+
+	st = step("end.submit", Answers{"end.comments": "thanks"}, "end")
+	if !st.Done || len(st.Errors) != 0 {
+		t.Fatalf("expected to be done, got %+v", st)
+	}
+
+	if p.Answers["you.name"] != "alice" || p.Answers["shipping.address"] != "1 Main St" || p.Answers["end.comments"] != "thanks" {
+		t.Fatalf("answers weren't kept between pages: %v", p.Answers)
+	}
+
+	if st := step("end.forget-it", Answers{}, "end"); !st.Cancelled {
+		t.Fatalf("expected to be cancelled")
+	}
+
+	if _, err := n.Press(p, "you.straight-to-the-end-button-1", Answers{}); err == nil {
+		t.Fatalf("expected an error for a button on another page")
+	}
+}
+
+func TestWizardButtons(t *testing.T) {
+	n, err := Parse([]byte(`
+[( Start over )] {goto you}
+
+You
+---
+
+Name [          ]
+#ship
+Ship it [ ]
+
+~ ship ~~~~~~~~~~~~~~~~~~~
+[( Ship now )] {goto shipping}
+~~~~~~~~~~~~~~~~~~~~~~~~~~
+
+[( Skip ahead )] {goto shipping}
+
+~ ship ~~~~~~~~~~~~~~~~~~~
+
+#shipping
+Shipping
+--------
+
+Address [                    ]
+
+~~~~~~~~~~~~~~~~~~~~~~~~~~
+`))
+	ok(t, err)
+
+	p := n.Start()
+
+	// a button in a ~ block on the page is on the page, once it shows
+	if _, err := n.Press(p, "you.ship-now", Answers{"ship": false}); err == nil {
+		t.Fatalf("expected an error for a hidden button")
+	}
+
+	st, err := n.Press(p, "you.ship-now", Answers{"ship": true})
+	ok(t, err)
+	if p.Page != "shipping" {
+		t.Fatalf("expected to go to shipping, got %s (%v)", p.Page, st.Errors)
+	}
+
+	// a button before the first page goes with every page
+	_, err = n.Press(p, "start-over", Answers{})
+	ok(t, err)
+	if p.Page != "you" {
+		t.Fatalf("expected to start over, got %s", p.Page)
+	}
+
+	// and nobody goes to a page their answers hide
+	if _, err := n.Press(p, "you.skip-ahead", Answers{"ship": false}); err == nil || !strings.Contains(err.Error(), "isn't part of the form") {
+		t.Fatalf("expected an error going to a hidden page, got %v", err)
+	}
+
+	if p.Page != "you" {
+		t.Fatalf("expected to stay put, got %s", p.Page)
+	}
+}
+
+func TestWizardSubmitRow(t *testing.T) {
+	n, err := Parse([]byte(`
+Family
+------
+
++ Kids +++++++++++
+Name [          ] {required}
+++++++++++++++++++
+
+End
+---
+
+[( Submit )]
+`))
+	ok(t, err)
+
+	// a problem in a row sends them back to the page with the repeat
+	p := &Progress{Page: "end", Answers: Answers{"family.kids": []interface{}{Answers{"name": ""}}}}
+	st, err := n.Press(p, "end.submit", Answers{})
+	ok(t, err)
+
+	if len(st.Errors) != 1 || st.Errors[0].Field != "family.kids[0].name" || p.Page != "family" {
+		t.Fatalf("expected to go back to the kids, got %s with %+v", p.Page, st.Errors)
+	}
+}